@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/deployotron/internal/container/docker"
+	"github.com/aybabtme/deployotron/internal/container/osprocess"
+	"github.com/aybabtme/deployotron/internal/rpc"
+)
+
+const (
+	appName = "agentctl"
+)
+
+const usage = `usage: agentctl [flags] <command> [flags]
+
+commands:
+  list                 list the processes the agent runs
+  tail <process>       print the last lines a process wrote
+  restart <process>    restart a process
+  stop <process>       stop a process
+
+flags:
+`
+
+// agentctl controls the agent of this box over its control socket, whether a
+// supervisor is reachable or not.
+func main() {
+	socket := flag.String("socket", "/var/run/agentd.sock", "path to the control socket of the agent")
+	callTimeout := flag.Duration("call-timeout", time.Minute, "how long the agent has to carry out the command, none if 0")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	args := flag.Args()
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+
+	var run func(context.Context, rpc.RemoteAgent) error
+	switch args[0] {
+	case "list":
+		_ = fs.Parse(args[1:])
+		run = list
+	case "tail":
+		lines := fs.Int("n", 10, "how many of the last lines to print")
+		follow := fs.Bool("f", false, "print the lines the process writes next, until it's stopped")
+		_ = fs.Parse(args[1:])
+		id := processArg(fs)
+		if *follow {
+			*callTimeout = 0 // until the process is stopped
+		}
+		run = func(ctx context.Context, ag rpc.RemoteAgent) error { return tail(ctx, ag, id, *lines, *follow) }
+	case "restart":
+		timeout := fs.Duration("timeout", 10*time.Second, "how long the process has to stop before it's killed")
+		_ = fs.Parse(args[1:])
+		id := processArg(fs)
+		run = func(ctx context.Context, ag rpc.RemoteAgent) error {
+			_, err := ag.RestartProcess(ctx, &rpc.RestartProcessReq{
+				ProcessID: id,
+				Policy:    agent.PolicySpec{StopTimeout: *timeout},
+			})
+			return err
+		}
+	case "stop":
+		timeout := fs.Duration("timeout", 10*time.Second, "how long the process has to stop before it's killed")
+		_ = fs.Parse(args[1:])
+		id := processArg(fs)
+		run = func(ctx context.Context, ag rpc.RemoteAgent) error {
+			_, err := ag.StopProcess(ctx, &rpc.StopProcessReq{ProcessID: id, Timeout: *timeout})
+			return err
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ag, err := connect(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: can't connect to agent: %v\n", appName, err)
+		os.Exit(1)
+	}
+	defer ag.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	if *callTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *callTimeout)
+	}
+	defer cancel()
+	if err := run(ctx, ag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %v\n", appName, args[0], err)
+		os.Exit(1)
+	}
+}
+
+func processArg(fs *flag.FlagSet) container.ProcessID {
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [flags] <process>\n", appName, fs.Name())
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	return container.ProcessID(fs.Arg(0))
+}
+
+// connect to the agent listening on a control socket, which greets us as it
+// would a supervisor.
+func connect(socket string) (rpc.RemoteAgent, error) {
+	cc, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	link, err := rpc.Greet(cc)
+	if err != nil {
+		_ = cc.Close()
+		return nil, err
+	}
+	provider, err := providerOf(link.Hello().Backend)
+	if err != nil {
+		_ = link.Close()
+		return nil, err
+	}
+	return rpc.RepresentAgent(link, provider)
+}
+
+// providerOf names programs the way the backend of an agent does.
+func providerOf(backend string) (container.ProgramProvider, error) {
+	switch backend {
+	case osprocess.Backend:
+		return osprocess.Provider, nil
+	case docker.Backend:
+		return docker.Provider, nil
+	}
+	return nil, fmt.Errorf("agent has unknown backend %q", backend)
+}
+
+func list(ctx context.Context, ag rpc.RemoteAgent) error {
+	res, err := ag.ListAll(ctx, &rpc.ListAllReq{})
+	if err != nil {
+		return err
+	}
+	programs := make([]string, 0, len(res.Running))
+	for id := range res.Running {
+		programs = append(programs, string(id))
+	}
+	sort.Strings(programs)
+	for _, prgm := range programs {
+		fmt.Println(prgm)
+		for _, proc := range res.Running[container.ProgramID(prgm)] {
+			fmt.Printf("  %s\n", proc)
+		}
+	}
+	return nil
+}
+
+func tail(ctx context.Context, ag rpc.RemoteAgent, id container.ProcessID, lines int, follow bool) error {
+	stream, err := ag.TailLogs(ctx, &rpc.TailLogsReq{ProcessID: id, Lines: lines, Follow: follow})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	var last uint64
+	for {
+		line, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if last != 0 && line.Seq > last+1 {
+			fmt.Fprintf(os.Stderr, "%s: missed %d lines\n", appName, line.Seq-last-1)
+		}
+		last = line.Seq
+		out := os.Stdout
+		if line.Stream == "stderr" {
+			out = os.Stderr
+		}
+		fmt.Fprintln(out, line.Text)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container/docker"
+	"github.com/aybabtme/deployotron/internal/container/osprocess"
+	"github.com/aybabtme/deployotron/internal/rpc"
+)
+
+// serve an agent with a backend on a control socket.
+func serve(t *testing.T, backend string) string {
+	t.Helper()
+	client := osprocess.New(osprocess.NopInstaller())
+	session, err := rpc.NewSession(agent.New(client), client, rpc.Hello{Name: "box", Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "agentd.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+		session.Close()
+	})
+	go func() {
+		for {
+			cc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = session.Operate(cc) }()
+		}
+	}()
+	return socket
+}
+
+func TestConnect(t *testing.T) {
+	for _, backend := range []string{osprocess.Backend, docker.Backend} {
+		t.Run(backend, func(t *testing.T) {
+			ag, err := connect(serve(t, backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ag.Close()
+			if _, err := ag.ListAll(context.Background(), &rpc.ListAllReq{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if _, err := connect(serve(t, "abacus")); err == nil {
+		t.Fatal("want an error connecting to an agent with an unknown backend")
+	}
+}
+
+func TestProviderOf(t *testing.T) {
+	tests := []struct {
+		backend string
+		want    string
+	}{
+		{osprocess.Backend, "osprocess.program.app"},
+		{docker.Backend, "docker.program.app"},
+	}
+	for _, tt := range tests {
+		provider, err := providerOf(tt.backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(provider.ProgramID("app")); got != tt.want {
+			t.Errorf("%s: want %q, got %q", tt.backend, tt.want, got)
+		}
+	}
+}
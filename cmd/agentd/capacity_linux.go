@@ -0,0 +1,17 @@
+package main
+
+import (
+	"runtime"
+	"syscall"
+
+	"github.com/aybabtme/deployotron/internal/rpc"
+)
+
+func capacity() rpc.Capacity {
+	c := rpc.Capacity{CPUs: runtime.NumCPU()}
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err == nil {
+		c.MemoryBytes = uint64(info.Totalram) * uint64(info.Unit)
+	}
+	return c
+}
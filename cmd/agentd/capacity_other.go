@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"runtime"
+
+	"github.com/aybabtme/deployotron/internal/rpc"
+)
+
+func capacity() rpc.Capacity {
+	return rpc.Capacity{CPUs: runtime.NumCPU()}
+}
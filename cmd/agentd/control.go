@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/deployotron/internal/rpc"
+	"github.com/aybabtme/log"
+)
+
+// Operators on the box control the agent over a Unix socket, whether a
+// supervisor is reachable or not. Only who can write to the socket can
+// connect, and they can make any call.
+const controlSocketMode = 0600
+
+// listenControl listens on a Unix socket, replacing the socket of an agent
+// that's gone.
+func listenControl(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if cc, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = cc.Close()
+			return nil, fmt.Errorf("another agent is listening on %q", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale control socket: %v", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, controlSocketMode); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("restricting access to control socket: %v", err)
+	}
+	return l, nil
+}
+
+// serveControl operates the agent for every local client, each in its own
+// session.
+func serveControl(ll *log.Log, l net.Listener, ag *agent.Agent, provider container.ProgramProvider, hello rpc.Hello) {
+	ll.Info("serving local control socket")
+	for {
+		cc, err := l.Accept()
+		if err != nil {
+			ll.Err(err).Info("can't accept")
+			return
+		}
+		go func(cc net.Conn) {
+			defer cc.Close()
+			session, err := rpc.NewSession(ag, provider, hello)
+			if err != nil {
+				ll.Err(err).Error("can't start control session")
+				return
+			}
+			defer session.Close()
+			if err := session.Operate(cc); err != nil {
+				ll.Err(err).Debug("control session ended")
+			}
+		}(cc)
+	}
+}
@@ -1,23 +1,51 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/backoff"
 	"github.com/aybabtme/deployotron/internal/container/osprocess"
+	"github.com/aybabtme/deployotron/internal/pki"
 	"github.com/aybabtme/deployotron/internal/rpc"
+	"github.com/aybabtme/deployotron/internal/secret"
 
 	"github.com/aybabtme/log"
 )
 
 const (
 	appName = "agentd"
+
+	// a link that lasted this long was working, and is worth resuming right
+	// away when it breaks
+	stableLink = 30 * time.Second
 )
 
+// version is set at link time.
+var version = "dev"
+
 func main() {
-	supervisord := flag.String("supervisord", "127.0.0.1:1337", "address where the supervisor can be reached")
+	name := flag.String("name", "", "name of this agent, defaults to the subject of its certificate or the hostname")
+	supervisord := flag.String("supervisord", "127.0.0.1:1337", "comma separated addresses where supervisors can be reached, tried in turn")
+	secretsPath := flag.String("secrets", "", "path to an encrypted secret store, see secretctl")
+	secretsKey := flag.String("secrets-key", "", "path to the master key of the secret store")
+	configRoot := flag.String("config-root", "", "directory where config files are rendered, should be a tmpfs")
+	labelList := flag.String("labels", "", "comma separated key=value labels describing this agent")
+	stateDir := flag.String("state-dir", "", "directory where the agent persists its schedules")
+	tlsCA := flag.String("tls-ca", "", "path to the CA certificate supervisors are signed by")
+	tlsCert := flag.String("tls-cert", "", "path to the certificate of this agent, its subject is the agent's name")
+	tlsKey := flag.String("tls-key", "", "path to the private key of this agent")
+	aclPath := flag.String("acl", "", "path to the JSON ACL giving supervisors their role, any supervisor can make any call without one")
+	auditPath := flag.String("audit-log", "", "path to the log of the calls the ACL denied")
+	codecList := flag.String("codecs", "", "comma separated codecs offered to supervisors by order of preference, defaults to all known codecs")
+	controlSocket := flag.String("control-socket", "/var/run/agentd.sock", "path to the Unix socket agentctl controls the agent over, none if empty")
 	flag.Parse()
 
 	policy := agent.PolicyAllAtOnce()
@@ -31,17 +59,164 @@ func main() {
 	client := osprocess.New(osprocess.NopInstaller())
 	// client = container.Log(client, log.KV("container", "osprocess"))
 
-	ag := agent.New(client)
+	labels, err := parseLabels(*labelList)
+	if err != nil {
+		ll.Err(err).Fatal("invalid labels")
+	}
+	opts := []agent.Option{agent.WithLabels(labels)}
+	if *configRoot != "" {
+		opts = append(opts, agent.WithConfigRoot(*configRoot))
+	}
+	if *stateDir != "" {
+		opts = append(opts, agent.WithStateDir(*stateDir))
+	}
+	if *secretsPath != "" {
+		store, err := openSecrets(*secretsPath, *secretsKey)
+		if err != nil {
+			ll.Err(err).Fatal("can't open secret store")
+		}
+		opts = append(opts, agent.WithSecrets(store))
+	}
+
+	var tlsConfig *tls.Config
+	if *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+		tlsConfig, err = pki.ClientConfig(*tlsCA, *tlsCert, *tlsKey)
+		if err != nil {
+			ll.Err(err).Fatal("can't load TLS configuration")
+		}
+		certName, err := pki.CertIdentity(tlsConfig.Certificates[0])
+		if err != nil {
+			ll.Err(err).Fatal("can't identify agent")
+		}
+		if *name != "" && *name != certName {
+			ll.KV("agent.name", *name).KV("cert.name", certName).Fatal("agent name must match the subject of its certificate")
+		}
+		*name = certName
+	} else {
+		ll.Info("TLS isn't configured, connecting to supervisord in plaintext")
+	}
+	if *name == "" {
+		if *name, err = os.Hostname(); err != nil {
+			ll.Err(err).Fatal("can't name agent after its hostname")
+		}
+	}
+	ll = ll.KV("agent.name", *name)
+
+	hello := rpc.Hello{
+		Name:     *name,
+		Labels:   labels,
+		Backend:  osprocess.Backend,
+		Version:  version,
+		Capacity: capacity(),
+	}
+	if *codecList != "" {
+		hello.Codecs = strings.Split(*codecList, ",")
+	}
 
-	for {
-		cc, err := net.DialTimeout("tcp", *supervisord, 10*time.Second)
+	var sessionOpts []rpc.SessionOption
+	if *aclPath != "" {
+		acl, err := rpc.LoadACL(*aclPath)
+		if err != nil {
+			ll.Err(err).Fatal("can't load ACL")
+		}
+		var audit io.Writer
+		if *auditPath != "" {
+			f, err := os.OpenFile(*auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				ll.Err(err).Fatal("can't open audit log")
+			}
+			defer f.Close()
+			audit = f
+		}
+		auth, err := rpc.NewAuthorizer(acl, audit)
 		if err != nil {
-			ll.Err(err).Error("can't dial supervisord")
+			ll.Err(err).Fatal("invalid ACL")
+		}
+		sessionOpts = append(sessionOpts, rpc.WithAuthorizer(auth))
+	} else {
+		ll.Info("no ACL is configured, supervisors can make any call")
+	}
+
+	ag := agent.New(client, opts...)
+	session, err := rpc.NewSession(ag, client, hello, sessionOpts...)
+	if err != nil {
+		ll.Err(err).Fatal("can't start RPC session")
+	}
+	defer session.Close()
+
+	if *controlSocket != "" {
+		ll := ll.KV("control.socket", *controlSocket)
+		if l, err := listenControl(*controlSocket); err != nil {
+			ll.Err(err).Error("can't listen on control socket")
+		} else {
+			defer l.Close()
+			go serveControl(ll, l, ag, client, hello)
+		}
+	}
+
+	addrs := strings.Split(*supervisord, ",")
+	retry := backoff.Backoff{Min: 500 * time.Millisecond, Max: 30 * time.Second}
+	for i := 0; ; i++ {
+		addr := addrs[i%len(addrs)]
+		ll := ll.KV("supervisord", addr)
+
+		connected := time.Now()
+		if err := operate(session, addr, tlsConfig); err != nil {
+			ll.Err(err).Error("disconnected from supervisord")
+		}
+		if time.Since(connected) > stableLink {
+			// reconnect to the same supervisor right away
+			retry.Reset()
+			i--
 			continue
 		}
+		if (i+1)%len(addrs) == 0 {
+			wait := retry.Next()
+			ll.KV("wait", wait).Info("no supervisord is reachable, backing off")
+			time.Sleep(wait)
+		}
+	}
+}
 
-		if err := rpc.OperateAgent(ag, client, cc); err != nil {
-			ll.Err(err).Error("can't operate agent over RPC")
+func operate(session *rpc.Session, addr string, tlsConfig *tls.Config) error {
+	cc, err := dial(addr, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("can't dial: %v", err)
+	}
+	defer cc.Close()
+	return session.Operate(cc)
+}
+
+func dial(addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if tlsConfig == nil {
+		return dialer.Dial("tcp", addr)
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+}
+
+func openSecrets(path, keyPath string) (*secret.Store, error) {
+	if keyPath == "" {
+		return nil, fmt.Errorf("a master key is required to open the secret store")
+	}
+	key, err := secret.ReadMasterKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Open(path, key)
+}
+
+func parseLabels(list string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if list == "" {
+		return labels, nil
+	}
+	for _, kv := range strings.Split(list, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("label %q isn't of the form key=value", kv)
 		}
+		labels[parts[0]] = parts[1]
 	}
+	return labels, nil
 }
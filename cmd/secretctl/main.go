@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aybabtme/deployotron/internal/secret"
+	"github.com/aybabtme/log"
+)
+
+const (
+	appName = "secretctl"
+)
+
+func main() {
+	storePath := flag.String("store", "secrets.db", "path to the encrypted secret store")
+	keyPath := flag.String("key", "secrets.key", "path to the master key of the store")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] genkey|ls|put <name>|rm <name>\n", appName)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ll := log.KV("app", appName).KV("store", *storePath)
+
+	switch flag.Arg(0) {
+	case "genkey":
+		if err := genKey(*keyPath); err != nil {
+			ll.Err(err).Fatal("can't generate master key")
+		}
+		return
+	case "ls", "put", "rm":
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	key, err := secret.ReadMasterKey(*keyPath)
+	if err != nil {
+		ll.Err(err).Fatal("can't read master key")
+	}
+	store, err := secret.Open(*storePath, key)
+	if err != nil {
+		ll.Err(err).Fatal("can't open secret store")
+	}
+
+	switch cmd, name := flag.Arg(0), flag.Arg(1); cmd {
+	case "ls":
+		for _, name := range store.Names() {
+			fmt.Println(name)
+		}
+	case "put":
+		// read the value from stdin so it doesn't end up in shell history
+		value, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			ll.Err(err).Fatal("can't read secret value from stdin")
+		}
+		if err := store.Put(name, value); err != nil {
+			ll.Err(err).Fatal("can't put secret")
+		}
+	case "rm":
+		if err := store.Remove(name); err != nil {
+			ll.Err(err).Fatal("can't remove secret")
+		}
+	}
+}
+
+func genKey(path string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
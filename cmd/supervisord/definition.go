@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/supervisor"
+	"github.com/aybabtme/log"
+)
+
+// reloadDefinition redefines what the supervisor applies when it's told to
+// with SIGHUP, or when the definition file changed, until done is closed. A
+// definition that isn't valid is ignored, and the supervisor carries on with
+// the previous one.
+func reloadDefinition(ll *log.Log, redefine func(supervisor.Definition), path string, poll time.Duration, done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changes <-chan time.Time
+	if poll > 0 {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		changes = ticker.C
+	}
+	last, _ := os.Stat(path)
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			ll.Info("told to reload definition")
+		case <-changes:
+			fi, err := os.Stat(path)
+			if err != nil || !changed(last, fi) {
+				continue // it may be in the middle of being replaced
+			}
+			ll.Info("definition file changed")
+		}
+		last, _ = os.Stat(path)
+		dfn, err := supervisor.LoadDefinition(path)
+		if err != nil {
+			ll.Err(err).Error("keeping the previous definition")
+			continue
+		}
+		redefine(dfn)
+		ll.Info("reloaded definition")
+	}
+}
+
+func changed(last, fi os.FileInfo) bool {
+	return last == nil || !fi.ModTime().Equal(last.ModTime()) || fi.Size() != last.Size()
+}
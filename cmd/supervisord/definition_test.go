@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/supervisor"
+	"github.com/aybabtme/log"
+)
+
+func writeDefinition(t *testing.T, path string, agents ...string) {
+	t.Helper()
+	src := "agents:\n"
+	for _, name := range agents {
+		src += fmt.Sprintf("  - %s\n", name)
+	}
+	if err := ioutil.WriteFile(path, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadDefinition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "definition.yaml")
+	writeDefinition(t, path, "a")
+
+	redefined := make(chan supervisor.Definition, 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		reloadDefinition(log.KV("test", t.Name()), func(dfn supervisor.Definition) { redefined <- dfn }, path, 5*time.Millisecond, done)
+		close(stopped)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	expect := func(why string, agents ...string) {
+		t.Helper()
+		select {
+		case dfn := <-redefined:
+			if len(dfn.Agents) != len(agents) {
+				t.Fatalf("%s: want agents %v, got %v", why, agents, dfn.Agents)
+			}
+			for i, name := range agents {
+				if string(dfn.Agents[i]) != name {
+					t.Fatalf("%s: want agents %v, got %v", why, agents, dfn.Agents)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: want the definition reloaded", why)
+		}
+	}
+	expectNothing := func(why string) {
+		t.Helper()
+		select {
+		case dfn := <-redefined:
+			t.Fatalf("%s: want the definition kept, got %+v", why, dfn)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	expectNothing("unchanged")
+
+	writeDefinition(t, path, "a", "b")
+	expect("changed", "a", "b")
+
+	if err := ioutil.WriteFile(path, []byte("agents: [a, a]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectNothing("invalid")
+
+	writeDefinition(t, path, "c")
+	expect("fixed", "c")
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	expect("hung up", "c")
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"crypto/tls"
+	"flag"
+	"io/ioutil"
 	"net"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/aybabtme/deployotron/internal/container/osprocess"
+	"github.com/aybabtme/deployotron/internal/pki"
 	"github.com/aybabtme/deployotron/internal/rpc"
+	"github.com/aybabtme/deployotron/internal/supervisor"
 	"github.com/aybabtme/log"
 )
 
@@ -16,56 +21,61 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "pki" {
+		pkiMain(os.Args[2:])
+		return
+	}
+
+	listen := flag.String("listen", ":1337", "address where agents connect")
+	tlsCA := flag.String("tls-ca", "", "path to the CA certificate agents are signed by")
+	tlsCert := flag.String("tls-cert", "", "path to the certificate of this supervisor")
+	tlsKey := flag.String("tls-key", "", "path to the private key of this supervisor")
+	tokenFile := flag.String("token-file", "", "path to a file holding the bearer token presented to agents")
+	dfnPath := flag.String("definition", "definition.yaml", "path to the YAML or JSON definition of what agents run, reloaded on SIGHUP")
+	dfnPoll := flag.Duration("definition-poll", 5*time.Second, "how often the definition is reloaded if its file changed, never if 0")
+	flag.Parse()
+
 	ll := log.KV("app", appName)
 	ll.Info("starting")
 
-	l, err := net.Listen("tcp", ":1337")
+	dfn, err := supervisor.LoadDefinition(*dfnPath)
 	if err != nil {
-		ll.Err(err).Fatal("can't listen")
+		ll.Err(err).Fatal("can't load definition")
 	}
-	defer l.Close()
-
-	acceptAgents(ll.KV("listen.addr", l.Addr().String()), l)
-}
 
-func acceptAgents(ll *log.Log, l net.Listener) {
-	ll.Info("listening for agents")
-	for {
-		cc, err := l.Accept()
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		ll.Err(err).Fatal("can't listen")
+	}
+	if *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+		tlsConfig, err := pki.ServerConfig(*tlsCA, *tlsCert, *tlsKey)
 		if err != nil {
-			ll.Err(err).Info("can't accept")
-			return
+			ll.Err(err).Fatal("can't load TLS configuration")
 		}
-
-		go handleAgent(ll.KV("agent.addr", cc.RemoteAddr().String()), cc)
+		l = tls.NewListener(l, tlsConfig)
+	} else {
+		ll.Info("TLS isn't configured, agents connect in plaintext")
 	}
-}
-
-func handleAgent(ll *log.Log, cc io.ReadWriteCloser) {
-	defer cc.Close()
-	client := osprocess.New(nil)
-	agent := rpc.RepresentAgent(cc, client)
-
-	for i := 0; ; i++ {
-		ll.Info("starting program")
+	defer l.Close()
 
-		res, err := agent.StartProcess(&rpc.StartProcessReq{
-			ProgramName: fmt.Sprintf("echoer v%d i was told to do this by the supervisor", i),
-		})
+	var opts []rpc.AgentOption
+	if *tokenFile != "" {
+		token, err := ioutil.ReadFile(*tokenFile)
 		if err != nil {
-			ll.Err(err).Error("couldn't send command to remote agent")
-			return
+			ll.Err(err).Fatal("can't read token")
 		}
-		ll.KV("process.id", res.ProcessID).Info("agent is running program")
-
-		time.Sleep(3 * time.Second)
+		opts = append(opts, rpc.WithToken(strings.TrimSpace(string(token))))
+	}
 
-		ll.Info("stopping program")
-		_, err = agent.StopProcess(&rpc.StopProcessReq{ProcessID: res.ProcessID, Timeout: time.Second})
-		if err != nil {
-			ll.Err(err).Error("couldn't send command to remote agent")
-			return
-		}
+	sup, err := supervisor.DefineStack(dfn, osprocess.New(nil), supervisor.WithAgentOptions(opts...))
+	if err != nil {
+		ll.Err(err).Fatal("can't create supervisor")
 	}
+	go reloadDefinition(ll.KV("definition", *dfnPath), sup.Redefine, *dfnPath, *dfnPoll, nil)
 
+	ll = ll.KV("listen.addr", l.Addr().String())
+	ll.Info("listening for agents")
+	if err := sup.Listen(l); err != nil {
+		ll.Err(err).Info("stopped listening")
+	}
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aybabtme/deployotron/internal/pki"
+)
+
+const pkiUsage = `usage: supervisord pki <command> [flags]
+
+commands:
+  init    create a certificate authority
+  issue   issue a certificate signed by the certificate authority
+`
+
+// pkiMain bootstraps a local CA and issues certificates for agents and
+// supervisors.
+func pkiMain(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, pkiUsage)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("pki "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", "pki", "directory holding the certificate authority")
+
+	var err error
+	switch args[0] {
+	case "init":
+		name := fs.String("name", "deployotron CA", "name of the certificate authority")
+		_ = fs.Parse(args[1:])
+		if err = pki.InitCA(*dir, *name); err == nil {
+			fmt.Printf("created certificate authority in %q\n", *dir)
+		}
+	case "issue":
+		name := fs.String("name", "", "name of the agent or supervisor the certificate identifies")
+		roleName := fs.String("role", "agent", "end of the connection the certificate is valid for, agent or supervisor")
+		hosts := fs.String("hosts", "", "comma separated DNS names and IPs a supervisor is reached at")
+		_ = fs.Parse(args[1:])
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "a -name is required")
+			os.Exit(2)
+		}
+		role, perr := pki.ParseRole(*roleName)
+		if perr != nil {
+			fmt.Fprintln(os.Stderr, perr)
+			os.Exit(2)
+		}
+		var hostList []string
+		if *hosts != "" {
+			hostList = strings.Split(*hosts, ",")
+		}
+		var certPath, keyPath string
+		if certPath, keyPath, err = pki.Issue(*dir, *name, role, hostList); err == nil {
+			fmt.Printf("issued %v certificate %q in %q, key in %q\n", role, *name, certPath, keyPath)
+		}
+	default:
+		fmt.Fprint(os.Stderr, pkiUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
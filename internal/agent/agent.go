@@ -1,7 +1,9 @@
 package agent
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
@@ -11,20 +13,60 @@ import (
 
 // An Agent supervises programs.
 type Agent struct {
-	client container.Client
+	client     container.Client
+	secrets    SecretStore
+	configRoot string
+	labels     map[string]string
+	stateDir   string
+	stateMu    sync.Mutex // serializes writes to the state dir
 
 	mu        sync.Mutex
 	instances map[container.ProgramID]map[container.ProcessID]*managedProcess
 	started   map[container.ProcessID]*managedProcess
+	// the steps of a cycle run concurrently while mu is held, so they also
+	// hold booksMu to change instances and started
+	booksMu   sync.Mutex
+	jobs      map[JobID]*job
+	schedules map[string]*scheduled
+
+	// subscriptions have their own lock, since events are emitted while
+	// the main lock is held
+	subsMu sync.Mutex
+	subs   map[*Subscription]struct{}
 }
 
 // New creates an agent that executes programs.
-func New(client container.Client) *Agent {
-	return &Agent{
+func New(client container.Client, opts ...Option) *Agent {
+	ag := &Agent{
 		client:    client,
 		instances: make(map[container.ProgramID]map[container.ProcessID]*managedProcess),
 		started:   make(map[container.ProcessID]*managedProcess),
+		jobs:      make(map[JobID]*job),
+		schedules: make(map[string]*scheduled),
+		subs:      make(map[*Subscription]struct{}),
 	}
+	for _, opt := range opts {
+		opt(ag)
+	}
+	if ag.stateDir != "" {
+		if err := ag.restoreSchedules(); err != nil {
+			ag.handleError(fmt.Errorf("restoring schedules: %v", err))
+		}
+	}
+	return ag
+}
+
+// An Option configures an Agent.
+type Option func(*Agent)
+
+// A SecretStore resolves secrets by name.
+type SecretStore interface {
+	Get(name string) ([]byte, bool)
+}
+
+// WithSecrets lets programs reference secrets held in the store.
+func WithSecrets(store SecretStore) Option {
+	return func(ag *Agent) { ag.secrets = store }
 }
 
 /*
@@ -47,7 +89,7 @@ func (ag *Agent) ListAll() map[container.ProgramID][]container.ProcessID {
 }
 
 // RestartAll restart all programs and their currently instanticated processes.
-func (ag *Agent) RestartAll(policy RestartPolicy) error {
+func (ag *Agent) RestartAll(ctx context.Context, policy RestartPolicy) error {
 	ag.mu.Lock()
 	defer ag.mu.Unlock()
 
@@ -60,14 +102,14 @@ func (ag *Agent) RestartAll(policy RestartPolicy) error {
 			break
 		}
 
-		prgm, ok, err := ag.client.Programs().Get(prgmID)
-		if !ok {
-			panic(fmt.Sprintf("program %v should be present, internal structure is inconsistent: %#v", prgmID, ag.instances))
-		}
+		prgm, ok, err := ag.client.Programs().Get(ctx, prgmID)
 		if err != nil {
 			return fmt.Errorf("restarting all processes, retrieving program %v: %v", prgmID, err)
 		}
-		if err := ag.cycleProcesses(policy, prgm, prgm); err != nil {
+		if !ok {
+			panic(fmt.Sprintf("program %v should be present, internal structure is inconsistent: %#v", prgmID, ag.instances))
+		}
+		if err := ag.cycleProcesses(ctx, policy, prgm, prgm, nil); err != nil {
 			return fmt.Errorf("restarting all processes, cycling program %v: %v", prgmID, err)
 		}
 	}
@@ -76,76 +118,92 @@ func (ag *Agent) RestartAll(policy RestartPolicy) error {
 
 /*
  Process scoped API
+
+ Calls that pull programs, or start or stop processes, give up once their
+ context is done. Processes they were stopping are stopped all the same.
 */
 
 // StartProcess a process running the given program.
-func (ag *Agent) StartProcess(id container.ProgramID) (container.ProcessID, error) {
-	prgm, err := ag.client.Programs().Pull(id)
+func (ag *Agent) StartProcess(ctx context.Context, id container.ProgramID, spec container.Spec) (container.ProcessID, error) {
+	prgm, err := ag.client.Programs().Pull(ctx, id)
 	if err != nil {
 		return "", fmt.Errorf("pulling program: %v", err)
 	}
 	ag.mu.Lock()
 	defer ag.mu.Unlock()
-	return ag.startProcess(prgm)
+	return ag.startProcess(ctx, prgm, spec, ag.freeSlot(id))
 }
 
-func (ag *Agent) startProcess(prgm container.Program) (container.ProcessID, error) {
-	proc, err := ag.client.Processes().Create(prgm)
+func (ag *Agent) startProcess(ctx context.Context, prgm container.Program, spec container.Spec, slot int) (container.ProcessID, error) {
+	cfg, err := ag.processConfig(prgm, spec, slot)
+	if err != nil {
+		return "", fmt.Errorf("configuring process: %w", err)
+	}
+	logs := newProcessLog()
+	cfg.Stdout, cfg.Stderr = logs.writer("stdout"), logs.writer("stderr")
+	proc, err := ag.client.Processes().Create(ctx, prgm, cfg)
 	if err != nil {
+		_ = removeConfig(cfg.ConfigDir)
 		return "", fmt.Errorf("creating process: %v", err)
 	}
-	if err := proc.Start(); err != nil {
+	// the process is removed on failure, along with its secrets, but an
+	// already managed one must be left alone
+	ag.booksMu.Lock()
+	_, managed := ag.started[proc.ID()]
+	ag.booksMu.Unlock()
+	if managed {
+		_ = removeConfig(cfg.ConfigDir)
+		return "", alreadyExists("process is already managed: %v", proc.ID())
+	}
+	if err := proc.Start(ctx); err != nil {
+		ag.discardProcess(proc, cfg.ConfigDir)
 		return "", fmt.Errorf("starting process: %v", err)
 	}
 
-	if _, ok := ag.started[proc.ID()]; ok {
-		return "", fmt.Errorf("process is already managed: %v", proc.ID())
-	}
-	mproc := manage(proc)
+	mproc := manage(ag, proc, spec)
+	mproc.slot = slot
+	mproc.configDir = cfg.ConfigDir
+	mproc.logs = logs
 	ag.recordInstance(mproc)
 	return proc.ID(), nil
 }
 
 // StopProcess stops a running process.
-func (ag *Agent) StopProcess(id container.ProcessID, timeout time.Duration) error {
+func (ag *Agent) StopProcess(ctx context.Context, id container.ProcessID, timeout time.Duration) error {
 	ag.mu.Lock()
 	defer ag.mu.Unlock()
 	mproc, ok := ag.started[id]
 	if !ok {
-		return fmt.Errorf("no such process: %#v", id)
+		return notFound("no such process: %#v", id)
 	}
-	mproc.stop(timeout)
-	ag.dropInstance(mproc)
-	return nil
+	return ag.stopInstance(ctx, mproc, timeout)
 }
 
 // RestartProcess restarts a single process.
-func (ag *Agent) RestartProcess(policy RestartPolicy, id container.ProcessID) error {
+func (ag *Agent) RestartProcess(ctx context.Context, policy RestartPolicy, id container.ProcessID) error {
 	ag.mu.Lock()
 	defer ag.mu.Unlock()
 	mproc, ok := ag.started[id]
 	if !ok {
-		return fmt.Errorf("no such process")
+		return notFound("no such process")
 	}
 
 	stop := func(i int) error {
-		mproc.stop(policy.Timeout())
-		ag.dropInstance(mproc)
-		return nil
+		return ag.stopInstance(ctx, mproc, policy.Timeout())
 	}
 	start := func(i int) error {
-		if _, err := ag.startProcess(mproc.proc.Program()); err != nil {
+		if _, err := ag.startProcess(ctx, mproc.proc.Program(), mproc.spec, mproc.slot); err != nil {
 			return fmt.Errorf("restart failed to start: %v", err)
 		}
 		return nil
 	}
 
-	return policy.Do(1, stop, start)
+	return policy.Do(1, stop, ag.progress(mproc.proc.Program().ID(), 1, start))
 }
 
 // UpgradeProcess upgrades a single process to a new program.
-func (ag *Agent) UpgradeProcess(policy RestartPolicy, id container.ProcessID, to container.ProgramID) error {
-	toPrgm, err := ag.client.Programs().Pull(to)
+func (ag *Agent) UpgradeProcess(ctx context.Context, policy RestartPolicy, id container.ProcessID, to container.ProgramID) error {
+	toPrgm, err := ag.client.Programs().Pull(ctx, to)
 	if err != nil {
 		return fmt.Errorf("can't pull program to upgrade: %v", err)
 	}
@@ -155,22 +213,58 @@ func (ag *Agent) UpgradeProcess(policy RestartPolicy, id container.ProcessID, to
 	defer ag.mu.Unlock()
 	mproc, ok := ag.started[id]
 	if !ok {
-		return fmt.Errorf("no such process")
+		return notFound("no such process")
 	}
 
 	stop := func(i int) error {
-		mproc.stop(policy.Timeout())
-		ag.dropInstance(mproc)
-		return nil
+		return ag.stopInstance(ctx, mproc, policy.Timeout())
 	}
 	start := func(i int) error {
-		if _, err := ag.startProcess(toPrgm); err != nil {
+		if _, err := ag.startProcess(ctx, toPrgm, mproc.spec, mproc.slot); err != nil {
 			return fmt.Errorf("upgrade failed to start: %v", err)
 		}
 		return nil
 	}
 
-	return policy.Do(1, stop, start)
+	return policy.Do(1, stop, ag.progress(to, 1, start))
+}
+
+// SignalProcess sends a signal to a single process. Processes are paused
+// and resumed with PauseProcess and ResumeProcess, not with signals.
+func (ag *Agent) SignalProcess(id container.ProcessID, sig os.Signal) error {
+	if err := container.CheckSignal(sig); err != nil {
+		return invalidArgument("%v", err)
+	}
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mproc, ok := ag.started[id]
+	if !ok {
+		return notFound("no such process: %#v", id)
+	}
+	return mproc.proc.Signal(sig)
+}
+
+// PauseProcess freezes a process without stopping it. A paused process is
+// not restarted if it dies, until it's resumed.
+func (ag *Agent) PauseProcess(id container.ProcessID) error {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mproc, ok := ag.started[id]
+	if !ok {
+		return notFound("no such process: %#v", id)
+	}
+	return mproc.pause()
+}
+
+// ResumeProcess resumes a paused process.
+func (ag *Agent) ResumeProcess(id container.ProcessID) error {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mproc, ok := ag.started[id]
+	if !ok {
+		return notFound("no such process: %#v", id)
+	}
+	return mproc.resume()
 }
 
 /*
@@ -189,42 +283,60 @@ func (ag *Agent) ListProgram(id container.ProgramID) ([]container.ProcessID, err
 }
 
 // StopProgram stops all processes of a program.
-func (ag *Agent) StopProgram(id container.ProgramID, timeout time.Duration) error {
+func (ag *Agent) StopProgram(ctx context.Context, id container.ProgramID, timeout time.Duration) error {
 	ag.mu.Lock()
 	defer ag.mu.Unlock()
 	for _, mproc := range ag.instances[id] {
-		mproc.stop(timeout)
-		ag.dropInstance(mproc)
+		if err := ag.stopInstance(ctx, mproc, timeout); err != nil {
+			return fmt.Errorf("stopping process %v: %w", mproc.proc.ID(), err)
+		}
 	}
 	return nil
 }
 
 // RestartProgram the processes running a program while respecting a policy.
-func (ag *Agent) RestartProgram(policy RestartPolicy, id container.ProgramID) error {
-	prgm, ok, err := ag.client.Programs().Get(id)
+func (ag *Agent) RestartProgram(ctx context.Context, policy RestartPolicy, id container.ProgramID) error {
+	prgm, ok, err := ag.client.Programs().Get(ctx, id)
 	switch {
 	case err != nil:
 		return fmt.Errorf("can't get program to restart: %v", err)
 	case !ok:
-		return fmt.Errorf("program %v isn't present", id)
+		return notFound("program %v isn't present", id)
 	}
 	ag.mu.Lock()
 	defer ag.mu.Unlock()
-	return ag.cycleProcesses(policy, prgm, prgm)
+	return ag.cycleProcesses(ctx, policy, prgm, prgm, nil)
 }
 
 // UpgradeProgram upgrades all instances of a program to another program
 // while respecting the policy.
-func (ag *Agent) UpgradeProgram(policy RestartPolicy, from, to container.ProgramID) error {
-	fromPrgm, ok, err := ag.client.Programs().Get(from)
+func (ag *Agent) UpgradeProgram(ctx context.Context, policy RestartPolicy, from, to container.ProgramID) error {
+	return ag.upgradeProgram(ctx, policy, from, to, nil)
+}
+
+// ReconfigureProgram upgrades all instances of a program like UpgradeProgram,
+// but starts them with a new spec. It can upgrade a program to itself, which
+// only changes the spec of its processes.
+func (ag *Agent) ReconfigureProgram(ctx context.Context, policy RestartPolicy, from, to container.ProgramID, spec container.Spec) error {
+	return ag.upgradeProgram(ctx, policy, from, to, &spec)
+}
+
+func (ag *Agent) upgradeProgram(ctx context.Context, policy RestartPolicy, from, to container.ProgramID, spec *container.Spec) error {
+	if spec != nil {
+		// rather than once processes are stopped
+		if err := validateSpec(*spec); err != nil {
+			return err
+		}
+	}
+	fromPrgm, ok, err := ag.client.Programs().Get(ctx, from)
 	switch {
 	case err != nil:
 		return fmt.Errorf("can't get program to upgrade: %v", err)
 	case !ok:
-		return fmt.Errorf("program %v isn't present, thus cannot be upgraded", from)
+		return notFound("program %v isn't present, thus cannot be upgraded", from)
 	}
 
-	toPrgm, err := ag.client.Programs().Pull(to)
+	toPrgm, err := ag.client.Programs().Pull(ctx, to)
 	if err != nil {
 		return fmt.Errorf("can't pull program to upgrade: %v", err)
 	}
@@ -232,13 +344,91 @@ func (ag *Agent) UpgradeProgram(policy RestartPolicy, from, to container.Program
 	// we pull programs before locking
 	ag.mu.Lock()
 	defer ag.mu.Unlock()
-	return ag.cycleProcesses(policy, fromPrgm, toPrgm)
+	return ag.cycleProcesses(ctx, policy, fromPrgm, toPrgm, spec)
+}
+
+// SignalProgram sends a signal to all processes of a program. Programs are
+// paused and resumed with PauseProgram and ResumeProgram, not with signals.
+func (ag *Agent) SignalProgram(id container.ProgramID, sig os.Signal) error {
+	if err := container.CheckSignal(sig); err != nil {
+		return invalidArgument("%v", err)
+	}
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mprocs, ok := ag.instances[id]
+	if !ok {
+		return notFound("no instance of program %v is running", id)
+	}
+	for _, mproc := range mprocs {
+		if err := mproc.proc.Signal(sig); err != nil {
+			return fmt.Errorf("signaling process %v: %v", mproc.proc.ID(), err)
+		}
+	}
+	return nil
+}
+
+// PauseProgram freezes all processes of a program.
+func (ag *Agent) PauseProgram(id container.ProgramID) error {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mprocs, ok := ag.instances[id]
+	if !ok {
+		return notFound("no instance of program %v is running", id)
+	}
+	for _, mproc := range mprocs {
+		if err := mproc.pause(); err != nil {
+			return fmt.Errorf("pausing process %v: %v", mproc.proc.ID(), err)
+		}
+	}
+	return nil
 }
 
-func (ag *Agent) cycleProcesses(policy RestartPolicy, from, to container.Program) error {
+// ResumeProgram resumes all paused processes of a program.
+func (ag *Agent) ResumeProgram(id container.ProgramID) error {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mprocs, ok := ag.instances[id]
+	if !ok {
+		return notFound("no instance of program %v is running", id)
+	}
+	for _, mproc := range mprocs {
+		if err := mproc.resume(); err != nil {
+			return fmt.Errorf("resuming process %v: %v", mproc.proc.ID(), err)
+		}
+	}
+	return nil
+}
+
+// ReloadProgram reloads all processes of a program in place, by sending them
+// the reload signal of their spec instead of restarting them.
+func (ag *Agent) ReloadProgram(id container.ProgramID) error {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mprocs, ok := ag.instances[id]
+	if !ok {
+		return notFound("no instance of program %v is running", id)
+	}
+	for _, mproc := range mprocs {
+		if mproc.spec.ReloadSignal == "" {
+			return invalidArgument("process %v has no reload signal", mproc.proc.ID())
+		}
+		sig, err := container.ParseSignal(mproc.spec.ReloadSignal)
+		if err != nil {
+			return invalidArgument("process %v has a bad reload signal: %v", mproc.proc.ID(), err)
+		}
+		if err := mproc.proc.Signal(sig); err != nil {
+			return fmt.Errorf("reloading process %v: %v", mproc.proc.ID(), err)
+		}
+	}
+	return nil
+}
+
+// cycleProcesses replaces the processes of a program by processes of
+// another, or the same. They keep their spec unless a new one is given.
+func (ag *Agent) cycleProcesses(ctx context.Context, policy RestartPolicy, from, to container.Program, spec *container.Spec) error {
 	unordered, ok := ag.instances[from.ID()]
 	if !ok {
-		return fmt.Errorf("no instance of program %v is running", from)
+		return notFound("no instance of program %v is running", from)
 	}
 	count := len(unordered)
 	ordered := make([]*managedProcess, 0, count)
@@ -247,19 +437,20 @@ func (ag *Agent) cycleProcesses(policy RestartPolicy, from, to container.Program
 	}
 
 	stop := func(i int) error {
-		proc := ordered[i]
-		proc.stop(policy.Timeout())
-		ag.dropInstance(proc)
-		return nil
+		return ag.stopInstance(ctx, ordered[i], policy.Timeout())
 	}
 	start := func(i int) error {
-		if _, err := ag.startProcess(to); err != nil {
+		next := ordered[i].spec
+		if spec != nil {
+			next = *spec
+		}
+		if _, err := ag.startProcess(ctx, to, next, ordered[i].slot); err != nil {
 			return fmt.Errorf("cycle loop failed to start: %v", err)
 		}
 		return nil
 	}
 
-	return policy.Do(count, stop, start)
+	return policy.Do(count, stop, ag.progress(to.ID(), count, start))
 }
 
 /*
@@ -267,6 +458,8 @@ func (ag *Agent) cycleProcesses(policy RestartPolicy, from, to container.Program
 */
 
 func (ag *Agent) recordInstance(mproc *managedProcess) {
+	ag.booksMu.Lock()
+	defer ag.booksMu.Unlock()
 	prgmID := mproc.proc.Program().ID()
 	procID := mproc.proc.ID()
 	ag.started[procID] = mproc
@@ -276,22 +469,131 @@ func (ag *Agent) recordInstance(mproc *managedProcess) {
 	ag.instances[prgmID][procID] = mproc
 }
 
+// stopInstance stops a process and forgets it. If ctx is done first, the
+// process is forgotten once it's stopped. It must be called with mu held.
+func (ag *Agent) stopInstance(ctx context.Context, mproc *managedProcess, timeout time.Duration) error {
+	if err := mproc.stop(ctx, timeout); err != nil {
+		go func() {
+			<-mproc.stopped
+			ag.mu.Lock()
+			defer ag.mu.Unlock()
+			if ag.started[mproc.proc.ID()] == mproc {
+				ag.dropInstance(mproc)
+			}
+		}()
+		return err
+	}
+	ag.dropInstance(mproc)
+	return nil
+}
+
+// dropInstance forgets a stopped process, and cleans up after it. The clean
+// up isn't bound to the call that stopped it.
 func (ag *Agent) dropInstance(mproc *managedProcess) {
 	prgmID := mproc.proc.Program().ID()
 	procID := mproc.proc.ID()
+	last := ag.forgetInstance(mproc)
+	mproc.logs.close()
+	ctx := context.Background()
+	if err := ag.client.Processes().Remove(ctx, mproc.proc); err != nil {
+		ag.handleError(fmt.Errorf("cleaning up stopped process %v, %v", procID, err))
+	}
+	if err := removeConfig(mproc.configDir); err != nil {
+		ag.handleError(fmt.Errorf("cleaning up config of stopped process %v, %v", procID, err))
+	}
+	if last {
+		if err := ag.client.Programs().Remove(ctx, mproc.proc.Program().ID()); err != nil {
+			ag.handleError(fmt.Errorf("cleaning up no longer used program %v, %v", prgmID, err))
+		}
+	}
+}
+
+// forgetInstance takes a process off the books, and tells if it was the last
+// of its program.
+func (ag *Agent) forgetInstance(mproc *managedProcess) bool {
+	ag.booksMu.Lock()
+	defer ag.booksMu.Unlock()
+	prgmID := mproc.proc.Program().ID()
 	instances, ok := ag.instances[prgmID]
 	if !ok {
 		panic(fmt.Sprintf("can't delete program %v from instances %#v", prgmID, ag.instances))
 	}
+	delete(ag.started, mproc.proc.ID())
+	delete(instances, mproc.proc.ID())
+	if len(instances) > 0 {
+		return false
+	}
+	delete(ag.instances, prgmID)
+	return true
+}
 
-	delete(ag.started, procID)
-	delete(instances, procID)
-	if len(instances) == 0 {
-		delete(ag.instances, prgmID)
-		if err := ag.client.Programs().Remove(mproc.proc.Program().ID()); err != nil {
-			ag.handleError(fmt.Errorf("cleaning up no longer used program %v, %v", prgmID, err))
+// discardProcess cleans up after a process that was created but never got
+// managed.
+func (ag *Agent) discardProcess(proc container.Process, configDir string) {
+	if err := ag.client.Processes().Remove(context.Background(), proc); err != nil {
+		ag.handleError(fmt.Errorf("cleaning up process %v that failed to start, %v", proc.ID(), err))
+	}
+	if err := removeConfig(configDir); err != nil {
+		ag.handleError(fmt.Errorf("cleaning up config of process %v that failed to start, %v", proc.ID(), err))
+	}
+}
+
+// freeSlot returns the lowest slot not used by an instance of the program.
+func (ag *Agent) freeSlot(id container.ProgramID) int {
+	used := make(map[int]bool, len(ag.instances[id]))
+	for _, mproc := range ag.instances[id] {
+		used[mproc.slot] = true
+	}
+	slot := 0
+	for used[slot] {
+		slot++
+	}
+	return slot
+}
+
+// processConfig resolves a spec into what's needed to create a process,
+// rendering its config files for the given slot.
+func (ag *Agent) processConfig(prgm container.Program, spec container.Spec, slot int) (container.ProcessConfig, error) {
+	cfg := container.ProcessConfig{Env: spec.Env, Limits: spec.Limits}
+	if err := validateSpec(spec); err != nil {
+		return cfg, err
+	}
+	for _, ref := range spec.Secrets {
+		if ag.secrets == nil {
+			return cfg, invalidArgument("secret %q is referenced but agent has no secret store", ref.Name)
+		}
+		value, ok := ag.secrets.Get(ref.Name)
+		if !ok {
+			return cfg, notFound("no such secret: %q", ref.Name)
+		}
+		cfg.Secrets = append(cfg.Secrets, container.Secret{SecretRef: ref, Value: value})
+	}
+	dir, err := ag.renderConfig(spec.Files, ag.instance(prgm, spec, slot, cfg.Secrets))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ConfigDir = dir
+	return cfg, nil
+}
+
+// validateSpec tells if processes can be started with a spec, as far as it
+// can be told without starting them.
+func validateSpec(spec container.Spec) error {
+	if err := spec.Limits.Validate(); err != nil {
+		return invalidArgument("invalid limits: %v", err)
+	}
+	if err := container.ValidateSecrets(spec.Secrets); err != nil {
+		return invalidArgument("invalid secrets: %v", err)
+	}
+	if err := spec.KeepAlive.Validate(); err != nil {
+		return invalidArgument("invalid keep-alive policy: %v", err)
+	}
+	if spec.ReloadSignal != "" {
+		if _, err := container.ParseSignal(spec.ReloadSignal); err != nil {
+			return invalidArgument("invalid reload signal: %v", err)
 		}
 	}
+	return nil
 }
 
 func (ag *Agent) handleError(err error) {
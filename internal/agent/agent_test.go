@@ -0,0 +1,365 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+// fakeClient creates processes that run until they're stopped, unless their
+// program is named "exit <code>", in which case they exit right away.
+type fakeClient struct {
+	mu        sync.Mutex
+	next      int
+	sameID    bool  // every process gets the same ID
+	failStart error // returned by every start
+	created   []*fakeProcess
+	removed   []container.ProcessID
+}
+
+func (cl *fakeClient) ProgramID(name string) container.ProgramID {
+	return container.ProgramID("fake.program." + name)
+}
+
+func (cl *fakeClient) Programs() container.ProgramSvc  { return fakePrograms{} }
+func (cl *fakeClient) Processes() container.ProcessSvc { return cl }
+
+func (cl *fakeClient) Create(ctx context.Context, prgm container.Program, cfg container.ProcessConfig) (container.Process, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if !cl.sameID {
+		cl.next++
+	}
+	proc := &fakeProcess{cl: cl, id: container.ProcessID(fmt.Sprintf("fake.process.%d", cl.next)), prgm: prgm, cfg: cfg}
+	cl.created = append(cl.created, proc)
+	return proc, nil
+}
+
+func (cl *fakeClient) Remove(ctx context.Context, proc container.Process) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.removed = append(cl.removed, proc.ID())
+	return nil
+}
+
+func (cl *fakeClient) removedIDs() []container.ProcessID {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return append([]container.ProcessID(nil), cl.removed...)
+}
+
+func (cl *fakeClient) createdProcs() []*fakeProcess {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return append([]*fakeProcess(nil), cl.created...)
+}
+
+type fakePrograms struct{}
+
+func (fakePrograms) Pull(ctx context.Context, id container.ProgramID) (container.Program, error) {
+	return fakeProgram(id), nil
+}
+
+func (fakePrograms) Get(ctx context.Context, id container.ProgramID) (container.Program, bool, error) {
+	return fakeProgram(id), true, nil
+}
+
+func (fakePrograms) Remove(ctx context.Context, id container.ProgramID) error { return nil }
+
+type fakeProgram container.ProgramID
+
+func (prgm fakeProgram) ID() container.ProgramID { return container.ProgramID(prgm) }
+
+// exitCode tells if processes of the program exit right away, and how.
+func (prgm fakeProgram) exitCode() (int, bool) {
+	code, err := strconv.Atoi(strings.TrimPrefix(string(prgm), "fake.program.exit "))
+	return code, err == nil
+}
+
+type fakeProcess struct {
+	cl   *fakeClient
+	id   container.ProcessID
+	prgm container.Program
+	cfg  container.ProcessConfig
+
+	mu      sync.Mutex
+	starts  int
+	exits   chan error // of the current run
+	signals []os.Signal
+}
+
+func (proc *fakeProcess) ID() container.ProcessID    { return proc.id }
+func (proc *fakeProcess) Program() container.Program { return proc.prgm }
+
+func (proc *fakeProcess) Start(ctx context.Context) error {
+	proc.cl.mu.Lock()
+	err := proc.cl.failStart
+	proc.cl.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	proc.starts++
+	proc.exits = make(chan error, 1)
+	if code, ok := proc.prgm.(fakeProgram).exitCode(); ok && code != 0 {
+		proc.exits <- &container.ExitError{Code: code}
+	} else if ok {
+		proc.exits <- nil
+	}
+	return nil
+}
+
+func (proc *fakeProcess) exit(err error) {
+	proc.mu.Lock()
+	exits := proc.exits
+	proc.mu.Unlock()
+	select {
+	case exits <- err:
+	default: // exiting already
+	}
+}
+
+func (proc *fakeProcess) Stop(ctx context.Context, timeout time.Duration) error {
+	proc.exit(&container.ExitError{Code: 143})
+	return nil
+}
+
+func (proc *fakeProcess) Kill() error {
+	proc.exit(&container.ExitError{Code: 137})
+	return nil
+}
+
+func (proc *fakeProcess) Signal(sig os.Signal) error {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	proc.signals = append(proc.signals, sig)
+	return nil
+}
+
+func (proc *fakeProcess) Pause() error  { return nil }
+func (proc *fakeProcess) Resume() error { return nil }
+
+func (proc *fakeProcess) Wait() error {
+	proc.mu.Lock()
+	exits := proc.exits
+	proc.mu.Unlock()
+	return <-exits
+}
+
+func (proc *fakeProcess) startCount() int {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return proc.starts
+}
+
+type fakeSecrets map[string]string
+
+func (store fakeSecrets) Get(name string) ([]byte, bool) {
+	v, ok := store[name]
+	return []byte(v), ok
+}
+
+func newTestAgent(t *testing.T, cl *fakeClient, opts ...Option) *Agent {
+	t.Helper()
+	opts = append([]Option{WithConfigRoot(t.TempDir())}, opts...)
+	ag := New(cl, opts...)
+	t.Cleanup(func() {
+		for id := range ag.ListAll() {
+			_ = ag.StopProgram(context.Background(), id, time.Second)
+		}
+	})
+	return ag
+}
+
+func TestStartProcessCleansUpWhenStartFails(t *testing.T) {
+	cl := &fakeClient{failStart: errors.New("no luck")}
+	root := t.TempDir()
+	ag := newTestAgent(t, cl, WithConfigRoot(root))
+	spec := container.Spec{Files: []container.ConfigFile{{Path: "app.conf", Template: "slot={{.Slot}}"}}}
+
+	_, err := ag.StartProcess(context.Background(), cl.ProgramID("app"), spec)
+	if err == nil {
+		t.Fatal("want an error")
+	}
+	procs := cl.createdProcs()
+	if len(procs) != 1 {
+		t.Fatalf("want 1 process created, got %d", len(procs))
+	}
+	if removed := cl.removedIDs(); len(removed) != 1 || removed[0] != procs[0].ID() {
+		t.Errorf("want process %v removed, got %v", procs[0].ID(), removed)
+	}
+	if left, _ := ioutil.ReadDir(root); len(left) != 0 {
+		t.Errorf("want config removed, %d files are left", len(left))
+	}
+	if running := ag.ListAll(); len(running) != 0 {
+		t.Errorf("want no process managed, got %v", running)
+	}
+}
+
+func TestStartProcessRefusesManagedProcess(t *testing.T) {
+	cl := &fakeClient{sameID: true}
+	ag := newTestAgent(t, cl)
+	id := cl.ProgramID("app")
+
+	if _, err := ag.StartProcess(context.Background(), id, container.Spec{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ag.StartProcess(context.Background(), id, container.Spec{})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("want %v, got %v", ErrAlreadyExists, err)
+	}
+	procs := cl.createdProcs()
+	if starts := procs[1].startCount(); starts != 0 {
+		t.Errorf("want the duplicate never started, it was %d times", starts)
+	}
+	if removed := cl.removedIDs(); len(removed) != 0 {
+		t.Errorf("want the managed process left alone, got %v removed", removed)
+	}
+	if procs := ag.ListAll()[id]; len(procs) != 1 {
+		t.Errorf("want 1 process managed, got %v", procs)
+	}
+}
+
+func TestStartProcessInjectsSecrets(t *testing.T) {
+	store := fakeSecrets{"db": "hunter2", "tls": "---key---"}
+	spec := container.Spec{Secrets: []container.SecretRef{
+		{Name: "db", Env: "DB_PASSWORD"},
+		{Name: "tls", File: "tls/key.pem"},
+	}}
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl, WithSecrets(store))
+
+	if _, err := ag.StartProcess(context.Background(), cl.ProgramID("app"), spec); err != nil {
+		t.Fatal(err)
+	}
+	got := cl.createdProcs()[0].cfg.Secrets
+	if len(got) != 2 {
+		t.Fatalf("want 2 secrets, got %v", got)
+	}
+	for i, ref := range spec.Secrets {
+		if got[i].SecretRef != ref || string(got[i].Value) != store[ref.Name] {
+			t.Errorf("want secret %v to be %v with its value, got %v", i, ref, got[i])
+		}
+	}
+}
+
+func TestStartProcessRejectsBadSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   SecretStore
+		secrets []container.SecretRef
+		want    error
+	}{
+		{"no store", nil, []container.SecretRef{{Name: "db", Env: "DB"}}, ErrInvalidArgument},
+		{"unknown secret", fakeSecrets{}, []container.SecretRef{{Name: "db", Env: "DB"}}, ErrNotFound},
+		{"same file", fakeSecrets{"a": "1", "b": "2"}, []container.SecretRef{{Name: "a", File: "x"}, {Name: "b", File: "./x"}}, ErrInvalidArgument},
+		{"escapes dir", fakeSecrets{"a": "1"}, []container.SecretRef{{Name: "a", File: "../x"}}, ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &fakeClient{}
+			ag := newTestAgent(t, cl, WithSecrets(tt.store))
+			_, err := ag.StartProcess(context.Background(), cl.ProgramID("app"), container.Spec{Secrets: tt.secrets})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, err)
+			}
+			if procs := cl.createdProcs(); len(procs) != 0 {
+				t.Errorf("want no process created, got %d", len(procs))
+			}
+		})
+	}
+}
+
+func TestSignalRefusesJobControl(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	id := cl.ProgramID("app")
+	procID, err := ag.StartProcess(context.Background(), id, container.Spec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sig := range []os.Signal{syscall.SIGSTOP, syscall.SIGCONT, syscall.SIGTSTP} {
+		if err := ag.SignalProcess(procID, sig); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%v: want %v, got %v", sig, ErrInvalidArgument, err)
+		}
+		if err := ag.SignalProgram(id, sig); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%v: want %v, got %v", sig, ErrInvalidArgument, err)
+		}
+	}
+	if err := ag.SignalProcess(procID, syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	proc := cl.createdProcs()[0]
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if len(proc.signals) != 1 || proc.signals[0] != syscall.SIGHUP {
+		t.Errorf("want only SIGHUP sent, got %v", proc.signals)
+	}
+}
+
+func TestReloadProgram(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	reloaded, plain := cl.ProgramID("reloaded"), cl.ProgramID("plain")
+	for i := 0; i < 2; i++ {
+		if _, err := ag.StartProcess(context.Background(), reloaded, container.Spec{ReloadSignal: "usr1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ag.StartProcess(context.Background(), plain, container.Spec{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ag.ReloadProgram(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if err := ag.ReloadProgram(plain); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want %v reloading a program without a reload signal, got %v", ErrInvalidArgument, err)
+	}
+	if err := ag.ReloadProgram(cl.ProgramID("nope")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want %v reloading a program that doesn't run, got %v", ErrNotFound, err)
+	}
+	for _, proc := range cl.createdProcs()[:2] {
+		proc.mu.Lock()
+		if len(proc.signals) != 1 || proc.signals[0] != syscall.SIGUSR1 {
+			t.Errorf("%v: want SIGUSR1 sent, got %v", proc.id, proc.signals)
+		}
+		proc.mu.Unlock()
+	}
+	if _, err := ag.StartProcess(context.Background(), plain, container.Spec{ReloadSignal: "SIGSTOP"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want %v starting with SIGSTOP as reload signal, got %v", ErrInvalidArgument, err)
+	}
+}
+
+func TestUpgradeAllAtOnce(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	ctx := context.Background()
+	const instances = 8
+	for i := 0; i < instances; i++ {
+		if _, err := ag.StartProcess(ctx, cl.ProgramID("app:v1"), container.Spec{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy, err := PolicySpec{Strategy: StrategyAllAtOnce, StopTimeout: time.Second}.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ag.UpgradeProgram(ctx, policy, cl.ProgramID("app:v1"), cl.ProgramID("app:v2")); err != nil {
+		t.Fatal(err)
+	}
+	all := ag.ListAll()
+	if len(all) != 1 || len(all[cl.ProgramID("app:v2")]) != instances {
+		t.Fatalf("want %d processes of app:v2, got %v", instances, all)
+	}
+}
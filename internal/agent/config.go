@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+// An Instance is the context config file templates are rendered with.
+type Instance struct {
+	Program  container.ProgramID
+	Slot     int
+	Hostname string
+	Ports    map[string]int
+	Labels   map[string]string
+	Secrets  map[string]string // only the secrets referenced by the spec
+}
+
+// WithConfigRoot sets the directory under which config files are rendered.
+// Since templates can include secrets, it should be a tmpfs.
+func WithConfigRoot(dir string) Option {
+	return func(ag *Agent) { ag.configRoot = dir }
+}
+
+// WithLabels attaches labels to the agent, they are made available to
+// config templates.
+func WithLabels(labels map[string]string) Option {
+	return func(ag *Agent) { ag.labels = labels }
+}
+
+const defaultConfigRoot = "/dev/shm/deployotron/config"
+
+func (ag *Agent) instance(prgm container.Program, spec container.Spec, slot int, secrets []container.Secret) Instance {
+	hostname, err := os.Hostname()
+	if err != nil {
+		ag.handleError(fmt.Errorf("looking up hostname for config templates: %v", err))
+	}
+	inst := Instance{
+		Program:  prgm.ID(),
+		Slot:     slot,
+		Hostname: hostname,
+		Ports:    spec.Ports,
+		Labels:   ag.labels,
+		Secrets:  make(map[string]string, len(secrets)),
+	}
+	for _, secret := range secrets {
+		inst.Secrets[secret.Name] = string(secret.Value)
+	}
+	return inst
+}
+
+// renderConfig renders the config files of a spec in a new directory. It
+// returns an empty dir if the spec has no config files.
+func (ag *Agent) renderConfig(files []container.ConfigFile, inst Instance) (dir string, err error) {
+	if len(files) == 0 {
+		return "", nil
+	}
+	root := ag.configRoot
+	if root == "" {
+		root = defaultConfigRoot
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", fmt.Errorf("creating config root: %v", err)
+	}
+	created, err := ioutil.TempDir(root, "config")
+	if err != nil {
+		return "", fmt.Errorf("creating config directory: %v", err)
+	}
+	defer func() {
+		// a config that fails to render leaves no directory behind
+		if err != nil {
+			_ = os.RemoveAll(created)
+		}
+	}()
+
+	for _, file := range files {
+		path, err := configPath(created, file.Path)
+		if err != nil {
+			return "", err
+		}
+		tmpl, err := template.New(file.Path).Option("missingkey=error").Parse(file.Template)
+		if err != nil {
+			return "", invalidArgument("parsing config template %q: %v", file.Path, err)
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := tmpl.Execute(buf, inst); err != nil {
+			return "", invalidArgument("rendering config template %q: %v", file.Path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return "", fmt.Errorf("creating directory for config %q: %v", file.Path, err)
+		}
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0400); err != nil {
+			return "", fmt.Errorf("writing config %q: %v", file.Path, err)
+		}
+	}
+	return created, nil
+}
+
+func configPath(dir, name string) (string, error) {
+	clean := filepath.Clean(name)
+	if name == "" || filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", invalidArgument("config path must be relative to the config directory: %q", name)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+func removeConfig(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
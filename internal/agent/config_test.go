@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+func TestRenderConfig(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl, WithLabels(map[string]string{"zone": "east"}), WithSecrets(fakeSecrets{"db": "hunter2"}))
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := container.Spec{
+		Ports:   map[string]int{"http": 8080},
+		Secrets: []container.SecretRef{{Name: "db"}},
+		Files: []container.ConfigFile{
+			{Path: "app.conf", Template: "{{.Program}} #{{.Slot}} on {{.Hostname}} in {{.Labels.zone}}"},
+			{Path: "nested/db.conf", Template: "port={{.Ports.http}} password={{.Secrets.db}}"},
+		},
+	}
+	id := cl.ProgramID("app")
+
+	for slot := 0; slot < 2; slot++ {
+		if _, err := ag.StartProcess(context.Background(), id, spec); err != nil {
+			t.Fatal(err)
+		}
+		dir := cl.createdProcs()[slot].cfg.ConfigDir
+		want := map[string]string{
+			"app.conf":       string(id) + " #" + string(rune('0'+slot)) + " on " + hostname + " in east",
+			"nested/db.conf": "port=8080 password=hunter2",
+		}
+		for file, content := range want {
+			got, err := ioutil.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content {
+				t.Errorf("slot %d, %s: want %q, got %q", slot, file, content, got)
+			}
+		}
+	}
+}
+
+func TestRenderConfigRemovedWithProcess(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	spec := container.Spec{Files: []container.ConfigFile{{Path: "app.conf", Template: "hello"}}}
+	procID, err := ag.StartProcess(context.Background(), cl.ProgramID("app"), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := cl.createdProcs()[0].cfg.ConfigDir
+	if err := ag.StopProcess(context.Background(), procID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("want config dir removed, got %v", err)
+	}
+}
+
+func TestRenderConfigRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		file container.ConfigFile
+	}{
+		{"empty path", container.ConfigFile{Path: "", Template: "x"}},
+		{"dot path", container.ConfigFile{Path: "./", Template: "x"}},
+		{"absolute path", container.ConfigFile{Path: "/etc/app.conf", Template: "x"}},
+		{"escaping path", container.ConfigFile{Path: "../app.conf", Template: "x"}},
+		{"escaping nested path", container.ConfigFile{Path: "a/../../app.conf", Template: "x"}},
+		{"bad template", container.ConfigFile{Path: "app.conf", Template: "{{.Slot"}},
+		{"unknown field", container.ConfigFile{Path: "app.conf", Template: "{{.Nope}}"}},
+		{"missing label", container.ConfigFile{Path: "app.conf", Template: "{{.Labels.nope}}"}},
+		{"unreferenced secret", container.ConfigFile{Path: "app.conf", Template: "{{.Secrets.db}}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			cl := &fakeClient{}
+			ag := newTestAgent(t, cl, WithConfigRoot(root), WithLabels(map[string]string{}), WithSecrets(fakeSecrets{"db": "hunter2"}))
+			spec := container.Spec{Files: []container.ConfigFile{{Path: "ok.conf", Template: "ok"}, tt.file}}
+			_, err := ag.StartProcess(context.Background(), cl.ProgramID("app"), spec)
+			if !errors.Is(err, ErrInvalidArgument) {
+				t.Fatalf("want %v, got %v", ErrInvalidArgument, err)
+			}
+			if entries, _ := ioutil.ReadDir(root); len(entries) != 0 {
+				t.Errorf("want the config dir removed, got %d entries", len(entries))
+			}
+			if procs := cl.createdProcs(); len(procs) != 0 {
+				t.Errorf("want no process created, got %d", len(procs))
+			}
+		})
+	}
+}
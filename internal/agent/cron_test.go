@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+// bits sets the bits of values, for expected cron fields.
+func bits(values ...int) uint64 {
+	var b uint64
+	for _, v := range values {
+		b |= 1 << uint(v)
+	}
+	return b
+}
+
+func bitRange(lo, hi, step int) uint64 {
+	var b uint64
+	for v := lo; v <= hi; v += step {
+		b |= 1 << uint(v)
+	}
+	return b
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		names    map[string]int
+		want     uint64
+	}{
+		{"*", 0, 59, nil, bitRange(0, 59, 1)},
+		{"?", 1, 31, nil, bitRange(1, 31, 1)},
+		{"5", 0, 59, nil, bits(5)},
+		{"0", 0, 59, nil, bits(0)},
+		{"59", 0, 59, nil, bits(59)},
+		{"1-5", 0, 59, nil, bitRange(1, 5, 1)},
+		{"*/15", 0, 59, nil, bits(0, 15, 30, 45)},
+		{"1-10/3", 0, 59, nil, bits(1, 4, 7, 10)},
+		{"5/20", 0, 59, nil, bits(5, 25, 45)},
+		{"1,3,5", 0, 59, nil, bits(1, 3, 5)},
+		{"1-3,10-12,20", 0, 59, nil, bits(1, 2, 3, 10, 11, 12, 20)},
+		{"*/10,5", 0, 59, nil, bits(0, 5, 10, 20, 30, 40, 50)},
+		{"jan", 1, 12, monthNames, bits(1)},
+		{"JAN-Mar", 1, 12, monthNames, bits(1, 2, 3)},
+		{"jun,dec", 1, 12, monthNames, bits(6, 12)},
+		{"jan-dec/6", 1, 12, monthNames, bits(1, 7)},
+		{"mon-fri", 0, 7, dowNames, bitRange(1, 5, 1)},
+		{"sat,sun", 0, 7, dowNames, bits(0, 6)},
+		{"5-7", 0, 7, dowNames, bits(5, 6, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.min, tt.max, tt.names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %b, got %b", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseCronFieldRejects(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		names    map[string]int
+	}{
+		{"", 0, 59, nil},
+		{"60", 0, 59, nil},
+		{"-1", 0, 59, nil},
+		{"0", 1, 31, nil},
+		{"5-1", 0, 59, nil},
+		{"1-", 0, 59, nil},
+		{"1-70", 0, 59, nil},
+		{"*/0", 0, 59, nil},
+		{"*/-5", 0, 59, nil},
+		{"*/x", 0, 59, nil},
+		{"1,,2", 0, 59, nil},
+		{"x", 0, 59, nil},
+		{"mon", 0, 59, nil},
+		{"jan", 0, 7, dowNames},
+		{"fri-mon", 0, 7, dowNames},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			if got, err := parseCronField(tt.field, tt.min, tt.max, tt.names); err == nil {
+				t.Fatalf("want an error, got %b", got)
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		want cronExpr
+	}{
+		{"* * * * *", cronExpr{
+			minute: bitRange(0, 59, 1), hour: bitRange(0, 23, 1), dom: bitRange(1, 31, 1), month: bitRange(1, 12, 1), dow: bitRange(0, 7, 1),
+			domAny: true, dowAny: true,
+		}},
+		{"*/15 9-17 * * mon-fri", cronExpr{
+			minute: bits(0, 15, 30, 45), hour: bitRange(9, 17, 1), dom: bitRange(1, 31, 1), month: bitRange(1, 12, 1), dow: bitRange(1, 5, 1),
+			domAny: true,
+		}},
+		{"0 0 1,15 * 7", cronExpr{
+			minute: bits(0), hour: bits(0), dom: bits(1, 15), month: bitRange(1, 12, 1), dow: bits(0, 7),
+			dowAny: false, domAny: false,
+		}},
+		{"@daily", cronExpr{
+			minute: bits(0), hour: bits(0), dom: bitRange(1, 31, 1), month: bitRange(1, 12, 1), dow: bitRange(0, 7, 1),
+			domAny: true, dowAny: true,
+		}},
+		{" @yearly ", cronExpr{
+			minute: bits(0), hour: bits(0), dom: bits(1), month: bits(1), dow: bitRange(0, 7, 1),
+			dowAny: true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Fatalf("want %+v, got %+v", tt.want, *got)
+			}
+		})
+	}
+
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "@fortnightly", "60 * * * *", "* 24 * * *", "* * 32 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string // empty if it never runs
+	}{
+		{"next minute", "* * * * *", "2026-03-10 10:00", "2026-03-10 10:01"},
+		{"seconds are ignored", "* * * * *", "2026-03-10 10:00", "2026-03-10 10:01"},
+		{"later in the hour", "*/15 * * * *", "2026-03-10 10:16", "2026-03-10 10:30"},
+		{"next hour", "5 * * * *", "2026-03-10 10:05", "2026-03-10 11:05"},
+		{"next day", "0 9 * * *", "2026-03-10 09:00", "2026-03-11 09:00"},
+		{"across a month", "0 0 * * *", "2026-01-31 12:00", "2026-02-01 00:00"},
+		{"across a short month", "0 0 31 * *", "2026-01-31 00:00", "2026-03-31 00:00"},
+		{"across a year", "30 23 31 12 *", "2026-12-31 23:30", "2027-12-31 23:30"},
+		{"first of the year", "@yearly", "2026-06-15 08:00", "2027-01-01 00:00"},
+		{"new year's eve to day", "0 0 * * *", "2026-12-31 23:59", "2027-01-01 00:00"},
+		{"leap day", "0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"never", "0 0 30 2 *", "2026-01-01 00:00", ""},
+		{"week days", "0 9 * * mon-fri", "2026-10-16 09:00", "2026-10-19 09:00"}, // friday to monday
+		{"sunday as 7", "0 0 * * 7", "2026-10-16 00:00", "2026-10-18 00:00"},
+		{"month names", "0 0 1 jun *", "2026-10-16 00:00", "2027-06-01 00:00"},
+		// either day matches when both are restricted
+		{"day of month or week, week first", "0 0 13 * fri", "2026-10-01 00:00", "2026-10-02 00:00"},
+		{"day of month or week, month first", "0 0 13 * fri", "2026-10-10 00:00", "2026-10-13 00:00"},
+		// both must match when either is a star
+		{"day of week only", "0 0 * * fri", "2026-10-10 00:00", "2026-10-16 00:00"},
+		{"day of month with a stepped week", "0 0 13 * */2", "2026-10-01 00:00", "2026-10-13 00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := at(tt.from)
+			if tt.name == "seconds are ignored" {
+				from = from.Add(59 * time.Second)
+			}
+			got := c.next(from)
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Fatalf("want never, got %v", got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+		})
+	}
+}
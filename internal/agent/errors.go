@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by an Agent can be told apart with errors.Is.
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrAlreadyExists   = errors.New("already exists")
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (err *kindError) Error() string { return err.msg }
+func (err *kindError) Unwrap() error { return err.kind }
+
+func notFound(format string, args ...interface{}) error {
+	return &kindError{kind: ErrNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+func alreadyExists(format string, args ...interface{}) error {
+	return &kindError{kind: ErrAlreadyExists, msg: fmt.Sprintf(format, args...)}
+}
+
+func invalidArgument(format string, args ...interface{}) error {
+	return &kindError{kind: ErrInvalidArgument, msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+// An EventKind tells what happened in an Event.
+type EventKind string
+
+// The kinds of events an agent emits.
+const (
+	EventProcessExited    EventKind = "process.exited"
+	EventProcessRestarted EventKind = "process.restarted"
+	EventCrashLooping     EventKind = "process.crash_looping"
+	EventHealthChanged    EventKind = "process.health_changed"
+	EventDeployProgress   EventKind = "deployment.progress"
+)
+
+// Health is the state of a managed process, as far as the agent can tell.
+type Health string
+
+// The health a process can be in.
+const (
+	HealthRunning      Health = "running"
+	HealthCrashLooping Health = "crash-looping"
+	HealthPaused       Health = "paused"
+	// the process exited, and its keep-alive policy doesn't restart it
+	HealthExited Health = "exited"
+)
+
+// An Event is something that happened to the processes of an agent.
+type Event struct {
+	Kind      EventKind           `json:"kind"`
+	Time      time.Time           `json:"time"`
+	ProgramID container.ProgramID `json:"program_id,omitempty"`
+	ProcessID container.ProcessID `json:"process_id,omitempty"`
+	Message   string              `json:"message,omitempty"`
+
+	// set on EventProcessExited
+	ExitCode int `json:"exit_code,omitempty"`
+	// set on EventHealthChanged
+	Health Health `json:"health,omitempty"`
+	// set on EventDeployProgress
+	Done  int `json:"done,omitempty"`
+	Total int `json:"total,omitempty"`
+}
+
+// A Subscription receives the events of an agent on C. A subscriber that
+// doesn't keep up misses events rather than slowing down the agent.
+type Subscription struct {
+	C <-chan Event
+
+	c     chan Event
+	kinds map[EventKind]bool
+	ag    *Agent
+}
+
+// Close stops the delivery of events, and closes C.
+func (sub *Subscription) Close() {
+	ag := sub.ag
+	ag.subsMu.Lock()
+	defer ag.subsMu.Unlock()
+	if _, ok := ag.subs[sub]; ok {
+		delete(ag.subs, sub)
+		close(sub.c)
+	}
+}
+
+func (sub *Subscription) wants(kind EventKind) bool {
+	return len(sub.kinds) == 0 || sub.kinds[kind]
+}
+
+const subscriptionBuffer = 128
+
+// Subscribe to events of the given kinds, or of all kinds if none are given.
+func (ag *Agent) Subscribe(kinds ...EventKind) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, kinds: make(map[EventKind]bool, len(kinds)), ag: ag}
+	for _, kind := range kinds {
+		sub.kinds[kind] = true
+	}
+	ag.subsMu.Lock()
+	ag.subs[sub] = struct{}{}
+	ag.subsMu.Unlock()
+	return sub
+}
+
+func (ag *Agent) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ag.subsMu.Lock()
+	defer ag.subsMu.Unlock()
+	for sub := range ag.subs {
+		if !sub.wants(ev.Kind) {
+			continue
+		}
+		select {
+		case sub.c <- ev:
+		default: // slow subscriber, drop the event
+		}
+	}
+}
+
+// progress wraps the start of processes to report the progress of a
+// deployment of a program.
+func (ag *Agent) progress(id container.ProgramID, total int, start func(int) error) func(int) error {
+	var (
+		mu   sync.Mutex
+		done int
+	)
+	return func(i int) error {
+		if err := start(i); err != nil {
+			return err
+		}
+		mu.Lock()
+		done++
+		ev := Event{
+			Kind:      EventDeployProgress,
+			ProgramID: id,
+			Done:      done,
+			Total:     total,
+			Message:   fmt.Sprintf("%d/%d processes started", done, total),
+		}
+		mu.Unlock()
+		ag.emit(ev)
+		return nil
+	}
+}
@@ -0,0 +1,351 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/pborman/uuid"
+)
+
+// A JobID uniquely identifies a job.
+type JobID string
+
+// JobOptions tell how a job should be run.
+type JobOptions struct {
+	// Completions is how many runs must succeed for the job to succeed,
+	// defaults to 1.
+	Completions int `json:"completions,omitempty"`
+	// Parallelism is how many runs can happen at once, defaults to 1.
+	Parallelism int `json:"parallelism,omitempty"`
+	// Retries is how many runs can fail before the job fails.
+	Retries int `json:"retries,omitempty"`
+}
+
+// A JobState tells where a job is in its life.
+type JobState string
+
+// The states a job can be in.
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobStopped   JobState = "stopped"
+)
+
+// A JobRun is a single execution of the program of a job.
+type JobRun struct {
+	ProcessID  container.ProcessID `json:"process_id"`
+	Started    time.Time           `json:"started"`
+	Duration   time.Duration       `json:"duration"`
+	ExitCode   int                 `json:"exit_code"`
+	Err        string              `json:"error,omitempty"`
+	StdoutTail string              `json:"stdout_tail"`
+}
+
+// A JobStatus describes a job and its runs.
+type JobStatus struct {
+	ID        JobID               `json:"id"`
+	Program   container.ProgramID `json:"program"`
+	Options   JobOptions          `json:"options"`
+	State     JobState            `json:"state"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Runs      []JobRun            `json:"runs"`
+	Started   time.Time           `json:"started"`
+	Finished  time.Time           `json:"finished,omitempty"`
+}
+
+const (
+	// keep that much of the output of each run
+	jobStdoutTailSize = 4 << 10
+	// forget the oldest finished jobs past that many
+	maxFinishedJobs = 100
+)
+
+/*
+ Job scoped API
+*/
+
+// RunJob runs a program until it completes, instead of keeping it alive. It
+// gives up pulling the program once ctx is done, but the job itself doesn't
+// depend on ctx.
+func (ag *Agent) RunJob(ctx context.Context, id container.ProgramID, spec container.Spec, opts JobOptions) (JobID, error) {
+	job, err := ag.runJob(ctx, id, spec, opts)
+	if err != nil {
+		return "", err
+	}
+	return job.status.ID, nil
+}
+
+func (ag *Agent) runJob(ctx context.Context, id container.ProgramID, spec container.Spec, opts JobOptions) (*job, error) {
+	if opts.Completions == 0 {
+		opts.Completions = 1
+	}
+	if opts.Parallelism == 0 {
+		opts.Parallelism = 1
+	}
+	if opts.Completions < 0 || opts.Parallelism < 0 || opts.Retries < 0 {
+		return nil, invalidArgument("job options can't be negative: %+v", opts)
+	}
+	prgm, err := ag.client.Programs().Pull(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("pulling program: %v", err)
+	}
+	job := &job{
+		ag:      ag,
+		prgm:    prgm,
+		spec:    spec,
+		running: make(map[container.ProcessID]container.Process),
+		done:    make(chan struct{}),
+		status: JobStatus{
+			ID:      JobID(uuid.New()),
+			Program: id,
+			Options: opts,
+			State:   JobRunning,
+			Started: time.Now(),
+		},
+	}
+
+	ag.mu.Lock()
+	ag.jobs[job.status.ID] = job
+	ag.forgetOldJobs()
+	ag.mu.Unlock()
+
+	go job.run()
+	return job, nil
+}
+
+// Job returns the status of a job.
+func (ag *Agent) Job(id JobID) (JobStatus, error) {
+	ag.mu.Lock()
+	job, ok := ag.jobs[id]
+	ag.mu.Unlock()
+	if !ok {
+		return JobStatus{}, notFound("no such job: %v", id)
+	}
+	return job.Status(), nil
+}
+
+// ListJobs returns the status of all known jobs, oldest first.
+func (ag *Agent) ListJobs() []JobStatus {
+	ag.mu.Lock()
+	jobs := make([]*job, 0, len(ag.jobs))
+	for _, job := range ag.jobs {
+		jobs = append(jobs, job)
+	}
+	ag.mu.Unlock()
+
+	out := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		out = append(out, job.Status())
+	}
+	sort.Sort(jobsByStart(out))
+	return out
+}
+
+// StopJob stops the running processes of a job, no further run is started.
+func (ag *Agent) StopJob(id JobID, timeout time.Duration) error {
+	ag.mu.Lock()
+	job, ok := ag.jobs[id]
+	ag.mu.Unlock()
+	if !ok {
+		return notFound("no such job: %v", id)
+	}
+	job.stop(timeout)
+	return nil
+}
+
+// forgetOldJobs must be called with ag.mu held.
+func (ag *Agent) forgetOldJobs() {
+	var finished []JobStatus
+	for _, job := range ag.jobs {
+		if status := job.Status(); status.State != JobRunning {
+			finished = append(finished, status)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Sort(jobsByStart(finished))
+	for _, status := range finished[:len(finished)-maxFinishedJobs] {
+		delete(ag.jobs, status.ID)
+	}
+}
+
+type jobsByStart []JobStatus
+
+func (s jobsByStart) Len() int           { return len(s) }
+func (s jobsByStart) Less(i, j int) bool { return s[i].Started.Before(s[j].Started) }
+func (s jobsByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type job struct {
+	ag   *Agent
+	prgm container.Program
+	spec container.Spec
+
+	mu       sync.Mutex
+	status   JobStatus
+	stopping bool
+	running  map[container.ProcessID]container.Process
+	done     chan struct{}
+}
+
+// Status returns a copy of the job's status.
+func (job *job) Status() JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	status := job.status
+	status.Runs = append([]JobRun(nil), job.status.Runs...)
+	return status
+}
+
+func (job *job) run() {
+	defer close(job.done)
+	opts := job.status.Options
+	results := make(chan JobRun)
+	running, launched := 0, 0
+	for {
+		for job.shouldLaunch(running) {
+			running++
+			go func(slot int) { results <- job.runOnce(slot) }(launched)
+			launched++
+		}
+		if running == 0 {
+			break
+		}
+		run := <-results
+		running--
+
+		job.mu.Lock()
+		job.status.Runs = append(job.status.Runs, run)
+		if run.Err == "" {
+			job.status.Succeeded++
+		} else {
+			job.status.Failed++
+		}
+		job.mu.Unlock()
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.Finished = time.Now()
+	switch {
+	case job.status.Succeeded >= opts.Completions:
+		job.status.State = JobSucceeded
+	case job.stopping:
+		job.status.State = JobStopped
+	default:
+		job.status.State = JobFailed
+	}
+}
+
+func (job *job) shouldLaunch(running int) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	opts := job.status.Options
+	return !job.stopping &&
+		running < opts.Parallelism &&
+		job.status.Succeeded+running < opts.Completions &&
+		job.status.Failed <= opts.Retries
+}
+
+func (job *job) runOnce(slot int) (run JobRun) {
+	run.Started = time.Now()
+	stdout := newTailBuffer(jobStdoutTailSize)
+	defer func() {
+		run.Duration = time.Since(run.Started)
+		run.StdoutTail = stdout.String()
+	}()
+
+	cfg, err := job.ag.processConfig(job.prgm, job.spec, slot)
+	if err != nil {
+		run.Err = fmt.Sprintf("configuring process: %v", err)
+		return run
+	}
+	defer func() {
+		if err := removeConfig(cfg.ConfigDir); err != nil {
+			job.ag.handleError(fmt.Errorf("cleaning up config of job process %v, %v", run.ProcessID, err))
+		}
+	}()
+	cfg.Stdout = stdout
+
+	ctx := context.Background()
+	proc, err := job.ag.client.Processes().Create(ctx, job.prgm, cfg)
+	if err != nil {
+		run.Err = fmt.Sprintf("creating process: %v", err)
+		return run
+	}
+	run.ProcessID = proc.ID()
+	defer func() {
+		if err := job.ag.client.Processes().Remove(ctx, proc); err != nil {
+			job.ag.handleError(fmt.Errorf("cleaning up job process %v, %v", run.ProcessID, err))
+		}
+	}()
+
+	if err := job.start(proc); err != nil {
+		run.Err = err.Error()
+		return run
+	}
+	defer job.untrack(proc)
+
+	err = proc.Wait()
+	if exitErr, ok := err.(*container.ExitError); ok {
+		run.ExitCode = exitErr.Code
+	}
+	if err != nil {
+		run.Err = err.Error()
+	}
+	return run
+}
+
+// start a process and remember it so it can be stopped, unless the job is
+// already stopping.
+func (job *job) start(proc container.Process) error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.stopping {
+		return fmt.Errorf("job was stopped")
+	}
+	if err := proc.Start(context.Background()); err != nil {
+		return fmt.Errorf("starting process: %v", err)
+	}
+	job.running[proc.ID()] = proc
+	return nil
+}
+
+func (job *job) untrack(proc container.Process) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	delete(job.running, proc.ID())
+}
+
+func (job *job) stop(timeout time.Duration) {
+	job.mu.Lock()
+	job.stopping = true
+	procs := make([]container.Process, 0, len(job.running))
+	for _, proc := range job.running {
+		procs = append(procs, proc)
+	}
+	job.mu.Unlock()
+
+	for _, proc := range procs {
+		if err := proc.Stop(context.Background(), timeout); err != nil {
+			job.ag.handleError(fmt.Errorf("stopping job process %v: %v", proc.ID(), err))
+		}
+	}
+	select {
+	case <-job.done:
+		return
+	case <-time.After(timeout):
+	}
+	for _, proc := range procs {
+		if err := proc.Kill(); err != nil {
+			job.ag.handleError(fmt.Errorf("killing job process %v: %v", proc.ID(), err))
+		}
+	}
+	<-job.done
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+func waitJob(t *testing.T, ag *Agent, id JobID) JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := ag.Job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != JobRunning {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %v is still running: %+v", id, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	tests := []struct {
+		name      string
+		program   string
+		opts      JobOptions
+		state     JobState
+		succeeded int
+		failed    int
+	}{
+		{"defaults", "exit 0", JobOptions{}, JobSucceeded, 1, 0},
+		{"completions", "exit 0", JobOptions{Completions: 3}, JobSucceeded, 3, 0},
+		{"parallel completions", "exit 0", JobOptions{Completions: 5, Parallelism: 2}, JobSucceeded, 5, 0},
+		{"failure", "exit 3", JobOptions{}, JobFailed, 0, 1},
+		{"retries", "exit 3", JobOptions{Completions: 2, Retries: 2}, JobFailed, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &fakeClient{}
+			ag := newTestAgent(t, cl)
+			id, err := ag.RunJob(context.Background(), cl.ProgramID(tt.program), container.Spec{}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := waitJob(t, ag, id)
+			if status.State != tt.state || status.Succeeded != tt.succeeded || status.Failed != tt.failed {
+				t.Fatalf("want %v with %d succeeded and %d failed, got %v with %d and %d",
+					tt.state, tt.succeeded, tt.failed, status.State, status.Succeeded, status.Failed)
+			}
+			if len(status.Runs) != tt.succeeded+tt.failed {
+				t.Fatalf("want %d runs, got %d", tt.succeeded+tt.failed, len(status.Runs))
+			}
+			for _, run := range status.Runs {
+				if (run.Err == "") != (run.ExitCode == 0) {
+					t.Errorf("run %v exited with %d, and error %q", run.ProcessID, run.ExitCode, run.Err)
+				}
+			}
+			if status.Finished.IsZero() {
+				t.Error("want the job finished")
+			}
+			if removed, created := cl.removedIDs(), cl.createdProcs(); len(removed) != len(created) {
+				t.Errorf("want every process removed, %d of %d were", len(removed), len(created))
+			}
+		})
+	}
+}
+
+func TestStopJob(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	id, err := ag.RunJob(context.Background(), cl.ProgramID("forever"), container.Spec{}, JobOptions{Parallelism: 2, Completions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(cl.createdProcs()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := ag.StopJob(id, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	status := waitJob(t, ag, id)
+	if status.State != JobStopped {
+		t.Fatalf("want %v, got %v", JobStopped, status.State)
+	}
+	if len(cl.createdProcs()) != 2 {
+		t.Errorf("want no run started once stopped, got %d", len(cl.createdProcs()))
+	}
+}
+
+func TestRunJobRejects(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	for _, opts := range []JobOptions{{Completions: -1}, {Parallelism: -1}, {Retries: -1}} {
+		if _, err := ag.RunJob(context.Background(), cl.ProgramID("exit 0"), container.Spec{}, opts); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%+v: want %v, got %v", opts, ErrInvalidArgument, err)
+		}
+	}
+	if _, err := ag.Job("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want %v for an unknown job, got %v", ErrNotFound, err)
+	}
+	if err := ag.StopJob("nope", time.Second); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want %v stopping an unknown job, got %v", ErrNotFound, err)
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	var ids []JobID
+	for i := 0; i < 3; i++ {
+		id, err := ag.RunJob(context.Background(), cl.ProgramID("exit 0"), container.Spec{}, JobOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitJob(t, ag, id)
+		ids = append(ids, id)
+	}
+	jobs := ag.ListJobs()
+	if len(jobs) != len(ids) {
+		t.Fatalf("want %d jobs, got %d", len(ids), len(jobs))
+	}
+	for i, job := range jobs {
+		if job.ID != ids[i] {
+			t.Errorf("want job %d to be %v, got %v", i, ids[i], job.ID)
+		}
+	}
+}
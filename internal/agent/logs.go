@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+// A LogLine is a line a process wrote. Lines of a process are numbered in
+// the order they were written, so that missed lines show as gaps.
+type LogLine struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"` // stdout or stderr
+	Text   string    `json:"text"`
+}
+
+const (
+	// how many lines of each process are kept
+	processLogLines = 1000
+	// how many lines a follower can lag behind before it misses some
+	logTailBuffer = 256
+	// lines longer than this are split
+	maxLogLine = 64 << 10
+)
+
+// A LogTail receives lines of a process on C, which is closed once there
+// are no more lines to receive. A follower that doesn't keep up misses lines
+// rather than slowing down the process.
+type LogTail struct {
+	C <-chan LogLine
+
+	c    chan LogLine
+	plog *processLog
+}
+
+// Close stops the delivery of lines, and closes C.
+func (tail *LogTail) Close() {
+	plog := tail.plog
+	plog.mu.Lock()
+	defer plog.mu.Unlock()
+	if _, ok := plog.tails[tail]; ok {
+		delete(plog.tails, tail)
+		close(tail.c)
+	}
+}
+
+// TailLogs returns the last lines a process wrote. If follow is set, the
+// lines it writes next are received until the tail is closed or the process
+// is stopped.
+func (ag *Agent) TailLogs(id container.ProcessID, lines int, follow bool) (*LogTail, error) {
+	if lines < 0 {
+		return nil, invalidArgument("can't tail %d lines", lines)
+	}
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	mproc, ok := ag.started[id]
+	if !ok {
+		return nil, notFound("no such process: %#v", id)
+	}
+	return mproc.logs.tail(lines, follow), nil
+}
+
+// processLog keeps the last lines of a process, and hands them to its
+// followers.
+type processLog struct {
+	mu     sync.Mutex
+	lines  []LogLine // the oldest at start
+	seq    uint64
+	tails  map[*LogTail]struct{}
+	closed bool
+}
+
+func newProcessLog() *processLog {
+	return &processLog{tails: make(map[*LogTail]struct{})}
+}
+
+// writer of one of the output streams of the process.
+func (plog *processLog) writer(stream string) *logWriter {
+	return &logWriter{plog: plog, stream: stream}
+}
+
+func (plog *processLog) append(stream string, text []byte) {
+	plog.mu.Lock()
+	defer plog.mu.Unlock()
+	plog.seq++
+	line := LogLine{Seq: plog.seq, Time: time.Now(), Stream: stream, Text: string(text)}
+	if len(plog.lines) == processLogLines {
+		plog.lines = append(plog.lines[:0], plog.lines[1:]...)
+	}
+	plog.lines = append(plog.lines, line)
+	for tail := range plog.tails {
+		select {
+		case tail.c <- line:
+		default: // slow follower, drop the line
+		}
+	}
+}
+
+func (plog *processLog) tail(lines int, follow bool) *LogTail {
+	plog.mu.Lock()
+	defer plog.mu.Unlock()
+	if lines > len(plog.lines) {
+		lines = len(plog.lines)
+	}
+	backlog := plog.lines[len(plog.lines)-lines:]
+	c := make(chan LogLine, len(backlog)+logTailBuffer)
+	for _, line := range backlog {
+		c <- line
+	}
+	tail := &LogTail{C: c, c: c, plog: plog}
+	if follow && !plog.closed {
+		plog.tails[tail] = struct{}{}
+	} else {
+		close(c)
+	}
+	return tail
+}
+
+// close ends the tails following the process, once it's stopped.
+func (plog *processLog) close() {
+	plog.mu.Lock()
+	defer plog.mu.Unlock()
+	plog.closed = true
+	for tail := range plog.tails {
+		delete(plog.tails, tail)
+		close(tail.c)
+	}
+}
+
+// logWriter splits what a process writes into lines.
+type logWriter struct {
+	plog   *processLog
+	stream string
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, p...)
+			if len(w.partial) >= maxLogLine {
+				w.plog.append(w.stream, w.partial)
+				w.partial = w.partial[:0]
+			}
+			break
+		}
+		w.plog.append(w.stream, append(w.partial, p[:i]...))
+		w.partial = w.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
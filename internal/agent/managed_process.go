@@ -1,24 +1,48 @@
 package agent
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/aybabtme/deployotron/internal/backoff"
 	"github.com/aybabtme/deployotron/internal/container"
 	"github.com/aybabtme/log"
 )
 
 type managedProcess struct {
-	kill chan *stopJob
-	done chan struct{}
-	ag   *Agent
-	proc container.Process
+	kill    chan *stopJob
+	done    chan struct{} // closed once it's being stopped
+	stopped chan struct{} // closed once it's stopped
+	ag      *Agent
+	proc    container.Process
+	spec    container.Spec
+
+	slot      int    // index of this instance among those of its program
+	configDir string // where its config files were rendered
+	logs      *processLog
+
+	mu      sync.Mutex
+	resumed chan struct{} // nil unless the process is paused
+	exited  bool          // whether it exited while paused
+	health  Health
+	starts  int // bumped on every restart
 }
 
-func manage(proc container.Process) *managedProcess {
+const (
+	// a process exiting that many times within the window is crash-looping
+	crashLoopExits  = 5
+	crashLoopWindow = time.Minute
+
+	// the first delay between restarts, when they're spaced out
+	restartBackoff = time.Second
+)
+
+func manage(ag *Agent, proc container.Process, spec container.Spec) *managedProcess {
 	kill := make(chan *stopJob, 1)
 	done := make(chan struct{})
-	mproc := &managedProcess{kill: kill, proc: proc, done: done}
+	mproc := &managedProcess{kill: kill, ag: ag, proc: proc, spec: spec, done: done, stopped: make(chan struct{}), health: HealthRunning}
 	go mproc.listenStop()
 	go mproc.keepAlive()
 	return mproc
@@ -30,12 +54,18 @@ func (mproc *managedProcess) handleError(err error) {
 
 func (mproc *managedProcess) listenStop() {
 	job := <-mproc.kill
-	defer close(job.done)
+	defer close(mproc.stopped)
 	close(mproc.done) // tell the keepAlive loop to give up
+	if mproc.isPaused() {
+		// a paused process can't handle the stop, wake it up first
+		if err := mproc.resume(); err != nil {
+			mproc.handleError(fmt.Errorf("resuming process %v before stopping it: %v", mproc.proc.ID(), err))
+		}
+	}
 	if job.timeout != 0 {
 		stopped := make(chan struct{}, 0)
 		go func() {
-			if err := mproc.proc.Stop(job.timeout); err != nil {
+			if err := mproc.proc.Stop(job.ctx, job.timeout); err != nil {
 				mproc.ag.handleError(err)
 			} else {
 				close(stopped)
@@ -54,11 +84,18 @@ func (mproc *managedProcess) listenStop() {
 	}
 }
 
+// keepAlive restarts the process when it exits, as its keep-alive policy
+// tells. Restarts in a row are those of a process that didn't stay up for
+// the crash-loop window.
 func (mproc *managedProcess) keepAlive() {
-	// TODO(antoine): accept a policy for restarts, like:
-	//   - forever/limited attempts to restart
-	//   - immediate/backoff
 	proc := mproc.proc
+	policy := mproc.spec.KeepAlive
+	retry := backoff.Backoff{Min: restartBackoff, Max: policy.MaxBackoff}
+	var (
+		exits    []time.Time
+		restarts int // in a row
+		started  = time.Now()
+	)
 	for {
 		err := proc.Wait()
 		select {
@@ -66,12 +103,54 @@ func (mproc *managedProcess) keepAlive() {
 			return // expected to die
 		default:
 		}
+		exited := Event{Kind: EventProcessExited, Message: "process exited"}
+		if exitErr, ok := err.(*container.ExitError); ok {
+			exited.ExitCode = exitErr.Code
+		}
 		if err != nil {
 			mproc.handleError(fmt.Errorf("waiting for process %v: %v", proc.ID(), err))
+			exited.Message = err.Error()
+		}
+		mproc.emit(exited)
+
+		exits = append(recentExits(exits, time.Now()), time.Now())
+		if len(exits) >= crashLoopExits && mproc.setHealth(HealthCrashLooping) {
+			mproc.emit(Event{
+				Kind:    EventCrashLooping,
+				Message: fmt.Sprintf("exited %d times in the last %v", len(exits), crashLoopWindow),
+			})
+		}
+
+		// a paused process was frozen on purpose: if it dies meanwhile, wait
+		// until someone resumes it before bringing it back
+		if resumed := mproc.exitedWhilePaused(); resumed != nil {
+			select {
+			case <-mproc.done:
+				return // expected to die
+			case <-resumed:
+			}
+		}
+
+		if time.Since(started) >= crashLoopWindow {
+			restarts = 0
+			retry.Reset()
+		}
+		if !policy.Restarts(err != nil) || (policy.MaxRestarts > 0 && restarts >= policy.MaxRestarts) {
+			mproc.setHealth(HealthExited)
+			return
+		}
+		if policy.MaxBackoff > 0 {
+			select {
+			case <-mproc.done:
+				return // expected to die
+			case <-time.After(retry.Next()):
+			}
 		}
 
 		// restart it
-		for serr := proc.Start(); serr != nil; serr = proc.Start() {
+		restarts++
+		ctx := context.Background()
+		for serr := proc.Start(ctx); serr != nil; serr = proc.Start(ctx) {
 			select {
 			case <-mproc.done:
 				return // expected to die
@@ -80,19 +159,127 @@ func (mproc *managedProcess) keepAlive() {
 				time.Sleep(500 * time.Millisecond)
 			}
 		}
+		started = time.Now()
+		mproc.emit(Event{Kind: EventProcessRestarted, Message: "process restarted"})
+		mproc.healthyIfUpFor(crashLoopWindow)
 	}
 }
 
+func recentExits(exits []time.Time, now time.Time) []time.Time {
+	recent := exits[:0]
+	for _, exit := range exits {
+		if now.Sub(exit) < crashLoopWindow {
+			recent = append(recent, exit)
+		}
+	}
+	return recent
+}
+
+// healthyIfUpFor marks a crash-looping process as running again if it isn't
+// restarted for that long.
+func (mproc *managedProcess) healthyIfUpFor(d time.Duration) {
+	mproc.mu.Lock()
+	mproc.starts++
+	starts := mproc.starts
+	mproc.mu.Unlock()
+	time.AfterFunc(d, func() {
+		mproc.mu.Lock()
+		stable := mproc.starts == starts && mproc.health == HealthCrashLooping
+		mproc.mu.Unlock()
+		if stable {
+			mproc.setHealth(HealthRunning)
+		}
+	})
+}
+
+// setHealth changes the health of the process, and reports whether it
+// changed.
+func (mproc *managedProcess) setHealth(health Health) bool {
+	mproc.mu.Lock()
+	changed := mproc.health != health
+	mproc.health = health
+	mproc.mu.Unlock()
+	if changed {
+		mproc.emit(Event{Kind: EventHealthChanged, Health: health, Message: "process is " + string(health)})
+	}
+	return changed
+}
+
+func (mproc *managedProcess) emit(ev Event) {
+	ev.ProgramID = mproc.proc.Program().ID()
+	ev.ProcessID = mproc.proc.ID()
+	mproc.ag.emit(ev)
+}
+
 type stopJob struct {
+	ctx     context.Context
 	timeout time.Duration
-	done    chan struct{}
 }
 
-func (mproc *managedProcess) stop(timeout time.Duration) {
-	job := &stopJob{timeout: timeout, done: make(chan struct{})}
+// stop the process, waiting until it's stopped or ctx is done. Once it's
+// asked to stop, the process is stopped all the same.
+func (mproc *managedProcess) stop(ctx context.Context, timeout time.Duration) error {
 	select {
-	case mproc.kill <- job:
-		<-job.done
-	default:
+	case mproc.kill <- &stopJob{ctx: ctx, timeout: timeout}:
+	default: // already stopping
+	}
+	select {
+	case <-mproc.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (mproc *managedProcess) pause() error {
+	mproc.mu.Lock()
+	defer mproc.mu.Unlock()
+	if mproc.resumed != nil {
+		return nil // already paused
+	}
+	if err := mproc.proc.Pause(); err != nil {
+		return err
+	}
+	mproc.resumed = make(chan struct{})
+	mproc.health = HealthPaused
+	mproc.emit(Event{Kind: EventHealthChanged, Health: HealthPaused, Message: "process is paused"})
+	return nil
+}
+
+func (mproc *managedProcess) resume() error {
+	mproc.mu.Lock()
+	defer mproc.mu.Unlock()
+	if mproc.resumed == nil {
+		return nil // not paused
+	}
+	if !mproc.exited {
+		if err := mproc.proc.Resume(); err != nil {
+			return err
+		}
+	}
+	close(mproc.resumed)
+	mproc.resumed = nil
+	mproc.exited = false
+	mproc.health = HealthRunning
+	mproc.emit(Event{Kind: EventHealthChanged, Health: HealthRunning, Message: "process is running"})
+	return nil
+}
+
+func (mproc *managedProcess) isPaused() bool {
+	mproc.mu.Lock()
+	defer mproc.mu.Unlock()
+	return mproc.resumed != nil
+}
+
+// exitedWhilePaused records that the process exited if it was paused, and
+// returns a channel closed when it's resumed. It returns nil if the process
+// wasn't paused.
+func (mproc *managedProcess) exitedWhilePaused() <-chan struct{} {
+	mproc.mu.Lock()
+	defer mproc.mu.Unlock()
+	if mproc.resumed == nil {
+		return nil
 	}
+	mproc.exited = true
+	return mproc.resumed
 }
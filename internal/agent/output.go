@@ -0,0 +1,35 @@
+package agent
+
+import "sync"
+
+// tailBuffer keeps the last bytes written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size, buf: make([]byte, 0, size)}
+}
+
+func (tail *tailBuffer) Write(p []byte) (int, error) {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	n := len(p)
+	if n >= tail.size {
+		tail.buf = append(tail.buf[:0], p[n-tail.size:]...)
+		return n, nil
+	}
+	if overflow := len(tail.buf) + n - tail.size; overflow > 0 {
+		tail.buf = append(tail.buf[:0], tail.buf[overflow:]...)
+	}
+	tail.buf = append(tail.buf, p...)
+	return n, nil
+}
+
+func (tail *tailBuffer) String() string {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	return string(tail.buf)
+}
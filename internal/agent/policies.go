@@ -95,3 +95,46 @@ func PolicyRolling() RestartPolicy {
 		},
 	}
 }
+
+// DefaultStopTimeout is how long processes have to stop before they're
+// killed, unless a PolicySpec says otherwise.
+const DefaultStopTimeout = 10 * time.Second
+
+// The strategies a PolicySpec can use.
+const (
+	StrategyAllAtOnce = "all-at-once"
+	StrategyRolling   = "rolling"
+)
+
+// A PolicySpec describes a RestartPolicy in a form that can be serialized.
+type PolicySpec struct {
+	Strategy        string        `json:"strategy" yaml:"strategy,omitempty"`
+	StartBeforeStop bool          `json:"start_before_stop,omitempty" yaml:"start_before_stop,omitempty"`
+	StopTimeout     time.Duration `json:"stop_timeout,omitempty" yaml:"stop_timeout,omitempty"`
+}
+
+// Policy returns the RestartPolicy described by the spec. The strategy
+// defaults to a rolling restart, and the stop timeout to DefaultStopTimeout.
+func (spec PolicySpec) Policy() (RestartPolicy, error) {
+	var policy RestartPolicy
+	switch spec.Strategy {
+	case StrategyRolling, "":
+		policy = PolicyRolling()
+	case StrategyAllAtOnce:
+		policy = PolicyAllAtOnce()
+	default:
+		return nil, invalidArgument("unknown restart strategy %q", spec.Strategy)
+	}
+	if spec.StartBeforeStop {
+		policy = PolicyStartBeforeStop(policy)
+	}
+	switch {
+	case spec.StopTimeout < 0:
+		return nil, invalidArgument("stop timeout is negative: %v", spec.StopTimeout)
+	case spec.StopTimeout == 0:
+		policy = PolicyStopTimeout(policy, DefaultStopTimeout)
+	default:
+		policy = PolicyStopTimeout(policy, spec.StopTimeout)
+	}
+	return policy, nil
+}
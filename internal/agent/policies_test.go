@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicySpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    PolicySpec
+		timeout time.Duration
+	}{
+		{"defaults", PolicySpec{}, DefaultStopTimeout},
+		{"rolling", PolicySpec{Strategy: StrategyRolling, StopTimeout: time.Second}, time.Second},
+		{"all at once", PolicySpec{Strategy: StrategyAllAtOnce}, DefaultStopTimeout},
+		{"start before stop", PolicySpec{StartBeforeStop: true, StopTimeout: time.Minute}, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := tt.spec.Policy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.Timeout() != tt.timeout {
+				t.Fatalf("want a stop timeout of %v, got %v", tt.timeout, policy.Timeout())
+			}
+		})
+	}
+
+	for _, spec := range []PolicySpec{
+		{Strategy: "yolo"},
+		{StopTimeout: -time.Second},
+	} {
+		if _, err := spec.Policy(); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%+v: want %v, got %v", spec, ErrInvalidArgument, err)
+		}
+	}
+}
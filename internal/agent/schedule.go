@@ -0,0 +1,385 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/log"
+)
+
+// A ConcurrencyPolicy tells what to do when a scheduled run is due while
+// previous runs are still going.
+type ConcurrencyPolicy string
+
+// The policies for overlapping runs.
+const (
+	ConcurrencyAllow   ConcurrencyPolicy = "allow"
+	ConcurrencyForbid  ConcurrencyPolicy = "forbid"
+	ConcurrencyReplace ConcurrencyPolicy = "replace"
+)
+
+// A MissedRunPolicy tells what to do about runs that were due while the
+// agent was down.
+type MissedRunPolicy string
+
+// The policies for missed runs.
+const (
+	MissedRunSkip    MissedRunPolicy = "skip"
+	MissedRunRunOnce MissedRunPolicy = "run-once"
+)
+
+// A Schedule runs a program as a job, periodically.
+type Schedule struct {
+	Name    string              `json:"name"`
+	Program container.ProgramID `json:"program"`
+	Spec    container.Spec      `json:"spec"`
+	Job     JobOptions          `json:"job"`
+
+	// Cron is a 5 fields cron expression, see parseCron.
+	Cron string `json:"cron"`
+	// Concurrency defaults to ConcurrencyAllow.
+	Concurrency ConcurrencyPolicy `json:"concurrency,omitempty"`
+	// MissedRuns defaults to MissedRunSkip.
+	MissedRuns MissedRunPolicy `json:"missed_runs,omitempty"`
+	// StartingDeadline is how late a missed run can be caught up, no limit
+	// if zero.
+	StartingDeadline time.Duration `json:"starting_deadline,omitempty"`
+	// HistoryLimit is how many runs are remembered, defaults to 10.
+	HistoryLimit int `json:"history_limit,omitempty"`
+}
+
+// A ScheduleStatus describes a schedule and its latest runs.
+type ScheduleStatus struct {
+	Schedule Schedule    `json:"schedule"`
+	LastRun  time.Time   `json:"last_run,omitempty"`
+	NextRun  time.Time   `json:"next_run,omitempty"`
+	History  []JobStatus `json:"history"`
+}
+
+const (
+	defaultHistoryLimit = 10
+	// how long replaced runs have to stop cleanly
+	replaceStopTimeout = 10 * time.Second
+	// where schedules are persisted in the state directory
+	scheduleStateFile = "schedules.json"
+)
+
+// WithStateDir makes the agent persist its schedules in dir, so that they
+// survive restarts and runs missed while it was down can be caught up.
+func WithStateDir(dir string) Option {
+	return func(ag *Agent) { ag.stateDir = dir }
+}
+
+/*
+ Schedule scoped API
+*/
+
+// Schedule a program to run as a job periodically. A schedule with the same
+// name is replaced.
+func (ag *Agent) Schedule(sched Schedule) error {
+	cron, err := validSchedule(&sched)
+	if err != nil {
+		return err
+	}
+	s := &scheduled{ag: ag, sched: sched, cron: cron, stop: make(chan struct{})}
+
+	ag.mu.Lock()
+	if previous, ok := ag.schedules[sched.Name]; ok {
+		previous.cancel()
+		s.lastRun, s.history = previous.snapshot()
+	}
+	ag.schedules[sched.Name] = s
+	ag.mu.Unlock()
+
+	go s.loop(time.Time{})
+	return ag.saveSchedules()
+}
+
+// Unschedule stops scheduling runs of a schedule. Its running jobs are left
+// alone.
+func (ag *Agent) Unschedule(name string) error {
+	ag.mu.Lock()
+	s, ok := ag.schedules[name]
+	if ok {
+		s.cancel()
+		delete(ag.schedules, name)
+	}
+	ag.mu.Unlock()
+	if !ok {
+		return notFound("no such schedule: %q", name)
+	}
+	return ag.saveSchedules()
+}
+
+// ListSchedules returns all schedules, sorted by name.
+func (ag *Agent) ListSchedules() []ScheduleStatus {
+	ag.mu.Lock()
+	all := make([]*scheduled, 0, len(ag.schedules))
+	for _, s := range ag.schedules {
+		all = append(all, s)
+	}
+	ag.mu.Unlock()
+
+	out := make([]ScheduleStatus, 0, len(all))
+	for _, s := range all {
+		out = append(out, s.status())
+	}
+	sort.Sort(schedulesByName(out))
+	return out
+}
+
+// ScheduleHistory returns the latest runs of a schedule, oldest first.
+func (ag *Agent) ScheduleHistory(name string) ([]JobStatus, error) {
+	ag.mu.Lock()
+	s, ok := ag.schedules[name]
+	ag.mu.Unlock()
+	if !ok {
+		return nil, notFound("no such schedule: %q", name)
+	}
+	return s.status().History, nil
+}
+
+func validSchedule(sched *Schedule) (*cronExpr, error) {
+	if sched.Name == "" {
+		return nil, invalidArgument("schedule has no name")
+	}
+	cron, err := parseCron(sched.Cron)
+	if err != nil {
+		return nil, invalidArgument("schedule %q: %v", sched.Name, err)
+	}
+	switch sched.Concurrency {
+	case "":
+		sched.Concurrency = ConcurrencyAllow
+	case ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return nil, invalidArgument("schedule %q: unknown concurrency policy %q", sched.Name, sched.Concurrency)
+	}
+	switch sched.MissedRuns {
+	case "":
+		sched.MissedRuns = MissedRunSkip
+	case MissedRunSkip, MissedRunRunOnce:
+	default:
+		return nil, invalidArgument("schedule %q: unknown missed runs policy %q", sched.Name, sched.MissedRuns)
+	}
+	if sched.StartingDeadline < 0 {
+		return nil, invalidArgument("schedule %q: starting deadline is negative: %v", sched.Name, sched.StartingDeadline)
+	}
+	switch {
+	case sched.HistoryLimit < 0:
+		return nil, invalidArgument("schedule %q: history limit is negative: %d", sched.Name, sched.HistoryLimit)
+	case sched.HistoryLimit == 0:
+		sched.HistoryLimit = defaultHistoryLimit
+	}
+	return cron, nil
+}
+
+type schedulesByName []ScheduleStatus
+
+func (s schedulesByName) Len() int           { return len(s) }
+func (s schedulesByName) Less(i, j int) bool { return s[i].Schedule.Name < s[j].Schedule.Name }
+func (s schedulesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type scheduled struct {
+	ag    *Agent
+	sched Schedule
+	cron  *cronExpr
+
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	mu      sync.Mutex
+	lastRun time.Time
+	history []*job
+}
+
+// loop launches runs when they're due until the schedule is canceled. If
+// missed isn't zero, a run that was due at that time is launched first.
+func (s *scheduled) loop(missed time.Time) {
+	if !missed.IsZero() {
+		s.launch(missed)
+	}
+	for {
+		now := time.Now()
+		next := s.cron.next(now)
+		if next.IsZero() {
+			s.ag.handleError(fmt.Errorf("schedule %q will never run again", s.sched.Name))
+			return
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.launch(next)
+		}
+	}
+}
+
+func (s *scheduled) launch(due time.Time) {
+	ll := log.KV("schedule", s.sched.Name).KV("due", due)
+	running := s.running()
+	switch {
+	case len(running) == 0:
+	case s.sched.Concurrency == ConcurrencyForbid:
+		ll.KV("running", len(running)).Info("previous run still going, skipping")
+		return
+	case s.sched.Concurrency == ConcurrencyReplace:
+		for _, job := range running {
+			job.stop(replaceStopTimeout)
+		}
+	}
+
+	job, err := s.ag.runJob(context.Background(), s.sched.Program, s.sched.Spec, s.sched.Job)
+	if err != nil {
+		s.ag.handleError(fmt.Errorf("launching run of schedule %q: %v", s.sched.Name, err))
+	}
+
+	s.mu.Lock()
+	s.lastRun = due
+	if job != nil {
+		s.history = append(s.history, job)
+		if over := len(s.history) - s.sched.HistoryLimit; over > 0 {
+			s.history = s.history[over:]
+		}
+	}
+	s.mu.Unlock()
+
+	if err := s.ag.saveSchedules(); err != nil {
+		s.ag.handleError(err)
+	}
+}
+
+func (s *scheduled) running() []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var running []*job
+	for _, job := range s.history {
+		if job.Status().State == JobRunning {
+			running = append(running, job)
+		}
+	}
+	return running
+}
+
+func (s *scheduled) snapshot() (time.Time, []*job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRun, append([]*job(nil), s.history...)
+}
+
+func (s *scheduled) status() ScheduleStatus {
+	lastRun, history := s.snapshot()
+	status := ScheduleStatus{
+		Schedule: s.sched,
+		LastRun:  lastRun,
+		NextRun:  s.cron.next(time.Now()),
+		History:  make([]JobStatus, 0, len(history)),
+	}
+	for _, job := range history {
+		status.History = append(status.History, job.Status())
+	}
+	return status
+}
+
+func (s *scheduled) cancel() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// missedRun returns the latest run that was due since the last one, if it
+// should be caught up according to the schedule's policy.
+func (s *scheduled) missedRun(now time.Time) time.Time {
+	if s.lastRun.IsZero() || s.sched.MissedRuns != MissedRunRunOnce {
+		return time.Time{}
+	}
+	var latest time.Time
+	for next := s.cron.next(s.lastRun); !next.IsZero() && !next.After(now); next = s.cron.next(next) {
+		latest = next
+	}
+	if latest.IsZero() {
+		return latest
+	}
+	if s.sched.StartingDeadline != 0 && now.Sub(latest) > s.sched.StartingDeadline {
+		return time.Time{} // too late to catch it up
+	}
+	return latest
+}
+
+/*
+ schedule persistence
+*/
+
+type scheduleState struct {
+	Schedule Schedule  `json:"schedule"`
+	LastRun  time.Time `json:"last_run"`
+}
+
+func (ag *Agent) saveSchedules() error {
+	if ag.stateDir == "" {
+		return nil
+	}
+	ag.stateMu.Lock()
+	defer ag.stateMu.Unlock()
+
+	ag.mu.Lock()
+	states := make([]scheduleState, 0, len(ag.schedules))
+	for _, s := range ag.schedules {
+		lastRun, _ := s.snapshot()
+		states = append(states, scheduleState{Schedule: s.sched, LastRun: lastRun})
+	}
+	ag.mu.Unlock()
+
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling schedules: %v", err)
+	}
+	path := filepath.Join(ag.stateDir, scheduleStateFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("saving schedules: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("saving schedules: %v", err)
+	}
+	return nil
+}
+
+// restoreSchedules resumes the schedules persisted in the state directory,
+// catching up the runs they missed.
+func (ag *Agent) restoreSchedules() error {
+	data, err := ioutil.ReadFile(filepath.Join(ag.stateDir, scheduleStateFile))
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("reading schedules: %v", err)
+	}
+	var states []scheduleState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("unmarshalling schedules: %v", err)
+	}
+
+	now := time.Now()
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	for _, state := range states {
+		sched := state.Schedule
+		cron, err := validSchedule(&sched)
+		if err != nil {
+			ag.handleError(fmt.Errorf("restoring schedule: %v", err))
+			continue
+		}
+		s := &scheduled{ag: ag, sched: sched, cron: cron, stop: make(chan struct{}), lastRun: state.LastRun}
+		ag.schedules[sched.Name] = s
+		go s.loop(s.missedRun(now))
+	}
+	return nil
+}
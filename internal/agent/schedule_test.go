@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+func TestMissedRun(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		cron     string
+		policy   MissedRunPolicy
+		lastRun  time.Time
+		deadline time.Duration
+		want     time.Time
+	}{
+		{"never ran", "0 * * * *", MissedRunRunOnce, time.Time{}, 0, time.Time{}},
+		{"skipped", "0 * * * *", MissedRunSkip, now.Add(-3 * time.Hour), 0, time.Time{}},
+		{"nothing missed", "0 * * * *", MissedRunRunOnce, now.Add(-10 * time.Minute), 0, time.Time{}},
+		{"latest of many", "0 * * * *", MissedRunRunOnce, now.Add(-5 * time.Hour), 0, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{"due right now", "30 * * * *", MissedRunRunOnce, now.Add(-time.Hour), 0, now},
+		{"within the deadline", "0 * * * *", MissedRunRunOnce, now.Add(-5 * time.Hour), time.Hour, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{"past the deadline", "0 * * * *", MissedRunRunOnce, now.Add(-5 * time.Hour), 10 * time.Minute, time.Time{}},
+		{"across days", "0 0 * * *", MissedRunRunOnce, now.AddDate(0, 0, -3), 0, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := parseCron(tt.cron)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &scheduled{
+				sched:   Schedule{Name: tt.name, MissedRuns: tt.policy, StartingDeadline: tt.deadline},
+				cron:    cron,
+				lastRun: tt.lastRun,
+			}
+			if got := s.missedRun(now); !got.Equal(tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRestoreSchedulesCatchesUp(t *testing.T) {
+	dir := t.TempDir()
+	cl := &fakeClient{}
+	states := []scheduleState{
+		{
+			Schedule: Schedule{Name: "caught-up", Program: cl.ProgramID("exit 0"), Cron: "@yearly", MissedRuns: MissedRunRunOnce},
+			LastRun:  time.Now().AddDate(-2, 0, 0),
+		},
+		{
+			Schedule: Schedule{Name: "skipped", Program: cl.ProgramID("exit 0"), Cron: "@yearly"},
+			LastRun:  time.Now().AddDate(-2, 0, 0),
+		},
+	}
+	data, err := json.Marshal(states)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, scheduleStateFile), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ag := newTestAgent(t, cl, WithStateDir(dir))
+	defer func() {
+		for _, name := range []string{"caught-up", "skipped"} {
+			_ = ag.Unschedule(name)
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		history, err := ag.ScheduleHistory("caught-up")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("missed run wasn't caught up")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	history, err := ag.ScheduleHistory("skipped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("want no run caught up, got %d", len(history))
+	}
+	schedules := ag.ListSchedules()
+	if len(schedules) != 2 || schedules[0].Schedule.Name != "caught-up" || schedules[1].Schedule.Name != "skipped" {
+		t.Fatalf("want both schedules restored, got %+v", schedules)
+	}
+	newYear := time.Date(time.Now().Year(), 1, 1, 0, 0, 0, 0, time.Local)
+	if !schedules[0].LastRun.Equal(newYear) {
+		t.Errorf("want the last run to be the one caught up, %v, got %v", newYear, schedules[0].LastRun)
+	}
+	if !schedules[1].LastRun.Equal(states[1].LastRun) {
+		t.Errorf("want the last run of a skipped schedule unchanged, got %v", schedules[1].LastRun)
+	}
+}
+
+func TestScheduleIsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl, WithStateDir(dir))
+	sched := Schedule{Name: "nightly", Program: cl.ProgramID("exit 0"), Cron: "@daily", MissedRuns: MissedRunRunOnce}
+	if err := ag.Schedule(sched); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ag.Unschedule("nightly") }()
+
+	restored := newTestAgent(t, cl, WithStateDir(dir))
+	defer func() { _ = restored.Unschedule("nightly") }()
+	schedules := restored.ListSchedules()
+	if len(schedules) != 1 {
+		t.Fatalf("want 1 schedule, got %d", len(schedules))
+	}
+	got := schedules[0].Schedule
+	if got.Name != sched.Name || got.Cron != sched.Cron || got.MissedRuns != sched.MissedRuns || got.HistoryLimit != defaultHistoryLimit {
+		t.Errorf("want %+v, got %+v", sched, got)
+	}
+
+	if err := ag.Unschedule("nightly"); err != nil {
+		t.Fatal(err)
+	}
+	if schedules := New(cl, WithStateDir(dir)).ListSchedules(); len(schedules) != 0 {
+		t.Errorf("want the schedule forgotten, got %+v", schedules)
+	}
+}
+
+func TestScheduleRejects(t *testing.T) {
+	cl := &fakeClient{}
+	ag := newTestAgent(t, cl)
+	for _, sched := range []Schedule{
+		{Program: cl.ProgramID("exit 0"), Cron: "@daily"},
+		{Name: "bad cron", Program: cl.ProgramID("exit 0"), Cron: "61 * * * *"},
+		{Name: "bad concurrency", Program: cl.ProgramID("exit 0"), Cron: "@daily", Concurrency: "sometimes"},
+		{Name: "bad missed runs", Program: cl.ProgramID("exit 0"), Cron: "@daily", MissedRuns: "all"},
+		{Name: "negative deadline", Program: cl.ProgramID("exit 0"), Cron: "@daily", StartingDeadline: -time.Minute},
+		{Name: "negative history", Program: cl.ProgramID("exit 0"), Cron: "@daily", HistoryLimit: -1},
+	} {
+		if err := ag.Schedule(sched); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%q: want %v, got %v", sched.Name, ErrInvalidArgument, err)
+		}
+	}
+	if err := ag.Unschedule("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want %v, got %v", ErrNotFound, err)
+	}
+	if _, err := ag.ScheduleHistory("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestLaunchConcurrency(t *testing.T) {
+	tests := []struct {
+		policy  ConcurrencyPolicy
+		runs    int
+		running int
+	}{
+		{ConcurrencyAllow, 2, 2},
+		{ConcurrencyForbid, 1, 1},
+		{ConcurrencyReplace, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			cl := &fakeClient{}
+			ag := newTestAgent(t, cl)
+			sched := Schedule{Name: "s", Program: cl.ProgramID("forever"), Cron: "@yearly", Concurrency: tt.policy, Spec: container.Spec{}}
+			cron, err := validSchedule(&sched)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &scheduled{ag: ag, sched: sched, cron: cron, stop: make(chan struct{})}
+			defer func() {
+				for _, job := range s.running() {
+					job.stop(time.Second)
+				}
+			}()
+
+			s.launch(time.Now())
+			for len(cl.createdProcs()) < 1 {
+				time.Sleep(time.Millisecond)
+			}
+			s.launch(time.Now())
+
+			_, history := s.snapshot()
+			if len(history) != tt.runs {
+				t.Fatalf("want %d runs, got %d", tt.runs, len(history))
+			}
+			if running := s.running(); len(running) != tt.running {
+				t.Fatalf("want %d runs going, got %d", tt.running, len(running))
+			}
+			if tt.policy == ConcurrencyReplace {
+				if state := history[0].Status().State; state != JobStopped {
+					t.Errorf("want the first run stopped, got %v", state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// Package backoff spaces out retries of something that keeps failing.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff doubles the delay between retries, from Min up to Max. Delays are
+// jittered so that many clients failing at once don't retry in lockstep.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	attempt uint
+}
+
+// Next returns how long to wait before the next retry.
+func (b *Backoff) Next() time.Duration {
+	delay := b.Max
+	if b.attempt < 32 {
+		if d := b.Min << b.attempt; d > 0 && d < b.Max {
+			delay = d
+		}
+	}
+	b.attempt++
+	// full jitter, but never less than half the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Reset the delay once retries succeed.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -1,14 +1,36 @@
 package docker
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aybabtme/deployotron/internal/container"
 	"github.com/fsouza/go-dockerclient"
 )
 
+// Backend is the name agents running Docker containers give their backend.
+const Backend = "docker"
+
+// Provider names programs the way a Docker client does, without a Docker
+// daemon to talk to.
+var Provider container.ProgramProvider = provider{}
+
+type provider struct{}
+
+func (provider) ProgramID(dockerImageName string) container.ProgramID {
+	return container.ProgramID(newProgramID(dockerImageName))
+}
+
+func (provider) Tag(dockerImageName, tag string) string {
+	return tagImage(dockerImageName, tag)
+}
+
 // New returns a container.Client implemented by Docker.
 func New(endpoint, registry string) (container.Client, error) {
 	dk, err := docker.NewClient(endpoint)
@@ -38,6 +60,10 @@ func (dk *client) ProgramID(dockerImageName string) container.ProgramID {
 	return container.ProgramID(newProgramID(dockerImageName))
 }
 
+func (dk *client) Tag(dockerImageName, tag string) string {
+	return tagImage(dockerImageName, tag)
+}
+
 func (dk *client) Programs() container.ProgramSvc  { return dk.programs }
 func (dk *client) Processes() container.ProcessSvc { return dk.processes }
 
@@ -47,12 +73,13 @@ type programSvc struct {
 	client *client
 }
 
-func (svc *programSvc) Pull(id container.ProgramID) (container.Program, error) {
+func (svc *programSvc) Pull(ctx context.Context, id container.ProgramID) (container.Program, error) {
 	pid := checkProgramID(id)
 	dk := svc.client.dk
 	opts := docker.PullImageOptions{
 		Repository: pid.ImageName(),
 		Registry:   svc.client.registry,
+		Context:    ctx,
 	}
 	auth := svc.client.auth
 	if err := dk.PullImage(opts, auth); err != nil {
@@ -65,7 +92,7 @@ func (svc *programSvc) Pull(id container.ProgramID) (container.Program, error) {
 	return program{img: *img}, nil
 }
 
-func (svc *programSvc) Get(id container.ProgramID) (container.Program, bool, error) {
+func (svc *programSvc) Get(ctx context.Context, id container.ProgramID) (container.Program, bool, error) {
 	pid := checkProgramID(id)
 	dk := svc.client.dk
 	img, err := dk.InspectImage(pid.ImageName())
@@ -79,10 +106,11 @@ func (svc *programSvc) Get(id container.ProgramID) (container.Program, bool, err
 	}
 }
 
-func (svc *programSvc) Remove(id container.ProgramID) error {
+func (svc *programSvc) Remove(ctx context.Context, id container.ProgramID) error {
 	pid := checkProgramID(id)
 	dk := svc.client.dk
-	if err := dk.RemoveImage(pid.ImageName()); err != nil {
+	opts := docker.RemoveImageOptions{Context: ctx}
+	if err := dk.RemoveImageExtended(pid.ImageName(), opts); err != nil {
 		return fmt.Errorf("removing docker image: %v", err)
 	}
 	return nil
@@ -118,6 +146,11 @@ func newProgramID(dockerImageName string) programID {
 	return programID("docker.program." + dockerImageName)
 }
 
+// tagImage names the image of a tag, the way `docker pull` wants it.
+func tagImage(dockerImageName, tag string) string {
+	return dockerImageName + ":" + tag
+}
+
 func (pid programID) ImageName() string {
 	return strings.TrimPrefix(string(pid), "docker.program.")
 }
@@ -128,16 +161,58 @@ type processSvc struct {
 	client *client
 }
 
-func (svc *processSvc) Create(prgm container.Program) (container.Process, error) {
+// secretRoot is where secret files are written on the host before being
+// mounted in containers, it should be a tmpfs so that they never touch a
+// disk. This requires the docker daemon to run on the same host.
+var secretRoot = "/dev/shm/deployotron"
+
+const (
+	// secretMount is where secret files appear inside containers.
+	secretMount = "/run/secrets"
+	// configMount is where rendered config files appear inside containers.
+	configMount = "/etc/deployotron"
+)
+
+func (svc *processSvc) Create(ctx context.Context, prgm container.Program, cfg container.ProcessConfig) (container.Process, error) {
 	dk := svc.client.dk
 	dkPrgm := checkProgram(prgm)
+
+	var env []string
+	for k, v := range cfg.Env {
+		env = append(env, k+"="+v)
+	}
+	secretDir, binds, err := writeSecrets(cfg.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range cfg.Secrets {
+		if secret.Env != "" {
+			env = append(env, secret.Env+"="+string(secret.Value))
+		}
+	}
+	if secretDir != "" {
+		env = append(env, "SECRETS_DIR="+secretMount)
+	}
+	if cfg.ConfigDir != "" {
+		env = append(env, "CONFIG_DIR="+configMount)
+		binds = append(binds, cfg.ConfigDir+":"+configMount+":ro")
+	}
+
 	opts := docker.CreateContainerOptions{
 		Config: &docker.Config{
 			Image: dkPrgm.id.ImageName(),
+			Env:   env,
 		},
+		HostConfig: &docker.HostConfig{
+			Binds:    binds,
+			Memory:   cfg.Limits.Memory,
+			NanoCPUs: int64(cfg.Limits.CPUs * 1e9),
+		},
+		Context: ctx,
 	}
 	container, err := dk.CreateContainer(opts)
 	if err != nil {
+		_ = removeSecrets(secretDir)
 		return nil, fmt.Errorf("creating docker container: %v", err)
 	}
 	return &process{
@@ -145,21 +220,52 @@ func (svc *processSvc) Create(prgm container.Program) (container.Process, error)
 		id:        procIDFromContainer(container),
 		prgm:      dkPrgm,
 		container: container,
+		secretDir: secretDir,
+		stdout:    cfg.Stdout,
+		stderr:    cfg.Stderr,
 	}, nil
 }
 
-func (svc *processSvc) Remove(proc container.Process) error {
+func (svc *processSvc) Remove(ctx context.Context, proc container.Process) error {
 	dk := svc.client.dk
 	dkProc := checkProcess(proc)
 	opts := docker.RemoveContainerOptions{
-		ID: dkProc.id.ContainerID(),
+		ID:      dkProc.id.ContainerID(),
+		Context: ctx,
 	}
 	if err := dk.RemoveContainer(opts); err != nil {
 		return fmt.Errorf("removing docker container: %v", err)
 	}
+	if err := removeSecrets(dkProc.secretDir); err != nil {
+		return fmt.Errorf("removing secret files: %v", err)
+	}
 	return nil
 }
 
+func writeSecrets(secrets []container.Secret) (dir string, binds []string, err error) {
+	if !container.HasSecretFiles(secrets) {
+		return "", nil, nil
+	}
+	if err := os.MkdirAll(secretRoot, 0700); err != nil {
+		return "", nil, fmt.Errorf("creating secret root: %v", err)
+	}
+	if dir, err = ioutil.TempDir(secretRoot, "docker"); err != nil {
+		return "", nil, fmt.Errorf("creating secret directory: %v", err)
+	}
+	if err := container.WriteSecretFiles(dir, secrets); err != nil {
+		_ = removeSecrets(dir)
+		return "", nil, err
+	}
+	return dir, []string{dir + ":" + secretMount + ":ro"}, nil
+}
+
+func removeSecrets(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
+
 type processID container.ProcessID
 
 func procIDFromContainer(dkCtnr *docker.Container) processID {
@@ -175,6 +281,9 @@ type process struct {
 	id        processID
 	prgm      program
 	container *docker.Container
+	secretDir string
+	stdout    io.Writer
+	stderr    io.Writer
 }
 
 func checkProcess(proc container.Process) *process {
@@ -188,18 +297,42 @@ func checkProcess(proc container.Process) *process {
 func (proc *process) ID() container.ProcessID    { return container.ProcessID(proc.id) }
 func (proc *process) Program() container.Program { return proc.prgm }
 
-func (proc *process) Start() error {
+func (proc *process) Start(ctx context.Context) error {
 	dk := proc.svc.client.dk
-	if err := dk.StartContainer(proc.id.ContainerID(), nil); err != nil {
+	if err := dk.StartContainerWithContext(proc.id.ContainerID(), nil, ctx); err != nil {
 		return fmt.Errorf("starting docker container: %v", err)
 	}
+	if proc.stdout != nil || proc.stderr != nil {
+		go proc.streamOutput()
+	}
 	return nil
 }
 
-func (proc *process) Stop(timeout time.Duration) error {
+// streamOutput copies the container's output until it stops.
+func (proc *process) streamOutput() {
+	opts := docker.LogsOptions{
+		Container:    proc.id.ContainerID(),
+		OutputStream: ioutil.Discard,
+		ErrorStream:  ioutil.Discard,
+		Follow:       true,
+		Stdout:       proc.stdout != nil,
+		Stderr:       proc.stderr != nil,
+		Tail:         "0",
+	}
+	if proc.stdout != nil {
+		opts.OutputStream = proc.stdout
+	}
+	if proc.stderr != nil {
+		opts.ErrorStream = proc.stderr
+	}
+	// the process doesn't care if we miss some output
+	_ = proc.svc.client.dk.Logs(opts)
+}
+
+func (proc *process) Stop(ctx context.Context, timeout time.Duration) error {
 	dk := proc.svc.client.dk
 	timeoutSec := uint(timeout.Seconds())
-	if err := dk.StopContainer(proc.id.ContainerID(), timeoutSec); err != nil {
+	if err := dk.StopContainerWithContext(proc.id.ContainerID(), timeoutSec, ctx); err != nil {
 		return fmt.Errorf("stopping docker container: %v", err)
 	}
 	return nil
@@ -213,11 +346,43 @@ func (proc *process) Kill() error {
 	return nil
 }
 
+func (proc *process) Signal(sig os.Signal) error {
+	sysSig, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal type %T", sig)
+	}
+	dk := proc.svc.client.dk
+	opts := docker.KillContainerOptions{ID: proc.id.ContainerID(), Signal: docker.Signal(sysSig)}
+	if err := dk.KillContainer(opts); err != nil {
+		return fmt.Errorf("signaling docker container with %v: %v", sig, err)
+	}
+	return nil
+}
+
+func (proc *process) Pause() error {
+	dk := proc.svc.client.dk
+	if err := dk.PauseContainer(proc.id.ContainerID()); err != nil {
+		return fmt.Errorf("pausing docker container: %v", err)
+	}
+	return nil
+}
+
+func (proc *process) Resume() error {
+	dk := proc.svc.client.dk
+	if err := dk.UnpauseContainer(proc.id.ContainerID()); err != nil {
+		return fmt.Errorf("unpausing docker container: %v", err)
+	}
+	return nil
+}
+
 func (proc *process) Wait() error {
 	dk := proc.svc.client.dk
-	// TODO(antoine): maybe someone cares about the exit code one day
-	if _, err := dk.WaitContainer(proc.id.ContainerID()); err != nil {
+	code, err := dk.WaitContainer(proc.id.ContainerID())
+	if err != nil {
 		return fmt.Errorf("waiting on docker container: %v", err)
 	}
+	if code != 0 {
+		return &container.ExitError{Code: code}
+	}
 	return nil
 }
@@ -0,0 +1,21 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+func TestProviderTagsImages(t *testing.T) {
+	tagger, ok := Provider.(container.ProgramTagger)
+	if !ok {
+		t.Fatal("want docker programs tagged")
+	}
+	name := tagger.Tag("redis", "6.2")
+	if name != "redis:6.2" {
+		t.Fatalf("want redis:6.2, got %q", name)
+	}
+	if image := checkProgramID(Provider.ProgramID(name)).ImageName(); image != "redis:6.2" {
+		t.Fatalf("want the image of the tag pulled, got %q", image)
+	}
+}
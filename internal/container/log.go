@@ -1,6 +1,8 @@
 package container
 
 import (
+	"context"
+	"os"
 	"time"
 
 	"github.com/aybabtme/log"
@@ -33,11 +35,11 @@ type logProgramSvc struct {
 	l    *log.Log
 }
 
-func (log *logProgramSvc) Pull(id ProgramID) (Program, error) {
+func (log *logProgramSvc) Pull(ctx context.Context, id ProgramID) (Program, error) {
 	ll := log.l.KV("program.id", id)
 	ll.Info("pulling program")
 
-	prgm, err := log.wrap.Pull(id)
+	prgm, err := log.wrap.Pull(ctx, id)
 	if err != nil {
 		ll.Err(err).Error("failed pulling program")
 	} else {
@@ -46,11 +48,11 @@ func (log *logProgramSvc) Pull(id ProgramID) (Program, error) {
 	return prgm, err
 }
 
-func (log *logProgramSvc) Get(id ProgramID) (Program, bool, error) {
+func (log *logProgramSvc) Get(ctx context.Context, id ProgramID) (Program, bool, error) {
 	ll := log.l.KV("program.id", id)
 	ll.Info("getting program")
 
-	prgm, ok, err := log.wrap.Get(id)
+	prgm, ok, err := log.wrap.Get(ctx, id)
 	if err != nil {
 		ll.Err(err).Error("failed getting program")
 	} else {
@@ -59,11 +61,11 @@ func (log *logProgramSvc) Get(id ProgramID) (Program, bool, error) {
 	return prgm, ok, err
 }
 
-func (log *logProgramSvc) Remove(id ProgramID) error {
+func (log *logProgramSvc) Remove(ctx context.Context, id ProgramID) error {
 	ll := log.l.KV("program.id", id)
 	ll.Info("removing program")
 
-	err := log.wrap.Remove(id)
+	err := log.wrap.Remove(ctx, id)
 	if err != nil {
 		ll.Err(err).Error("failed removing program")
 	}
@@ -75,11 +77,12 @@ type logProcessSvc struct {
 	l    *log.Log
 }
 
-func (log *logProcessSvc) Create(prgm Program) (Process, error) {
-	ll := log.l.KV("program.id", prgm.ID())
+func (log *logProcessSvc) Create(ctx context.Context, prgm Program, cfg ProcessConfig) (Process, error) {
+	// never log the config itself, it carries secret values
+	ll := log.l.KV("program.id", prgm.ID()).KV("secret.count", len(cfg.Secrets))
 	ll.Info("creating process")
 
-	proc, err := log.wrap.Create(prgm)
+	proc, err := log.wrap.Create(ctx, prgm, cfg)
 	if err != nil {
 		ll.Err(err).Error("failed creating process")
 		return nil, err
@@ -88,11 +91,14 @@ func (log *logProcessSvc) Create(prgm Program) (Process, error) {
 	return &logProcess{wrap: proc, l: ll}, err
 }
 
-func (log *logProcessSvc) Remove(proc Process) error {
+func (log *logProcessSvc) Remove(ctx context.Context, proc Process) error {
 	ll := log.l.KV("proc.id", proc.ID())
 	ll.Info("removing process")
 
-	err := log.wrap.Remove(proc)
+	if lproc, ok := proc.(*logProcess); ok {
+		proc = lproc.wrap // the wrapped svc only knows its own processes
+	}
+	err := log.wrap.Remove(ctx, proc)
 	if err != nil {
 		ll.Err(err).Error("failed removing process")
 		return err
@@ -109,9 +115,9 @@ type logProcess struct {
 func (log *logProcess) ID() ProcessID    { return log.wrap.ID() }
 func (log *logProcess) Program() Program { return log.wrap.Program() }
 
-func (log *logProcess) Start() error {
+func (log *logProcess) Start(ctx context.Context) error {
 	log.l.Info("starting process")
-	if err := log.wrap.Start(); err != nil {
+	if err := log.wrap.Start(ctx); err != nil {
 		log.l.Err(err).Error("failed starting process")
 		return err
 	}
@@ -120,9 +126,9 @@ func (log *logProcess) Start() error {
 	return nil
 }
 
-func (log *logProcess) Stop(timeout time.Duration) error {
+func (log *logProcess) Stop(ctx context.Context, timeout time.Duration) error {
 	log.l.Info("stopping process")
-	if err := log.wrap.Stop(timeout); err != nil {
+	if err := log.wrap.Stop(ctx, timeout); err != nil {
 		log.l.Err(err).Error("failed stopping process")
 		return err
 	}
@@ -140,6 +146,37 @@ func (log *logProcess) Kill() error {
 	return nil
 }
 
+func (log *logProcess) Signal(sig os.Signal) error {
+	ll := log.l.KV("signal", sig.String())
+	ll.Info("signaling process")
+	if err := log.wrap.Signal(sig); err != nil {
+		ll.Err(err).Error("failed signaling process")
+		return err
+	}
+	ll.Info("done signaling process")
+	return nil
+}
+
+func (log *logProcess) Pause() error {
+	log.l.Info("pausing process")
+	if err := log.wrap.Pause(); err != nil {
+		log.l.Err(err).Error("failed pausing process")
+		return err
+	}
+	log.l.Info("done pausing process")
+	return nil
+}
+
+func (log *logProcess) Resume() error {
+	log.l.Info("resuming process")
+	if err := log.wrap.Resume(); err != nil {
+		log.l.Err(err).Error("failed resuming process")
+		return err
+	}
+	log.l.Info("done resuming process")
+	return nil
+}
+
 func (log *logProcess) Wait() error {
 	log.l.Info("waiting for process")
 	if err := log.wrap.Wait(); err != nil {
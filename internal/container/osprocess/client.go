@@ -1,9 +1,12 @@
 package osprocess
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -12,6 +15,18 @@ import (
 	"github.com/pborman/uuid"
 )
 
+// Backend is the name agents running OS processes give their backend.
+const Backend = "osprocess"
+
+// Provider names programs the way a client does, without installing any.
+var Provider container.ProgramProvider = provider{}
+
+type provider struct{}
+
+func (provider) ProgramID(name string) container.ProgramID {
+	return container.ProgramID(newProgramID(name))
+}
+
 // Installer knows how to install programs in the $PATH.
 type Installer interface {
 	Install(name string) error
@@ -53,20 +68,23 @@ type programSvc struct {
 	client *client
 }
 
-func (svc *programSvc) Pull(id container.ProgramID) (container.Program, error) {
-	prgm, ok, _ := svc.Get(id)
+func (svc *programSvc) Pull(ctx context.Context, id container.ProgramID) (container.Program, error) {
+	prgm, ok, _ := svc.Get(ctx, id)
 	if ok {
 		return prgm, nil
 	}
 	prgmID := checkProgramID(id)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if err := svc.client.installer.Install(prgmID.Name()); err != nil {
 		return nil, fmt.Errorf("installing program %q: %v", prgmID.Name(), err)
 	}
-	prgm, _, err := svc.Get(id)
+	prgm, _, err := svc.Get(ctx, id)
 	return prgm, err
 }
 
-func (svc *programSvc) Get(id container.ProgramID) (container.Program, bool, error) {
+func (svc *programSvc) Get(ctx context.Context, id container.ProgramID) (container.Program, bool, error) {
 	prgmID := checkProgramID(id)
 
 	argv := strings.Split(prgmID.Name(), " ")
@@ -81,7 +99,7 @@ func (svc *programSvc) Get(id container.ProgramID) (container.Program, bool, err
 	return program{id: prgmID, path: path, argv: argv[1:]}, true, nil
 }
 
-func (svc *programSvc) Remove(id container.ProgramID) error {
+func (svc *programSvc) Remove(ctx context.Context, id container.ProgramID) error {
 	prgmID := checkProgramID(id)
 	return svc.client.installer.Uninstall(prgmID.Name())
 }
@@ -134,46 +152,117 @@ func newProcessID(uuid string) processID {
 }
 
 type process struct {
-	svc  *processSvc
-	id   processID
-	prgm program
-	cmd  *exec.Cmd
+	svc       *processSvc
+	id        processID
+	prgm      program
+	env       []string
+	secretDir string
+	stdout    io.Writer
+	stderr    io.Writer
+	cmd       *exec.Cmd
 }
 
 type processSvc struct {
 	client *client
 }
 
-func (svc *processSvc) Create(prgm container.Program) (container.Process, error) {
+// secretRoot is where secret files are written, it should be a tmpfs so
+// that they never touch a disk.
+var secretRoot = "/dev/shm/deployotron"
+
+func (svc *processSvc) Create(ctx context.Context, prgm container.Program, cfg container.ProcessConfig) (container.Process, error) {
 	osPrgm := checkProgram(prgm)
+	if cfg.Limits != (container.Limits{}) {
+		return nil, fmt.Errorf("can't limit the resources of OS processes")
+	}
 	uuid := uuid.New()
-	return &process{
+	proc := &process{
 		svc:  svc,
 		prgm: osPrgm,
 		id:   newProcessID(uuid),
-		cmd:  svc.create(osPrgm.path, osPrgm.argv),
-	}, nil
+		env:  os.Environ(),
+
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	if cfg.Stdout != nil {
+		proc.stdout = io.MultiWriter(proc.stdout, cfg.Stdout)
+	}
+	if cfg.Stderr != nil {
+		proc.stderr = io.MultiWriter(proc.stderr, cfg.Stderr)
+	}
+	for k, v := range cfg.Env {
+		proc.env = append(proc.env, k+"="+v)
+	}
+	if cfg.ConfigDir != "" {
+		proc.env = append(proc.env, "CONFIG_DIR="+cfg.ConfigDir)
+	}
+	if err := proc.injectSecrets(cfg.Secrets); err != nil {
+		_ = svc.Remove(ctx, proc)
+		return nil, err
+	}
+	proc.cmd = proc.command()
+	return proc, nil
+}
+
+func (svc *processSvc) Remove(ctx context.Context, proc container.Process) error {
+	osProc := checkProcess(proc)
+	if osProc.secretDir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(osProc.secretDir); err != nil {
+		return fmt.Errorf("removing secret files: %v", err)
+	}
+	return nil
+}
+
+func (proc *process) injectSecrets(secrets []container.Secret) error {
+	for _, secret := range secrets {
+		if secret.Env != "" {
+			proc.env = append(proc.env, secret.Env+"="+string(secret.Value))
+		}
+	}
+	if !container.HasSecretFiles(secrets) {
+		return nil
+	}
+	// the directory is removed along with the process if writing fails
+	proc.secretDir = filepath.Join(secretRoot, proc.id.UUID())
+	if err := os.MkdirAll(proc.secretDir, 0700); err != nil {
+		return fmt.Errorf("creating secret directory: %v", err)
+	}
+	proc.env = append(proc.env, "SECRETS_DIR="+proc.secretDir)
+	return container.WriteSecretFiles(proc.secretDir, secrets)
 }
 
-func (svc *processSvc) Remove(proc container.Process) error { return nil } // nothing to do
+func checkProcess(proc container.Process) *process {
+	osProc, ok := proc.(*process)
+	if !ok {
+		panic(fmt.Sprintf("bad container.Process, want %T got %T", &process{}, proc))
+	}
+	return osProc
+}
 
-func (svc *processSvc) create(path string, argv []string) *exec.Cmd {
-	cmd := exec.Command(path, argv...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func (proc *process) command() *exec.Cmd {
+	cmd := exec.Command(proc.prgm.path, proc.prgm.argv...)
+	cmd.Env = proc.env
+	cmd.Stdout = proc.stdout
+	cmd.Stderr = proc.stderr
 	return cmd
 }
 
 func (proc *process) ID() container.ProcessID    { return container.ProcessID(proc.id) }
 func (proc *process) Program() container.Program { return proc.prgm }
 
-func (proc *process) Start() error {
+func (proc *process) Start(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if proc.cmd.Process != nil {
 		if err := proc.cmd.Process.Release(); err != nil {
 			return fmt.Errorf("releasing OS process before starting: %v", err)
 		}
 
-		proc.cmd = proc.svc.create(proc.prgm.path, proc.prgm.argv)
+		proc.cmd = proc.command()
 	}
 
 	if err := proc.cmd.Start(); err != nil {
@@ -182,7 +271,7 @@ func (proc *process) Start() error {
 	return nil
 }
 
-func (proc *process) Stop(timeout time.Duration) error {
+func (proc *process) Stop(ctx context.Context, timeout time.Duration) error {
 	err := proc.cmd.Process.Signal(syscall.SIGTERM)
 	if err != nil {
 		return fmt.Errorf("stopping OS process with SIGTERM: %v", err)
@@ -197,8 +286,32 @@ func (proc *process) Kill() error {
 	return nil
 }
 
+func (proc *process) Signal(sig os.Signal) error {
+	if err := proc.cmd.Process.Signal(sig); err != nil {
+		return fmt.Errorf("signaling OS process with %v: %v", sig, err)
+	}
+	return nil
+}
+
+func (proc *process) Pause() error {
+	if err := proc.cmd.Process.Signal(syscall.SIGSTOP); err != nil {
+		return fmt.Errorf("pausing OS process with SIGSTOP: %v", err)
+	}
+	return nil
+}
+
+func (proc *process) Resume() error {
+	if err := proc.cmd.Process.Signal(syscall.SIGCONT); err != nil {
+		return fmt.Errorf("resuming OS process with SIGCONT: %v", err)
+	}
+	return nil
+}
+
 func (proc *process) Wait() error {
-	if err := proc.cmd.Wait(); err != nil {
+	err := proc.cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return &container.ExitError{Code: exitErr.ExitCode()}
+	} else if err != nil {
 		return fmt.Errorf("waiting for OS process: %v", err)
 	}
 	return nil
@@ -0,0 +1,117 @@
+package osprocess
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+func withSecretRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	prev := secretRoot
+	secretRoot = root
+	t.Cleanup(func() { secretRoot = prev })
+	return root
+}
+
+func createProcess(t *testing.T, cfg container.ProcessConfig) (container.ProcessSvc, container.Process, error) {
+	t.Helper()
+	cl := New(NopInstaller())
+	ctx := context.Background()
+	prgm, ok, err := cl.Programs().Get(ctx, cl.ProgramID("true"))
+	if err != nil || !ok {
+		t.Skipf("true isn't available: %v", err)
+	}
+	proc, err := cl.Processes().Create(ctx, prgm, cfg)
+	return cl.Processes(), proc, err
+}
+
+func TestCreateInjectsSecrets(t *testing.T) {
+	root := withSecretRoot(t)
+	svc, proc, err := createProcess(t, container.ProcessConfig{Secrets: []container.Secret{
+		{SecretRef: container.SecretRef{Name: "db", Env: "DB_PASSWORD"}, Value: []byte("hunter2")},
+		{SecretRef: container.SecretRef{Name: "key", File: "tls/key.pem"}, Value: []byte("---key---")},
+		{SecretRef: container.SecretRef{Name: "cert", File: "cert.pem", Env: "CERT"}, Value: []byte("---cert---")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	osProc := checkProcess(proc)
+	env := make(map[string]bool)
+	for _, kv := range osProc.env {
+		env[kv] = true
+	}
+	for _, want := range []string{"DB_PASSWORD=hunter2", "CERT=---cert---", "SECRETS_DIR=" + osProc.secretDir} {
+		if !env[want] {
+			t.Errorf("want %q in the environment", want)
+		}
+	}
+	if filepath.Dir(osProc.secretDir) != root {
+		t.Errorf("want secrets under %q, got %q", root, osProc.secretDir)
+	}
+	for file, want := range map[string]string{"tls/key.pem": "---key---", "cert.pem": "---cert---"} {
+		got, err := ioutil.ReadFile(filepath.Join(osProc.secretDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: want %q, got %q", file, want, got)
+		}
+	}
+
+	if err := svc.Remove(context.Background(), proc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(osProc.secretDir); !os.IsNotExist(err) {
+		t.Errorf("want secret dir removed, got %v", err)
+	}
+}
+
+func TestCreateWithoutSecretFiles(t *testing.T) {
+	root := withSecretRoot(t)
+	_, proc, err := createProcess(t, container.ProcessConfig{Secrets: []container.Secret{
+		{SecretRef: container.SecretRef{Name: "db", Env: "DB_PASSWORD"}, Value: []byte("hunter2")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir := checkProcess(proc).secretDir; dir != "" {
+		t.Errorf("want no secret dir, got %q", dir)
+	}
+	if entries, _ := ioutil.ReadDir(root); len(entries) != 0 {
+		t.Errorf("want nothing under the secret root, got %d entries", len(entries))
+	}
+}
+
+func TestCreateRejectsCollidingSecretFiles(t *testing.T) {
+	root := withSecretRoot(t)
+	_, _, err := createProcess(t, container.ProcessConfig{Secrets: []container.Secret{
+		{SecretRef: container.SecretRef{Name: "a", File: "one/key"}, Value: []byte("a")},
+		{SecretRef: container.SecretRef{Name: "b", File: "one/key"}, Value: []byte("b")},
+	}})
+	if err == nil {
+		t.Fatal("want an error")
+	}
+	if entries, _ := ioutil.ReadDir(root); len(entries) != 0 {
+		t.Errorf("want the secret dir removed, got %d entries", len(entries))
+	}
+}
+
+func TestCreateRefusesLimits(t *testing.T) {
+	withSecretRoot(t)
+	_, _, err := createProcess(t, container.ProcessConfig{Limits: container.Limits{Memory: 1 << 20}})
+	if err == nil {
+		t.Fatal("want an error")
+	}
+}
+
+func TestProviderNamesLikeClient(t *testing.T) {
+	if got, want := Provider.ProgramID("app"), New(NopInstaller()).ProgramID("app"); got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,91 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateSecrets tells if secrets can be handed to a process: they're all
+// named, and those handed as files each get a file of their own, at a path
+// relative to the secret directory.
+func ValidateSecrets(refs []SecretRef) error {
+	files := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		if ref.Name == "" {
+			return fmt.Errorf("secret has no name")
+		}
+		if ref.File == "" {
+			continue
+		}
+		file, err := secretFile(ref.File)
+		if err != nil {
+			return fmt.Errorf("secret %q: %v", ref.Name, err)
+		}
+		if other, ok := files[file]; ok {
+			return fmt.Errorf("secrets %q and %q are both written to %q", other, ref.Name, file)
+		}
+		files[file] = ref.Name
+	}
+	for _, ref := range refs {
+		if ref.File == "" {
+			continue
+		}
+		file, _ := secretFile(ref.File)
+		for dir := filepath.Dir(file); dir != "."; dir = filepath.Dir(dir) {
+			if other, ok := files[dir]; ok {
+				return fmt.Errorf("secret %q is written under %q, the file of secret %q", ref.Name, dir, other)
+			}
+		}
+	}
+	return nil
+}
+
+// WriteSecretFiles writes the secrets handed as files under dir, at the path
+// they ask for. The files can only be read by their owner.
+func WriteSecretFiles(dir string, secrets []Secret) error {
+	refs := make([]SecretRef, 0, len(secrets))
+	for _, secret := range secrets {
+		refs = append(refs, secret.SecretRef)
+	}
+	if err := ValidateSecrets(refs); err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		if secret.File == "" {
+			continue
+		}
+		file, _ := secretFile(secret.File)
+		filename := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return fmt.Errorf("creating directory for %v: %v", secret, err)
+		}
+		if err := ioutil.WriteFile(filename, secret.Value, 0400); err != nil {
+			return fmt.Errorf("writing secret file for %v: %v", secret, err)
+		}
+	}
+	return nil
+}
+
+// HasSecretFiles tells if any of the secrets is handed as a file.
+func HasSecretFiles(secrets []Secret) bool {
+	for _, secret := range secrets {
+		if secret.File != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func secretFile(name string) (string, error) {
+	clean := filepath.Clean(name)
+	switch {
+	case filepath.IsAbs(clean):
+		return "", fmt.Errorf("file %q isn't relative to the secret directory", name)
+	case clean == ".", clean == "..", strings.HasPrefix(clean, ".."+string(filepath.Separator)):
+		return "", fmt.Errorf("file %q isn't within the secret directory", name)
+	}
+	return clean, nil
+}
@@ -0,0 +1,99 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSecrets(t *testing.T) {
+	tests := []struct {
+		name string
+		refs []SecretRef
+		err  string // empty if valid
+	}{
+		{"none", nil, ""},
+		{"env only", []SecretRef{{Name: "a", Env: "A"}, {Name: "b", Env: "B"}}, ""},
+		{"templates only", []SecretRef{{Name: "a"}}, ""},
+		{"files", []SecretRef{{Name: "a", File: "a"}, {Name: "b", File: "tls/b.pem"}, {Name: "c", File: "tls/c.pem"}}, ""},
+		{"no name", []SecretRef{{File: "a"}}, "no name"},
+		{"same file", []SecretRef{{Name: "a", File: "x"}, {Name: "b", File: "x"}}, `secrets "a" and "b" are both written to "x"`},
+		{"same file once cleaned", []SecretRef{{Name: "a", File: "d/x"}, {Name: "b", File: "d/./x"}}, "both written"},
+		{"same basename", []SecretRef{{Name: "a", File: "one/x"}, {Name: "b", File: "two/x"}}, ""},
+		{"file is a dir of another", []SecretRef{{Name: "a", File: "d/x"}, {Name: "b", File: "d"}}, `secret "a" is written under "d", the file of secret "b"`},
+		{"dot", []SecretRef{{Name: "a", File: "."}}, "isn't within"},
+		{"dot dot", []SecretRef{{Name: "a", File: ".."}}, "isn't within"},
+		{"escapes", []SecretRef{{Name: "a", File: "d/../../x"}}, "isn't within"},
+		{"absolute", []SecretRef{{Name: "a", File: "/etc/passwd"}}, "isn't relative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSecrets(tt.refs)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("want no error, got %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("want an error with %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("want an error with %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestWriteSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secrets := []Secret{
+		{SecretRef: SecretRef{Name: "a", File: "a.txt"}, Value: []byte("value of a")},
+		{SecretRef: SecretRef{Name: "b", File: "tls/key.pem"}, Value: []byte("value of b")},
+		{SecretRef: SecretRef{Name: "c", Env: "C"}, Value: []byte("value of c")},
+	}
+	if err := WriteSecretFiles(dir, secrets); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secrets[:2] {
+		filename := filepath.Join(dir, secret.File)
+		got, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(secret.Value) {
+			t.Errorf("%s: want %q, got %q", secret.File, secret.Value, got)
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != 0400 {
+			t.Errorf("%s: want mode 0400, got %v", secret.File, mode)
+		}
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("want only a.txt and tls in the secret dir, got %d entries", len(entries))
+	}
+}
+
+func TestWriteSecretFilesRejectsCollisions(t *testing.T) {
+	dir := t.TempDir()
+	secrets := []Secret{
+		{SecretRef: SecretRef{Name: "a", File: "x"}, Value: []byte("a")},
+		{SecretRef: SecretRef{Name: "b", File: "x"}, Value: []byte("b")},
+	}
+	if err := WriteSecretFiles(dir, secrets); err == nil {
+		t.Fatal("want an error")
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("want nothing written, got %d entries", len(entries))
+	}
+}
+
+func TestSecretRedactsItself(t *testing.T) {
+	secret := Secret{SecretRef: SecretRef{Name: "db"}, Value: []byte("hunter2")}
+	for _, s := range []string{secret.String(), secret.GoString()} {
+		if strings.Contains(s, "hunter2") {
+			t.Errorf("secret value leaked in %q", s)
+		}
+	}
+}
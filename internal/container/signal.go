@@ -0,0 +1,56 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGABRT":  syscall.SIGABRT,
+	"SIGALRM":  syscall.SIGALRM,
+	"SIGCONT":  syscall.SIGCONT,
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGKILL":  syscall.SIGKILL,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGSTOP":  syscall.SIGSTOP,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGTSTP":  syscall.SIGTSTP,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGWINCH": syscall.SIGWINCH,
+}
+
+// ParseSignal parses a signal from its name, with or without the SIG
+// prefix, or from its number. Signals that CheckSignal refuses are refused.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid signal number: %d", n)
+		}
+		return syscall.Signal(n), CheckSignal(syscall.Signal(n))
+	}
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "SIG") {
+		upper = "SIG" + upper
+	}
+	sig, ok := signals[upper]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal: %q", name)
+	}
+	return sig, CheckSignal(sig)
+}
+
+// CheckSignal tells if a signal can be sent to a process. Signals that
+// freeze or thaw processes can't: processes are paused and resumed with
+// Process.Pause and Process.Resume, which their owner keeps track of.
+func CheckSignal(sig os.Signal) error {
+	switch sig {
+	case syscall.SIGSTOP, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGCONT:
+		return fmt.Errorf("signal %v freezes or thaws processes, pause or resume them instead", sig)
+	}
+	return nil
+}
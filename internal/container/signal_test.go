@@ -0,0 +1,39 @@
+package container
+
+import (
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name string
+		want syscall.Signal
+		ok   bool
+	}{
+		{"SIGHUP", syscall.SIGHUP, true},
+		{"hup", syscall.SIGHUP, true},
+		{"Usr1", syscall.SIGUSR1, true},
+		{"15", syscall.SIGTERM, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"SIGNOPE", 0, false},
+		{"", 0, false},
+		{"SIGSTOP", 0, false},
+		{"cont", 0, false},
+		{"tstp", 0, false},
+		{strconv.Itoa(int(syscall.SIGSTOP)), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignal(tt.name)
+			if tt.ok != (err == nil) {
+				t.Fatalf("want ok=%v, got %v", tt.ok, err)
+			}
+			if tt.ok && got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,13 +1,26 @@
 // Package container abstracts what we want to do with containers.
 package container
 
-import "time"
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
 
 // A ProgramProvider can instantiate a ProgramID from a name.
 type ProgramProvider interface {
 	ProgramID(name string) ProgramID
 }
 
+// A ProgramTagger is a ProgramProvider whose programs have versions, the way
+// images have tags.
+type ProgramTagger interface {
+	ProgramProvider
+	Tag(name, version string) string
+}
+
 // A Client knows how to do container stuff.
 type Client interface {
 	ProgramProvider
@@ -15,17 +28,19 @@ type Client interface {
 	Processes() ProcessSvc
 }
 
-// An ProgramSvc exposes facilities to interact with programs.
+// An ProgramSvc exposes facilities to interact with programs. Its calls give
+// up once their context is done.
 type ProgramSvc interface {
-	Pull(id ProgramID) (Program, error)
-	Get(id ProgramID) (Program, bool, error)
-	Remove(id ProgramID) error
+	Pull(ctx context.Context, id ProgramID) (Program, error)
+	Get(ctx context.Context, id ProgramID) (Program, bool, error)
+	Remove(ctx context.Context, id ProgramID) error
 }
 
-// A ProcessSvc is a service to interact with processes.
+// A ProcessSvc is a service to interact with processes. Its calls give up
+// once their context is done.
 type ProcessSvc interface {
-	Create(Program) (Process, error)
-	Remove(Process) error
+	Create(context.Context, Program, ProcessConfig) (Process, error)
+	Remove(context.Context, Process) error
 }
 
 // A ProgramID uniquely identifies a Program.
@@ -39,12 +54,148 @@ type Program interface {
 // A ProcessID uniquely idenfities a Process.
 type ProcessID string
 
-// A Process is the running execution of a program.
+// A Process is the running execution of a program. Starting and stopping it
+// give up once their context is done, but the process itself doesn't depend
+// on the context.
 type Process interface {
 	ID() ProcessID // The ID must be stable across restarts
 	Program() Program
-	Start() error
-	Stop(time.Duration) error
+	Start(context.Context) error
+	Stop(context.Context, time.Duration) error
 	Kill() error
+	Signal(os.Signal) error
+	Pause() error
+	Resume() error
 	Wait() error
 }
+
+// A Spec describes how processes of a program should be instantiated. Unlike
+// a ProcessConfig, it never carries secret values, only references to them.
+type Spec struct {
+	Env     map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	Secrets []SecretRef       `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Ports   map[string]int    `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Files   []ConfigFile      `json:"files,omitempty" yaml:"files,omitempty"`
+	Limits  Limits            `json:"limits,omitempty" yaml:"limits,omitempty"`
+
+	// ReloadSignal is sent to processes to reload them in place, see
+	// ParseSignal for the accepted values.
+	ReloadSignal string `json:"reload_signal,omitempty" yaml:"reload_signal,omitempty"`
+
+	// KeepAlive tells the agent when to restart processes that exit.
+	KeepAlive KeepAlive `json:"keep_alive,omitempty" yaml:"keep_alive,omitempty"`
+}
+
+// A ConfigFile is a text/template rendered for every process of a program,
+// at Path relative to the process' config directory.
+type ConfigFile struct {
+	Path     string `json:"path" yaml:"path"`
+	Template string `json:"template" yaml:"template"`
+}
+
+// A SecretRef references a secret by name and tells how to hand it to a
+// process: as an environment variable, as a file, or both. A secret that is
+// neither is only available to config templates.
+type SecretRef struct {
+	Name string `json:"name" yaml:"name"`
+	Env  string `json:"env,omitempty" yaml:"env,omitempty"`
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+// Limits bound the resources of a process. Zero means unbounded.
+type Limits struct {
+	Memory int64   `json:"memory,omitempty" yaml:"memory,omitempty"` // in bytes
+	CPUs   float64 `json:"cpus,omitempty" yaml:"cpus,omitempty"`     // in cores
+}
+
+// Validate tells if the limits make sense.
+func (limits Limits) Validate() error {
+	if limits.Memory < 0 {
+		return fmt.Errorf("memory limit can't be negative")
+	}
+	if limits.CPUs < 0 {
+		return fmt.Errorf("CPU limit can't be negative")
+	}
+	return nil
+}
+
+// The modes a KeepAlive restarts processes in.
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+// A KeepAlive tells when processes that exit are restarted. By default, they
+// always are, right away and as many times as needed.
+type KeepAlive struct {
+	Restart string `json:"restart,omitempty" yaml:"restart,omitempty"`
+	// MaxRestarts gives up on a process that restarted that many times
+	// in a row without staying up, unless it's 0.
+	MaxRestarts int `json:"max_restarts,omitempty" yaml:"max_restarts,omitempty"`
+	// MaxBackoff spaces out the restarts of a process that keeps exiting,
+	// doubling the delay up to MaxBackoff, unless it's 0.
+	MaxBackoff time.Duration `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty"`
+}
+
+// Validate tells if the keep-alive policy makes sense.
+func (ka KeepAlive) Validate() error {
+	switch ka.Restart {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("unknown restart mode %q", ka.Restart)
+	}
+	if ka.MaxRestarts < 0 {
+		return fmt.Errorf("max restarts can't be negative")
+	}
+	if ka.MaxBackoff < 0 {
+		return fmt.Errorf("max backoff can't be negative")
+	}
+	return nil
+}
+
+// Restarts tells if a process that exited is restarted, whether it failed
+// or not.
+func (ka KeepAlive) Restarts(failed bool) bool {
+	switch ka.Restart {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return failed
+	default:
+		return true
+	}
+}
+
+// A ProcessConfig is what a ProcessSvc needs to create a Process.
+type ProcessConfig struct {
+	Env       map[string]string
+	Secrets   []Secret
+	ConfigDir string // rendered config files, if any
+	Limits    Limits
+
+	// Stdout and Stderr receive a copy of the process' output, if set.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// An ExitError is returned by Process.Wait when a process exits with a
+// non-zero status.
+type ExitError struct {
+	Code int
+}
+
+func (err *ExitError) Error() string { return fmt.Sprintf("exit status %d", err.Code) }
+
+// A Secret is the resolved value of a SecretRef. Its value must never be
+// logged, so it redacts itself when formatted.
+type Secret struct {
+	SecretRef
+	Value []byte
+}
+
+// String implements fmt.Stringer without revealing the secret value.
+func (s Secret) String() string { return fmt.Sprintf("secret(%s)", s.Name) }
+
+// GoString implements fmt.GoStringer without revealing the secret value.
+func (s Secret) GoString() string { return s.String() }
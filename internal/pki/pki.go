@@ -0,0 +1,291 @@
+// Package pki sets up mutual TLS between agents and supervisors, and
+// bootstraps the certificate authority they trust.
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+)
+
+// ServerConfig returns a TLS config for a supervisor, which requires clients
+// to present a certificate signed by the CA.
+func ServerConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	pool, cert, err := load(caPath, certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig returns a TLS config for an agent, which presents its
+// certificate and only trusts supervisors signed by the CA.
+func ClientConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	pool, cert, err := load(caPath, certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Identity returns the name a certificate identifies.
+func Identity(cert *x509.Certificate) string {
+	return cert.Subject.CommonName
+}
+
+// CertIdentity returns the name the leaf of a TLS certificate identifies.
+func CertIdentity(cert tls.Certificate) (string, error) {
+	if len(cert.Certificate) == 0 {
+		return "", fmt.Errorf("empty certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", fmt.Errorf("parsing certificate: %v", err)
+	}
+	return Identity(leaf), nil
+}
+
+// PeerIdentity completes the TLS handshake on a connection and returns the
+// name the peer's certificate identifies. It returns false if the connection
+// isn't using TLS, or if the peer presented no certificate.
+func PeerIdentity(cc net.Conn) (string, bool, error) {
+	tlsConn, ok := cc.(*tls.Conn)
+	if !ok {
+		return "", false, nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", false, fmt.Errorf("TLS handshake: %v", err)
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", false, nil
+	}
+	return Identity(certs[0]), true, nil
+}
+
+func load(caPath, certPath, keyPath string) (*x509.CertPool, tls.Certificate, error) {
+	caPEM, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("reading CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, tls.Certificate{}, fmt.Errorf("no certificate found in %q", caPath)
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("loading certificate: %v", err)
+	}
+	return pool, cert, nil
+}
+
+/*
+ certificate authority
+*/
+
+// InitCA creates a new certificate authority in dir.
+func InitCA(dir, name string) error {
+	if _, err := os.Stat(filepath.Join(dir, caKeyFile)); err == nil {
+		return fmt.Errorf("a CA already exists in %q", dir)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating CA key: %v", err)
+	}
+	tmpl, err := template(name, caValidity)
+	if err != nil {
+		return err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("self-signing CA certificate: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating CA directory: %v", err)
+	}
+	return write(dir, caCertFile, caKeyFile, der, key)
+}
+
+// Role is the end of a connection a certificate is valid for.
+type Role int
+
+const (
+	// RoleAgent certificates authenticate agents to supervisors.
+	RoleAgent Role = iota
+	// RoleSupervisor certificates authenticate supervisors to agents.
+	RoleSupervisor
+)
+
+func (role Role) String() string {
+	switch role {
+	case RoleAgent:
+		return "agent"
+	case RoleSupervisor:
+		return "supervisor"
+	}
+	return fmt.Sprintf("Role(%d)", int(role))
+}
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, error) {
+	for _, role := range []Role{RoleAgent, RoleSupervisor} {
+		if role.String() == s {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q, want agent or supervisor", s)
+}
+
+// Issue a certificate for name, signed by the CA in dir. Agent certificates
+// are only valid as clients, supervisor certificates only as servers; hosts
+// are the DNS names and IPs a supervisor can be reached at.
+func Issue(dir, name string, role Role, hosts []string) (certPath, keyPath string, err error) {
+	if err := validName(name); err != nil {
+		return "", "", err
+	}
+	var usage x509.ExtKeyUsage
+	switch role {
+	case RoleAgent:
+		if len(hosts) != 0 {
+			return "", "", fmt.Errorf("agent certificates aren't valid for hosts")
+		}
+		usage = x509.ExtKeyUsageClientAuth
+	case RoleSupervisor:
+		usage = x509.ExtKeyUsageServerAuth
+	default:
+		return "", "", fmt.Errorf("unknown role %v", role)
+	}
+	caCert, caKey, err := loadCA(dir)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generating key: %v", err)
+	}
+	tmpl, err := template(name, certValidity)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", fmt.Errorf("signing certificate: %v", err)
+	}
+	certFile, keyFile := name+".crt", name+".key"
+	if err := write(dir, certFile, keyFile, der, key); err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, certFile), filepath.Join(dir, keyFile), nil
+}
+
+// validName checks that the files of a certificate for name are written in
+// the CA's directory, and don't replace the CA's own.
+func validName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("a name is required")
+	case name == "." || name == ".." || strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("name %q isn't a file name", name)
+	case name+".crt" == caCertFile || name+".key" == caKeyFile:
+		return fmt.Errorf("name %q is the CA's", name)
+	}
+	return nil
+}
+
+func template(name string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial number: %v", err)
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"deployotron"}},
+		NotBefore:    now.Add(-time.Hour), // tolerate some clock skew
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func loadCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := ioutil.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CA certificate: %v", err)
+	}
+	keyPEM, err := ioutil.ReadFile(filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CA key: %v", err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("CA in %q isn't PEM encoded", dir)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing CA certificate: %v", err)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing CA key: %v", err)
+	}
+	return cert, key, nil
+}
+
+func write(dir, certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshalling key: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, keyFile), keyPEM, 0600); err != nil {
+		return fmt.Errorf("writing key: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, certFile), certPEM, 0644); err != nil {
+		return fmt.Errorf("writing certificate: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func initCA(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := InitCA(dir, "test CA"); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func loadLeaf(t *testing.T, certPath, keyPath string) *x509.Certificate {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestIssue(t *testing.T) {
+	dir := initCA(t)
+	if err := InitCA(dir, "again"); err == nil {
+		t.Error("want an error replacing a CA")
+	}
+
+	tests := []struct {
+		name  string
+		role  Role
+		hosts []string
+		usage x509.ExtKeyUsage
+	}{
+		{"agent-1", RoleAgent, nil, x509.ExtKeyUsageClientAuth},
+		{"supervisor-1", RoleSupervisor, []string{"localhost", "127.0.0.1"}, x509.ExtKeyUsageServerAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role.String(), func(t *testing.T) {
+			certPath, keyPath, err := Issue(dir, tt.name, tt.role, tt.hosts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if certPath != filepath.Join(dir, tt.name+".crt") || keyPath != filepath.Join(dir, tt.name+".key") {
+				t.Errorf("want the certificate in %q, got %q and %q", dir, certPath, keyPath)
+			}
+			info, err := os.Stat(keyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("want the key readable by its owner only, got %v", info.Mode())
+			}
+			leaf := loadLeaf(t, certPath, keyPath)
+			if Identity(leaf) != tt.name {
+				t.Errorf("want identity %q, got %q", tt.name, Identity(leaf))
+			}
+			if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != tt.usage {
+				t.Errorf("want usage %v, got %v", tt.usage, leaf.ExtKeyUsage)
+			}
+			if len(leaf.DNSNames)+len(leaf.IPAddresses) != len(tt.hosts) {
+				t.Errorf("want hosts %v, got %v and %v", tt.hosts, leaf.DNSNames, leaf.IPAddresses)
+			}
+		})
+	}
+}
+
+func TestIssueRejects(t *testing.T) {
+	dir := initCA(t)
+	tests := []struct {
+		name  string
+		role  Role
+		hosts []string
+	}{
+		{"", RoleAgent, nil},
+		{".", RoleAgent, nil},
+		{"..", RoleAgent, nil},
+		{"../x", RoleAgent, nil},
+		{"a/b", RoleAgent, nil},
+		{`a\b`, RoleAgent, nil},
+		{"ca", RoleSupervisor, nil},
+		{"agent", RoleAgent, []string{"localhost"}},
+		{"agent", Role(7), nil},
+	}
+	for _, tt := range tests {
+		if _, _, err := Issue(dir, tt.name, tt.role, tt.hosts); err == nil {
+			t.Errorf("%q as %v: want an error", tt.name, tt.role)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "x.crt")); !os.IsNotExist(err) {
+		t.Errorf("want nothing written outside the CA, got %v", err)
+	}
+	if _, _, err := Issue(t.TempDir(), "agent", RoleAgent, nil); err == nil {
+		t.Error("want an error without a CA")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []Role{RoleAgent, RoleSupervisor} {
+		got, err := ParseRole(role.String())
+		if err != nil || got != role {
+			t.Errorf("want %v, got %v, %v", role, got, err)
+		}
+	}
+	if _, err := ParseRole("admin"); err == nil {
+		t.Error("want an error for an unknown role")
+	}
+}
+
+// handshake an agent and a supervisor with the certificates named, returning
+// the identity each side sees.
+func handshake(t *testing.T, dir, agent, supervisor string) (agentSees, supervisorSees string, err error) {
+	t.Helper()
+	caPath := filepath.Join(dir, caCertFile)
+	clientCfg, err := ClientConfig(caPath, filepath.Join(dir, agent+".crt"), filepath.Join(dir, agent+".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCfg.ServerName = "localhost"
+	serverCfg, err := ServerConfig(caPath, filepath.Join(dir, supervisor+".crt"), filepath.Join(dir, supervisor+".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		name string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		cc, err := ln.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer cc.Close()
+		sc := tls.Server(cc, serverCfg)
+		name, _, err := PeerIdentity(sc)
+		if err == nil {
+			// in TLS 1.3 the client's certificate is only checked after the
+			// client's handshake is done, so tell it how that went
+			_, err = sc.Write([]byte{1})
+		}
+		done <- result{name, err}
+	}()
+	cc, err := tls.Dial("tcp", ln.Addr().String(), clientCfg)
+	if err != nil {
+		<-done
+		return "", "", err
+	}
+	defer cc.Close()
+	agentSees, _, err = PeerIdentity(cc)
+	if err == nil {
+		_, err = cc.Read(make([]byte, 1))
+	}
+	res := <-done
+	if err != nil {
+		return "", "", err
+	}
+	return agentSees, res.name, res.err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := initCA(t)
+	for _, c := range []struct {
+		name  string
+		role  Role
+		hosts []string
+	}{
+		{"agent", RoleAgent, nil},
+		{"other-agent", RoleAgent, nil},
+		{"supervisor", RoleSupervisor, []string{"localhost"}},
+		{"other-supervisor", RoleSupervisor, []string{"localhost"}},
+	} {
+		if _, _, err := Issue(dir, c.name, c.role, c.hosts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agentSees, supervisorSees, err := handshake(t, dir, "agent", "supervisor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agentSees != "supervisor" || supervisorSees != "agent" {
+		t.Errorf("want the agent to see the supervisor and back, got %q and %q", agentSees, supervisorSees)
+	}
+
+	if _, _, err := handshake(t, dir, "agent", "other-agent"); err == nil {
+		t.Error("want an agent certificate refused as a server")
+	}
+	if _, _, err := handshake(t, dir, "other-supervisor", "supervisor"); err == nil {
+		t.Error("want a supervisor certificate refused as a client")
+	}
+}
+
+func TestPeerIdentityWithoutTLS(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if name, ok, err := PeerIdentity(a); name != "" || ok || err != nil {
+		t.Fatalf("want no identity, got %q, %v, %v", name, ok, err)
+	}
+}
@@ -1,64 +1,259 @@
 package rpc
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"time"
 
 	"github.com/aybabtme/deployotron/internal/agent"
 	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/deployotron/internal/pki"
+	"github.com/aybabtme/log"
+	"github.com/pborman/uuid"
 )
 
-// A RemoteAgent that is exposed over RPC.
+// A RemoteAgent that is exposed over RPC. Hello is how the agent introduced
+// itself, and Protocol and Supports tell what the supervisor and the agent
+// agreed on. The events it was subscribed to are received on Events.
+//
+// When its link breaks, the agent has a moment to reconnect and Resume its
+// session on a new link, and calls in flight carry on. Otherwise, or once
+// it's closed, the agent is gone: Done and Events are closed, calls fail, and
+// Err tells why.
 type RemoteAgent interface {
-	StartProcess(*StartProcessReq) (*StartProcessRes, error)
-	StopProcess(*StopProcessReq) (*StopProcessRes, error)
+	Hello() Hello
+	Protocol() int
+	Supports(feature string) bool
+	Events() <-chan agent.Event
+	Done() <-chan struct{}
+	Err() error
+	Resume(*Link) error
+	Close() error
+
+	agentContract
+}
+
+//go:generate go run ../../tools/rpcgen -in agent.go -out agent_gen.go
+
+// agentContract lists the methods an agent serves over RPC, and the role
+// supervisors need to call them. The representant's methods, the names
+// they're called by and the registration of the operator's methods are
+// generated from it, see tools/rpcgen. The operator's methods, and the
+// requests and responses, are written by hand.
+//
+// A call gives up once its context is done, and so does the agent: the
+// deadline of the context is sent along with the request, and the agent is
+// told when the call is canceled.
+type agentContract interface {
+	// rpc:role read-only
+	Subscribe(context.Context, *SubscribeReq) (*SubscribeRes, error)
+	// rpc:role read-only
+	Unsubscribe(context.Context, *UnsubscribeReq) (*UnsubscribeRes, error)
+	// WatchEvents streams the events of the agent. Events the supervisor
+	// doesn't keep up with are dropped.
+	//
+	// rpc:stream agent.Event
+	// rpc:role read-only
+	WatchEvents(context.Context, *WatchEventsReq) (*EventStream, error)
+	// TailLogs streams the lines of a process. Lines the supervisor doesn't
+	// keep up with are dropped, which shows as gaps in their Seq.
+	//
+	// rpc:stream agent.LogLine
+	// rpc:role read-only
+	TailLogs(context.Context, *TailLogsReq) (*LogStream, error)
+
+	// rpc:role read-only
+	ListAll(context.Context, *ListAllReq) (*ListAllRes, error)
+	// rpc:role deployer
+	RestartAll(context.Context, *RestartAllReq) (*RestartAllRes, error)
+	// rpc:role deployer
+	StartProcess(context.Context, *StartProcessReq) (*StartProcessRes, error)
+	// rpc:role deployer
+	StopProcess(context.Context, *StopProcessReq) (*StopProcessRes, error)
+	// rpc:role deployer
+	RestartProcess(context.Context, *RestartProcessReq) (*RestartProcessRes, error)
+	// rpc:role deployer
+	UpgradeProcess(context.Context, *UpgradeProcessReq) (*UpgradeProcessRes, error)
+	// rpc:role read-only
+	ListProgram(context.Context, *ListProgramReq) (*ListProgramRes, error)
+	// rpc:role deployer
+	StopProgram(context.Context, *StopProgramReq) (*StopProgramRes, error)
+	// rpc:role deployer
+	RestartProgram(context.Context, *RestartProgramReq) (*RestartProgramRes, error)
+	// rpc:role deployer
+	UpgradeProgram(context.Context, *UpgradeProgramReq) (*UpgradeProgramRes, error)
+	// rpc:role admin
+	SignalProcess(context.Context, *SignalProcessReq) (*SignalProcessRes, error)
+	// rpc:role admin
+	SignalProgram(context.Context, *SignalProgramReq) (*SignalProgramRes, error)
+	// rpc:role deployer
+	ReloadProgram(context.Context, *ReloadProgramReq) (*ReloadProgramRes, error)
+	// rpc:role deployer
+	PauseProcess(context.Context, *PauseProcessReq) (*PauseProcessRes, error)
+	// rpc:role deployer
+	ResumeProcess(context.Context, *ResumeProcessReq) (*ResumeProcessRes, error)
+	// rpc:role deployer
+	PauseProgram(context.Context, *PauseProgramReq) (*PauseProgramRes, error)
+	// rpc:role deployer
+	ResumeProgram(context.Context, *ResumeProgramReq) (*ResumeProgramRes, error)
+	// rpc:role deployer
+	RunJob(context.Context, *RunJobReq) (*RunJobRes, error)
+	// rpc:role read-only
+	GetJob(context.Context, *GetJobReq) (*GetJobRes, error)
+	// rpc:role read-only
+	ListJobs(context.Context, *ListJobsReq) (*ListJobsRes, error)
+	// rpc:role deployer
+	StopJob(context.Context, *StopJobReq) (*StopJobRes, error)
+	// rpc:role deployer
+	Schedule(context.Context, *ScheduleReq) (*ScheduleRes, error)
+	// rpc:role deployer
+	Unschedule(context.Context, *UnscheduleReq) (*UnscheduleRes, error)
+	// rpc:role read-only
+	ListSchedules(context.Context, *ListSchedulesReq) (*ListSchedulesRes, error)
+	// rpc:role read-only
+	ScheduleHistory(context.Context, *ScheduleHistoryReq) (*ScheduleHistoryRes, error)
 }
 
-// RepresentAgent exposes a RemoteAgent from a bidirectional stream.
-func RepresentAgent(r io.ReadWriteCloser, provider container.ProgramProvider) RemoteAgent {
-	return &representant{
+// An AgentOption configures how a RemoteAgent is represented.
+type AgentOption func(*representant)
+
+// WithToken presents a bearer token to the agent, which authenticates the
+// supervisor.
+func WithToken(token string) AgentOption {
+	return func(rep *representant) { rep.token = token }
+}
+
+// RepresentAgent exposes a RemoteAgent from a link it said hello on, if they
+// speak a common version of the protocol. Many calls can be in flight at
+// once on the same link.
+func RepresentAgent(link *Link, provider container.ProgramProvider, opts ...AgentOption) (RemoteAgent, error) {
+	terms := negotiate(link.hello, false)
+	if terms.Err != "" {
+		_ = link.sendMsg(terms)
+		_ = link.Close()
+		return nil, fmt.Errorf("agent %q: %s", link.hello.Name, terms.Err)
+	}
+	rep := &representant{
 		provider: provider,
-		sendMsg:  json.NewEncoder(r).Encode,
-		readMsg:  json.NewDecoder(r).Decode,
+		hello:    link.hello,
+		terms:    terms,
+		pending:  make(map[uint64]*pendingCall),
+		done:     make(chan struct{}),
+		events:   make(chan agent.Event, eventBuffer),
 	}
+	for _, opt := range opts {
+		opt(rep)
+	}
+	if link.hello.Methods != nil {
+		rep.methods = make(map[string]bool, len(link.hello.Methods))
+		for _, method := range link.hello.Methods {
+			rep.methods[method] = true
+		}
+	}
+	if err := rep.attach(link, false); err != nil {
+		// the agent has the resume window to come back
+		log.KV("agent.name", rep.hello.Name).Err(err).Info("couldn't welcome agent")
+	}
+	return rep, nil
+}
+
+// A Session operates an Agent over successive bidirectional streams. When
+// a stream breaks and the agent reconnects, calls in flight and event
+// subscriptions carry over to the new stream.
+type Session struct {
+	op *operator
+}
+
+// A SessionOption configures a Session.
+type SessionOption func(*operator)
+
+// WithAuthorizer only allows the calls an Authorizer allows. Otherwise, any
+// supervisor can make any call.
+func WithAuthorizer(auth *Authorizer) SessionOption {
+	return func(op *operator) { op.auth = auth }
 }
 
-// OperateAgent operates an Agent over a bidirectional stream.
-func OperateAgent(agent *agent.Agent, provider container.ProgramProvider, r io.ReadWriteCloser) error {
+// NewSession introduces an Agent with hello on every stream it's operated
+// over.
+func NewSession(agent *agent.Agent, provider container.ProgramProvider, hello Hello, opts ...SessionOption) (*Session, error) {
+	hello.Session = uuid.New()
+	introduce(&hello)
+	if err := hello.validate(); err != nil {
+		return nil, err
+	}
+	for _, name := range hello.Codecs {
+		if _, ok := codecNamed(name); !ok {
+			return nil, fmt.Errorf("unknown codec %q", name)
+		}
+	}
 	op := &operator{
 		agent:    agent,
 		provider: provider,
-		readMsg:  json.NewDecoder(r).Decode,
-		sendMsg:  json.NewEncoder(r).Encode,
+		hello:    hello,
+		running:  make(map[uint64]bool),
+		replay:   make(map[uint64]*rpcServerRes),
+		streams:  make(map[uint64]*serverStream),
+		calls:    make(map[uint64]context.CancelFunc),
+		keys:     newIdempotencyCache(),
+	}
+	for _, opt := range opts {
+		opt(op)
+	}
+	return &Session{op: op}, nil
+}
+
+// Operate the agent over a stream. It returns when the stream breaks or the
+// peer stops sending heartbeats, and the session can then be resumed on
+// another stream. A supervisor on the other end of a TLS connection is known
+// by the identity of its certificate.
+func (s *Session) Operate(r io.ReadWriteCloser) error {
+	link := newLink(r)
+	if conn, ok := r.(net.Conn); ok {
+		identity, _, err := pki.PeerIdentity(conn)
+		if err != nil {
+			_ = conn.Close()
+			return err
+		}
+		link.peer = identity
 	}
-	return op.service()
+	return s.op.service(link)
 }
 
-// Method definitions are in this order:
-//    const methodName = "rpc/Agent.MethodName"
+// Close the session, which stops the delivery of events.
+func (s *Session) Close() {
+	s.op.unsubscribe()
+}
+
+// OperateAgent operates an Agent over a bidirectional stream, after
+// introducing it with hello. It returns when the stream breaks or the peer
+// stops sending heartbeats.
+func OperateAgent(agent *agent.Agent, provider container.ProgramProvider, hello *Hello, r io.ReadWriteCloser) error {
+	session, err := NewSession(agent, provider, *hello)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Operate(r)
+}
+
+// Methods are listed in agentContract, which the representant's methods
+// and their registration are generated from. They're defined here in this
+// order:
 //
 //    type (
 //    	Req struct {Arg1 string}
 //    	Res struct {Res1 string}
 //    )
 //
-//    func (rep *representant) MethodName(*Req)      (*Res, error)
-//    func (op *operator)      MethodName(*Req,*Res) (error)
-
-func init() {
-	rpcContract[methodStartProcess] = func(op *operator) (method methodCall, req interface{}) {
-		return op.StartProcess, new(StartProcessReq)
-	}
-}
-
-const methodStartProcess = "rpc/agent.StartProcess"
+//    func (op *operator) MethodName(context.Context, *Req) (*Res, error)
 
 type (
 	// StartProcessReq is an RPC request
 	StartProcessReq struct {
-		ProgramName string `json:"program_name"`
+		ProgramName string         `json:"program_name"`
+		Spec        container.Spec `json:"spec"`
 	}
 	// StartProcessRes is an RPC response
 	StartProcessRes struct {
@@ -66,30 +261,16 @@ type (
 	}
 )
 
-func (rep *representant) StartProcess(req *StartProcessReq) (*StartProcessRes, error) {
-	res := new(StartProcessRes)
-	return res, rep.call(methodStartProcess, req, res)
-}
-
-func (op *operator) StartProcess(r interface{}) (interface{}, error) {
+func (op *operator) StartProcess(ctx context.Context, r interface{}) (interface{}, error) {
 	req := r.(*StartProcessReq)
 	prgmID := op.provider.ProgramID(req.ProgramName)
-	proc, err := op.agent.StartProcess(prgmID)
+	proc, err := op.agent.StartProcess(ctx, prgmID, req.Spec)
 	if err != nil {
 		return nil, err
 	}
 	return &StartProcessRes{ProcessID: proc}, nil
 }
 
-func init() {
-	rpcContract[methodStopProcess] = func(op *operator) (method methodCall, req interface{}) {
-		r := new(StopProcessReq)
-		return op.StopProcess, r
-	}
-}
-
-const methodStopProcess = "rpc/agent.StopProcess"
-
 type (
 	// StopProcessReq is an RPC request
 	StopProcessReq struct {
@@ -100,140 +281,550 @@ type (
 	StopProcessRes struct{}
 )
 
-func (rep *representant) StopProcess(req *StopProcessReq) (*StopProcessRes, error) {
-	res := new(StopProcessRes)
-	return res, rep.call(methodStopProcess, req, res)
-}
-
-func (op *operator) StopProcess(r interface{}) (interface{}, error) {
+func (op *operator) StopProcess(ctx context.Context, r interface{}) (interface{}, error) {
 	req := r.(*StopProcessReq)
-	err := op.agent.StopProcess(req.ProcessID, req.Timeout)
+	err := op.agent.StopProcess(ctx, req.ProcessID, req.Timeout)
 	if err != nil {
 		return nil, err
 	}
 	return &StopProcessRes{}, nil
 }
 
-const methodListAll = "rpc/agent.ListAll"
+type (
+	// SignalProcessReq is an RPC request
+	SignalProcessReq struct {
+		ProcessID container.ProcessID `json:"process_id"`
+		Signal    string              `json:"signal"`
+	}
+	// SignalProcessRes is an RPC response
+	SignalProcessRes struct{}
+)
+
+func (op *operator) SignalProcess(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*SignalProcessReq)
+	sig, err := container.ParseSignal(req.Signal)
+	if err != nil {
+		return nil, errorf(CodeInvalidArgument, "%v", err)
+	}
+	if err := op.agent.SignalProcess(req.ProcessID, sig); err != nil {
+		return nil, err
+	}
+	return &SignalProcessRes{}, nil
+}
 
 type (
-	// ListAllReq is an RPC request
-	ListAllReq struct{}
-	// ListAllRes is an RPC response
-	ListAllRes struct {
-		Running map[container.ProgramID][]container.ProcessID
+	// SignalProgramReq is an RPC request
+	SignalProgramReq struct {
+		ProgramName string `json:"program_name"`
+		Signal      string `json:"signal"`
 	}
+	// SignalProgramRes is an RPC response
+	SignalProgramRes struct{}
 )
 
-func (rep *representant) ListAll(req *ListAllReq) (*ListAllRes, error) {
-	res := new(ListAllRes)
-	return res, rep.call(methodListAll, req, res)
+func (op *operator) SignalProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*SignalProgramReq)
+	sig, err := container.ParseSignal(req.Signal)
+	if err != nil {
+		return nil, errorf(CodeInvalidArgument, "%v", err)
+	}
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	if err := op.agent.SignalProgram(prgmID, sig); err != nil {
+		return nil, err
+	}
+	return &SignalProgramRes{}, nil
 }
 
-func (op *operator) ListAll(r interface{}) (interface{}, error) {
-	return &ListAllRes{Running: op.agent.ListAll()}, nil
+type (
+	// ReloadProgramReq is an RPC request
+	ReloadProgramReq struct {
+		ProgramName string `json:"program_name"`
+	}
+	// ReloadProgramRes is an RPC response
+	ReloadProgramRes struct{}
+)
+
+func (op *operator) ReloadProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*ReloadProgramReq)
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	if err := op.agent.ReloadProgram(prgmID); err != nil {
+		return nil, err
+	}
+	return &ReloadProgramRes{}, nil
 }
 
-/*
-	rpc internal details
-*/
+type (
+	// PauseProcessReq is an RPC request
+	PauseProcessReq struct {
+		ProcessID container.ProcessID `json:"process_id"`
+	}
+	// PauseProcessRes is an RPC response
+	PauseProcessRes struct{}
+)
 
-type rpcClientReq struct {
-	MethodName string      `json:"method_name"`
-	Request    interface{} `json:"request"`
+func (op *operator) PauseProcess(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*PauseProcessReq)
+	if err := op.agent.PauseProcess(req.ProcessID); err != nil {
+		return nil, err
+	}
+	return &PauseProcessRes{}, nil
 }
 
-type rpcServerReq struct {
-	MethodName string          `json:"method_name"`
-	Request    json.RawMessage `json:"request"`
+type (
+	// ResumeProcessReq is an RPC request
+	ResumeProcessReq struct {
+		ProcessID container.ProcessID `json:"process_id"`
+	}
+	// ResumeProcessRes is an RPC response
+	ResumeProcessRes struct{}
+)
+
+func (op *operator) ResumeProcess(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*ResumeProcessReq)
+	if err := op.agent.ResumeProcess(req.ProcessID); err != nil {
+		return nil, err
+	}
+	return &ResumeProcessRes{}, nil
 }
 
-type rpcClientRes struct {
-	Response json.RawMessage `json:"response"`
-	Err      string          `json:"error"`
+type (
+	// PauseProgramReq is an RPC request
+	PauseProgramReq struct {
+		ProgramName string `json:"program_name"`
+	}
+	// PauseProgramRes is an RPC response
+	PauseProgramRes struct{}
+)
+
+func (op *operator) PauseProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*PauseProgramReq)
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	if err := op.agent.PauseProgram(prgmID); err != nil {
+		return nil, err
+	}
+	return &PauseProgramRes{}, nil
 }
 
-type rpcServerRes struct {
-	Response interface{} `json:"response"`
-	Err      string      `json:"error"`
+type (
+	// ResumeProgramReq is an RPC request
+	ResumeProgramReq struct {
+		ProgramName string `json:"program_name"`
+	}
+	// ResumeProgramRes is an RPC response
+	ResumeProgramRes struct{}
+)
+
+func (op *operator) ResumeProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*ResumeProgramReq)
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	if err := op.agent.ResumeProgram(prgmID); err != nil {
+		return nil, err
+	}
+	return &ResumeProgramRes{}, nil
 }
 
-type methodCall func(interface{}) (interface{}, error)
+type (
+	// RunJobReq is an RPC request
+	RunJobReq struct {
+		ProgramName string           `json:"program_name"`
+		Spec        container.Spec   `json:"spec"`
+		Options     agent.JobOptions `json:"options"`
+	}
+	// RunJobRes is an RPC response
+	RunJobRes struct {
+		JobID agent.JobID `json:"job_id"`
+	}
+)
+
+func (op *operator) RunJob(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*RunJobReq)
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	jobID, err := op.agent.RunJob(ctx, prgmID, req.Spec, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &RunJobRes{JobID: jobID}, nil
+}
 
-var rpcContract = make(map[string]func(op *operator) (method methodCall, req interface{}))
+type (
+	// GetJobReq is an RPC request
+	GetJobReq struct {
+		JobID agent.JobID `json:"job_id"`
+	}
+	// GetJobRes is an RPC response
+	GetJobRes struct {
+		Job agent.JobStatus `json:"job"`
+	}
+)
 
-type representant struct {
-	provider container.ProgramProvider
-	sendMsg  func(v interface{}) error
-	readMsg  func(v interface{}) error
+func (op *operator) GetJob(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*GetJobReq)
+	job, err := op.agent.Job(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+	return &GetJobRes{Job: job}, nil
 }
 
-func (rep *representant) call(method string, req, res interface{}) error {
-	if err := rep.sendMsg(rpcClientReq{
-		MethodName: method,
-		Request:    req,
-	}); err != nil {
-		return fmt.Errorf("sending rpc request message: %v", err)
+type (
+	// ListJobsReq is an RPC request
+	ListJobsReq struct{}
+	// ListJobsRes is an RPC response
+	ListJobsRes struct {
+		Jobs []agent.JobStatus `json:"jobs"`
 	}
-	rpcRes := new(rpcClientRes)
-	if err := rep.readMsg(rpcRes); err != nil {
-		return fmt.Errorf("reading rpc response message: %v", err)
+)
+
+func (op *operator) ListJobs(ctx context.Context, r interface{}) (interface{}, error) {
+	return &ListJobsRes{Jobs: op.agent.ListJobs()}, nil
+}
+
+type (
+	// StopJobReq is an RPC request
+	StopJobReq struct {
+		JobID   agent.JobID   `json:"job_id"`
+		Timeout time.Duration `json:"timeout"`
 	}
-	if rpcRes.Err != "" {
-		return fmt.Errorf("error response: %s", rpcRes.Err)
+	// StopJobRes is an RPC response
+	StopJobRes struct{}
+)
+
+func (op *operator) StopJob(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*StopJobReq)
+	if err := op.agent.StopJob(req.JobID, req.Timeout); err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal(rpcRes.Response, res); err != nil {
-		return fmt.Errorf("unmarshalling response: %v", err)
+	return &StopJobRes{}, nil
+}
+
+type (
+	// ScheduleReq is an RPC request
+	ScheduleReq struct {
+		ProgramName string         `json:"program_name"`
+		Schedule    agent.Schedule `json:"schedule"`
+	}
+	// ScheduleRes is an RPC response
+	ScheduleRes struct{}
+)
+
+func (op *operator) Schedule(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*ScheduleReq)
+	sched := req.Schedule
+	sched.Program = op.provider.ProgramID(req.ProgramName)
+	if err := op.agent.Schedule(sched); err != nil {
+		return nil, err
 	}
-	return nil
+	return &ScheduleRes{}, nil
 }
 
-type operator struct {
-	agent    *agent.Agent
-	provider container.ProgramProvider
+type (
+	// UnscheduleReq is an RPC request
+	UnscheduleReq struct {
+		Name string `json:"name"`
+	}
+	// UnscheduleRes is an RPC response
+	UnscheduleRes struct{}
+)
 
-	readMsg func(v interface{}) error
-	sendMsg func(v interface{}) error
+func (op *operator) Unschedule(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*UnscheduleReq)
+	if err := op.agent.Unschedule(req.Name); err != nil {
+		return nil, err
+	}
+	return &UnscheduleRes{}, nil
 }
 
-func (op *operator) service() error {
-	for {
-		rpcReq := new(rpcServerReq)
-		if err := op.readMsg(rpcReq); err != nil {
-			return fmt.Errorf("can't read message: %v", err)
-		}
-		rpcRes := new(rpcServerRes)
+type (
+	// ListSchedulesReq is an RPC request
+	ListSchedulesReq struct{}
+	// ListSchedulesRes is an RPC response
+	ListSchedulesRes struct {
+		Schedules []agent.ScheduleStatus `json:"schedules"`
+	}
+)
 
-		// find where to dispatch
-		dispatcher, ok := rpcContract[rpcReq.MethodName]
-		if !ok {
-			rpcRes.Err = "unsupported method"
-			return op.sendMsg(rpcRes)
-		}
-		method, req := dispatcher(op)
+func (op *operator) ListSchedules(ctx context.Context, r interface{}) (interface{}, error) {
+	return &ListSchedulesRes{Schedules: op.agent.ListSchedules()}, nil
+}
+
+type (
+	// ScheduleHistoryReq is an RPC request
+	ScheduleHistoryReq struct {
+		Name string `json:"name"`
+	}
+	// ScheduleHistoryRes is an RPC response
+	ScheduleHistoryRes struct {
+		Runs []agent.JobStatus `json:"runs"`
+	}
+)
+
+func (op *operator) ScheduleHistory(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*ScheduleHistoryReq)
+	runs, err := op.agent.ScheduleHistory(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduleHistoryRes{Runs: runs}, nil
+}
+
+type (
+	// SubscribeReq is an RPC request, no kinds means all of them
+	SubscribeReq struct {
+		Kinds []agent.EventKind `json:"kinds"`
+	}
+	// SubscribeRes is an RPC response
+	SubscribeRes struct{}
+)
+
+func (op *operator) Subscribe(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*SubscribeReq)
+	op.subscribe(req.Kinds)
+	return &SubscribeRes{}, nil
+}
+
+type (
+	// UnsubscribeReq is an RPC request
+	UnsubscribeReq struct{}
+	// UnsubscribeRes is an RPC response
+	UnsubscribeRes struct{}
+)
+
+func (op *operator) Unsubscribe(ctx context.Context, r interface{}) (interface{}, error) {
+	op.unsubscribe()
+	return &UnsubscribeRes{}, nil
+}
+
+// Streaming methods send their responses on a stream instead:
+//
+//    func (op *operator) MethodName(context.Context, *Req, *serverStream) error
+
+type (
+	// WatchEventsReq is an RPC request, no kinds means all of them
+	WatchEventsReq struct {
+		Kinds []agent.EventKind `json:"kinds"`
+	}
+)
 
-		// decode the method's arguments
-		if err := json.Unmarshal(rpcReq.Request, req); err != nil {
-			rpcRes.Err = "internal error"
-			if err := op.sendMsg(rpcRes); err != nil {
-				return fmt.Errorf("sending unmarshal error: %v", err)
+func (op *operator) WatchEvents(ctx context.Context, r interface{}, stream *serverStream) error {
+	req := r.(*WatchEventsReq)
+	sub := op.agent.Subscribe(req.Kinds...)
+	defer sub.Close()
+	for {
+		select {
+		case ev := <-sub.C:
+			if err := stream.send(&ev); err != nil {
+				return err
 			}
-			return err
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+	}
+}
 
-		// invoke the method
-		res, err := method(req)
-		if err != nil {
-			rpcRes.Err = fmt.Sprintf("method call error: %v", err)
-			if err := op.sendMsg(rpcRes); err != nil {
-				return fmt.Errorf("sending method call error: %v", err)
+type (
+	// TailLogsReq is an RPC request for the last lines of a process, and
+	// the lines it writes next if it follows them
+	TailLogsReq struct {
+		ProcessID container.ProcessID `json:"process_id"`
+		Lines     int                 `json:"lines"`
+		Follow    bool                `json:"follow"`
+	}
+)
+
+func (op *operator) TailLogs(ctx context.Context, r interface{}, stream *serverStream) error {
+	req := r.(*TailLogsReq)
+	tail, err := op.agent.TailLogs(req.ProcessID, req.Lines, req.Follow)
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+	for {
+		select {
+		case line, ok := <-tail.C:
+			if !ok {
+				return nil
+			}
+			if err := stream.send(&line); err != nil {
+				return err
 			}
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+	}
+}
 
-		// encode the response
-		rpcRes.Response = res
-		if err := op.sendMsg(rpcRes); err != nil {
-			return fmt.Errorf("sending method call response: %v", err)
-		}
+type (
+	// ListAllReq is an RPC request
+	ListAllReq struct{}
+	// ListAllRes is an RPC response
+	ListAllRes struct {
+		Running map[container.ProgramID][]container.ProcessID `json:"running"`
+	}
+)
+
+func (op *operator) ListAll(ctx context.Context, r interface{}) (interface{}, error) {
+	return &ListAllRes{Running: op.agent.ListAll()}, nil
+}
+
+type (
+	// RestartAllReq is an RPC request
+	RestartAllReq struct {
+		Policy agent.PolicySpec `json:"policy"`
+	}
+	// RestartAllRes is an RPC response
+	RestartAllRes struct{}
+)
+
+func (op *operator) RestartAll(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*RestartAllReq)
+	policy, err := req.Policy.Policy()
+	if err != nil {
+		return nil, err
+	}
+	if err := op.agent.RestartAll(ctx, policy); err != nil {
+		return nil, err
+	}
+	return &RestartAllRes{}, nil
+}
+
+type (
+	// RestartProcessReq is an RPC request
+	RestartProcessReq struct {
+		Policy    agent.PolicySpec    `json:"policy"`
+		ProcessID container.ProcessID `json:"process_id"`
+	}
+	// RestartProcessRes is an RPC response
+	RestartProcessRes struct{}
+)
+
+func (op *operator) RestartProcess(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*RestartProcessReq)
+	policy, err := req.Policy.Policy()
+	if err != nil {
+		return nil, err
+	}
+	if err := op.agent.RestartProcess(ctx, policy, req.ProcessID); err != nil {
+		return nil, err
+	}
+	return &RestartProcessRes{}, nil
+}
+
+type (
+	// UpgradeProcessReq is an RPC request
+	UpgradeProcessReq struct {
+		Policy        agent.PolicySpec    `json:"policy"`
+		ProcessID     container.ProcessID `json:"process_id"`
+		ToProgramName string              `json:"to_program_name"`
+	}
+	// UpgradeProcessRes is an RPC response
+	UpgradeProcessRes struct{}
+)
+
+func (op *operator) UpgradeProcess(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*UpgradeProcessReq)
+	policy, err := req.Policy.Policy()
+	if err != nil {
+		return nil, err
+	}
+	to := op.provider.ProgramID(req.ToProgramName)
+	if err := op.agent.UpgradeProcess(ctx, policy, req.ProcessID, to); err != nil {
+		return nil, err
+	}
+	return &UpgradeProcessRes{}, nil
+}
+
+type (
+	// ListProgramReq is an RPC request
+	ListProgramReq struct {
+		ProgramName string `json:"program_name"`
+	}
+	// ListProgramRes is an RPC response
+	ListProgramRes struct {
+		ProcessIDs []container.ProcessID `json:"process_ids"`
+	}
+)
+
+func (op *operator) ListProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*ListProgramReq)
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	procIDs, err := op.agent.ListProgram(prgmID)
+	if err != nil {
+		return nil, err
+	}
+	return &ListProgramRes{ProcessIDs: procIDs}, nil
+}
+
+type (
+	// StopProgramReq is an RPC request
+	StopProgramReq struct {
+		ProgramName string        `json:"program_name"`
+		Timeout     time.Duration `json:"timeout"`
+	}
+	// StopProgramRes is an RPC response
+	StopProgramRes struct{}
+)
+
+func (op *operator) StopProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*StopProgramReq)
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	if err := op.agent.StopProgram(ctx, prgmID, req.Timeout); err != nil {
+		return nil, err
+	}
+	return &StopProgramRes{}, nil
+}
+
+type (
+	// RestartProgramReq is an RPC request
+	RestartProgramReq struct {
+		Policy      agent.PolicySpec `json:"policy"`
+		ProgramName string           `json:"program_name"`
+	}
+	// RestartProgramRes is an RPC response
+	RestartProgramRes struct{}
+)
+
+func (op *operator) RestartProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*RestartProgramReq)
+	policy, err := req.Policy.Policy()
+	if err != nil {
+		return nil, err
+	}
+	prgmID := op.provider.ProgramID(req.ProgramName)
+	if err := op.agent.RestartProgram(ctx, policy, prgmID); err != nil {
+		return nil, err
+	}
+	return &RestartProgramRes{}, nil
+}
+
+type (
+	// UpgradeProgramReq is an RPC request
+	UpgradeProgramReq struct {
+		Policy          agent.PolicySpec `json:"policy"`
+		FromProgramName string           `json:"from_program_name"`
+		ToProgramName   string           `json:"to_program_name"`
+		// Spec replaces the spec of the processes, if set and the agent
+		// supports FeatureReconfigure.
+		Spec *container.Spec `json:"spec,omitempty"`
+	}
+	// UpgradeProgramRes is an RPC response
+	UpgradeProgramRes struct{}
+)
+
+func (op *operator) UpgradeProgram(ctx context.Context, r interface{}) (interface{}, error) {
+	req := r.(*UpgradeProgramReq)
+	policy, err := req.Policy.Policy()
+	if err != nil {
+		return nil, err
+	}
+	from := op.provider.ProgramID(req.FromProgramName)
+	to := op.provider.ProgramID(req.ToProgramName)
+	if req.Spec != nil {
+		err = op.agent.ReconfigureProgram(ctx, policy, from, to, *req.Spec)
+	} else {
+		err = op.agent.UpgradeProgram(ctx, policy, from, to)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return &UpgradeProgramRes{}, nil
 }
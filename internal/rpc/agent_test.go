@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+// fakeClient creates processes that run until they're stopped.
+type fakeClient struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (cl *fakeClient) ProgramID(name string) container.ProgramID {
+	return container.ProgramID("fake.program." + name)
+}
+
+func (cl *fakeClient) Programs() container.ProgramSvc  { return fakePrograms{} }
+func (cl *fakeClient) Processes() container.ProcessSvc { return cl }
+
+func (cl *fakeClient) Create(ctx context.Context, prgm container.Program, cfg container.ProcessConfig) (container.Process, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.next++
+	return &fakeProcess{id: container.ProcessID(fmt.Sprintf("fake.process.%d", cl.next)), prgm: prgm, exit: make(chan struct{})}, nil
+}
+
+func (cl *fakeClient) Remove(ctx context.Context, proc container.Process) error { return nil }
+
+type fakePrograms struct{}
+
+func (fakePrograms) Pull(ctx context.Context, id container.ProgramID) (container.Program, error) {
+	return fakeProgram(id), nil
+}
+
+func (fakePrograms) Get(ctx context.Context, id container.ProgramID) (container.Program, bool, error) {
+	return fakeProgram(id), true, nil
+}
+
+func (fakePrograms) Remove(ctx context.Context, id container.ProgramID) error { return nil }
+
+type fakeProgram container.ProgramID
+
+func (prgm fakeProgram) ID() container.ProgramID { return container.ProgramID(prgm) }
+
+type fakeProcess struct {
+	id   container.ProcessID
+	prgm container.Program
+	once sync.Once
+	exit chan struct{}
+}
+
+func (proc *fakeProcess) ID() container.ProcessID         { return proc.id }
+func (proc *fakeProcess) Program() container.Program      { return proc.prgm }
+func (proc *fakeProcess) Start(ctx context.Context) error { return nil }
+func (proc *fakeProcess) Kill() error                     { return proc.Stop(context.Background(), 0) }
+func (proc *fakeProcess) Signal(os.Signal) error          { return nil }
+func (proc *fakeProcess) Pause() error                    { return nil }
+func (proc *fakeProcess) Resume() error                   { return nil }
+
+func (proc *fakeProcess) Stop(ctx context.Context, timeout time.Duration) error {
+	proc.once.Do(func() { close(proc.exit) })
+	return nil
+}
+
+func (proc *fakeProcess) Wait() error {
+	<-proc.exit
+	return nil
+}
+
+// peers are a supervisor and an agent, talking over a pipe.
+type peers struct {
+	agent   *agent.Agent
+	client  *fakeClient
+	session *Session
+	rep     RemoteAgent
+}
+
+// connect an agent that introduces itself with hello to a supervisor.
+func connect(t testing.TB, hello Hello, sessionOpts []SessionOption, agentOpts ...AgentOption) *peers {
+	t.Helper()
+	p := &peers{client: &fakeClient{}}
+	p.agent = agent.New(p.client)
+	if hello.Name == "" {
+		hello.Name = "agent"
+	}
+	session, err := NewSession(p.agent, p.client, hello, sessionOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.session = session
+	p.rep = p.operate(t, agentOpts...)
+	t.Cleanup(func() {
+		_ = p.rep.Close()
+		session.Close()
+		for id := range p.agent.ListAll() {
+			_ = p.agent.StopProgram(context.Background(), id, time.Second)
+		}
+	})
+	return p
+}
+
+// operate the agent over a new pipe, and represent it to the supervisor.
+func (p *peers) operate(t testing.TB, agentOpts ...AgentOption) RemoteAgent {
+	t.Helper()
+	sup, ag := net.Pipe()
+	go func() { _ = p.session.Operate(ag) }()
+	link, err := Greet(sup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := RepresentAgent(link, p.client, agentOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+func TestRemoteCalls(t *testing.T) {
+	p := connect(t, Hello{}, nil)
+	ctx := context.Background()
+
+	start, err := p.rep.StartProcess(ctx, &StartProcessReq{ProgramName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := p.rep.ListAll(ctx, &ListAllReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	procs := list.Running[p.client.ProgramID("app")]
+	if len(procs) != 1 || procs[0] != start.ProcessID {
+		t.Fatalf("want %v running, got %v", start.ProcessID, list.Running)
+	}
+	if _, err := p.rep.StopProcess(ctx, &StopProcessReq{ProcessID: start.ProcessID, Timeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.rep.StopProcess(ctx, &StopProcessReq{ProcessID: start.ProcessID}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want %v stopping a stopped process, got %v", ErrNotFound, err)
+	}
+}
+
+func TestRemoteGetJob(t *testing.T) {
+	p := connect(t, Hello{}, nil)
+	ctx := context.Background()
+
+	run, err := p.rep.RunJob(ctx, &RunJobReq{ProgramName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.rep.GetJob(ctx, &GetJobReq{JobID: run.JobID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Job.ID != run.JobID {
+		t.Fatalf("want job %v, got %v", run.JobID, res.Job.ID)
+	}
+	if _, err := p.rep.StopJob(ctx, &StopJobReq{JobID: run.JobID, Timeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.rep.GetJob(ctx, &GetJobReq{JobID: "nope"})
+	var rerr *Error
+	if !errors.As(err, &rerr) || rerr.Code != CodeNotFound || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want a %v error, got %#v", CodeNotFound, err)
+	}
+}
@@ -0,0 +1,178 @@
+package rpc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/aybabtme/log"
+)
+
+// A Role is what a supervisor is allowed to do with an agent. Every role is
+// allowed what the roles before it are.
+type Role string
+
+// The roles a supervisor can have.
+const (
+	RoleNone     Role = ""
+	RoleReadOnly Role = "read-only"
+	RoleDeployer Role = "deployer"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRanks = map[Role]int{RoleNone: 0, RoleReadOnly: 1, RoleDeployer: 2, RoleAdmin: 3}
+
+// allows tells if a role is allowed what required is.
+func (role Role) allows(required Role) bool { return roleRanks[role] >= roleRanks[required] }
+
+// rpcRoles is the role each method requires. Methods that aren't listed
+// require RoleAdmin.
+var rpcRoles = make(map[string]Role)
+
+func requiredRole(method string) Role {
+	if role, ok := rpcRoles[method]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+// An ACL tells the role of the supervisors an agent can be operated by.
+// Supervisors are known by the subject of their certificate, or by a bearer
+// token they present when they welcome the agent.
+type ACL struct {
+	// Default is the role of supervisors that aren't known otherwise.
+	Default    Role            `json:"default"`
+	Identities map[string]Role `json:"identities"`
+	Tokens     []TokenGrant    `json:"tokens"`
+}
+
+// A TokenGrant gives a role to the supervisors that present a token. The
+// name of the grant tells them apart in the audit log.
+type TokenGrant struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  Role   `json:"role"`
+}
+
+// LoadACL reads an ACL from a JSON file.
+func LoadACL(path string) (ACL, error) {
+	var acl ACL
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return acl, fmt.Errorf("reading ACL: %v", err)
+	}
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return acl, fmt.Errorf("parsing ACL %q: %v", path, err)
+	}
+	return acl, acl.validate()
+}
+
+func (acl ACL) validate() error {
+	if _, ok := roleRanks[acl.Default]; !ok {
+		return fmt.Errorf("unknown default role %q", acl.Default)
+	}
+	for identity, role := range acl.Identities {
+		if _, ok := roleRanks[role]; !ok {
+			return fmt.Errorf("unknown role %q for identity %q", role, identity)
+		}
+	}
+	for i, grant := range acl.Tokens {
+		switch {
+		case grant.Name == "":
+			return fmt.Errorf("token %d has no name", i)
+		case grant.Token == "":
+			return fmt.Errorf("token %q is empty", grant.Name)
+		}
+		if _, ok := roleRanks[grant.Role]; !ok {
+			return fmt.Errorf("unknown role %q for token %q", grant.Role, grant.Name)
+		}
+	}
+	return nil
+}
+
+// A principal is a supervisor as far as authorization goes.
+type principal struct {
+	name string // who it's known as
+	role Role
+}
+
+// An Authorizer enforces an ACL on the calls an agent receives, and records
+// the calls it denies in an audit log.
+type Authorizer struct {
+	acl ACL
+
+	mu    sync.Mutex
+	audit io.Writer
+}
+
+// NewAuthorizer enforces an ACL. Denied calls are written to audit as JSON
+// lines, if it's not nil.
+func NewAuthorizer(acl ACL, audit io.Writer) (*Authorizer, error) {
+	if err := acl.validate(); err != nil {
+		return nil, err
+	}
+	return &Authorizer{acl: acl, audit: audit}, nil
+}
+
+// authenticate a supervisor by the identity of its certificate, if it has
+// one, and the token it presented, if any. It gets the highest role of
+// those it's known by.
+func (auth *Authorizer) authenticate(identity, token string) principal {
+	who := principal{name: "anonymous", role: auth.acl.Default}
+	if role, ok := auth.acl.Identities[identity]; ok && identity != "" {
+		who = principal{name: "cert:" + identity, role: role}
+	}
+	if token == "" {
+		return who
+	}
+	for _, grant := range auth.acl.Tokens {
+		if subtle.ConstantTimeCompare([]byte(grant.Token), []byte(token)) == 1 {
+			if roleRanks[grant.Role] > roleRanks[who.role] {
+				who = principal{name: "token:" + grant.Name, role: grant.Role}
+			}
+			return who
+		}
+	}
+	return who
+}
+
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session"`
+	Principal string    `json:"principal"`
+	Role      Role      `json:"role"`
+	Method    string    `json:"method"`
+	Required  Role      `json:"required"`
+}
+
+// authorize a call to a method, or deny it and record why.
+func (auth *Authorizer) authorize(session string, who principal, method string) error {
+	required := requiredRole(method)
+	if who.role.allows(required) {
+		return nil
+	}
+	log.KV("principal", who.name).KV("role", who.role).KV("method", method).Info("denied call")
+	if auth.audit != nil {
+		data, err := json.Marshal(auditRecord{
+			Time:      time.Now().UTC(),
+			Session:   session,
+			Principal: who.name,
+			Role:      who.role,
+			Method:    method,
+			Required:  required,
+		})
+		if err == nil {
+			auth.mu.Lock()
+			_, err = auth.audit.Write(append(data, '\n'))
+			auth.mu.Unlock()
+		}
+		if err != nil {
+			log.Err(err).Error("can't write to audit log")
+		}
+	}
+	return errorf(CodePermissionDenied, "%s with role %q can't call %s, which requires role %q", who.name, who.role, method, required)
+}
@@ -0,0 +1,191 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestACLValidate(t *testing.T) {
+	valid := []ACL{
+		{},
+		{Default: RoleReadOnly, Identities: map[string]Role{"sup": RoleAdmin}, Tokens: []TokenGrant{{Name: "ci", Token: "t", Role: RoleDeployer}}},
+	}
+	for _, acl := range valid {
+		if err := acl.validate(); err != nil {
+			t.Errorf("%+v: %v", acl, err)
+		}
+	}
+	invalid := map[string]ACL{
+		"unknown default":   {Default: "root"},
+		"unknown identity":  {Identities: map[string]Role{"sup": "root"}},
+		"token has no name": {Tokens: []TokenGrant{{Token: "t", Role: RoleAdmin}}},
+		"empty token":       {Tokens: []TokenGrant{{Name: "ci", Role: RoleAdmin}}},
+		"unknown token":     {Tokens: []TokenGrant{{Name: "ci", Token: "t", Role: "root"}}},
+	}
+	for name, acl := range invalid {
+		if err := acl.validate(); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+		if _, err := NewAuthorizer(acl, nil); err == nil {
+			t.Errorf("%s: want no authorizer", name)
+		}
+	}
+}
+
+func TestLoadACL(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	acl, err := LoadACL(write("acl.json", `{"default": "read-only", "identities": {"sup": "admin"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl.Default != RoleReadOnly || acl.Identities["sup"] != RoleAdmin {
+		t.Errorf("want the ACL read, got %+v", acl)
+	}
+	for _, path := range []string{
+		filepath.Join(dir, "missing.json"),
+		write("bad.json", `{"default": `),
+		write("unknown.json", `{"default": "root"}`),
+	} {
+		if _, err := LoadACL(path); err == nil {
+			t.Errorf("%s: want an error", filepath.Base(path))
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	auth, err := NewAuthorizer(ACL{
+		Default:    RoleReadOnly,
+		Identities: map[string]Role{"sup": RoleDeployer, "": RoleAdmin, "nobody": RoleNone},
+		Tokens: []TokenGrant{
+			{Name: "ops", Token: "ops-token", Role: RoleAdmin},
+			{Name: "ro", Token: "ro-token", Role: RoleReadOnly},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		identity string
+		token    string
+		want     principal
+	}{
+		{"anonymous", "", "", principal{"anonymous", RoleReadOnly}},
+		{"unknown identity", "stranger", "", principal{"anonymous", RoleReadOnly}},
+		{"known identity", "sup", "", principal{"cert:sup", RoleDeployer}},
+		{"identity with a lesser role", "nobody", "", principal{"cert:nobody", RoleNone}},
+		{"token", "", "ops-token", principal{"token:ops", RoleAdmin}},
+		{"token over identity", "sup", "ops-token", principal{"token:ops", RoleAdmin}},
+		{"identity over token", "sup", "ro-token", principal{"cert:sup", RoleDeployer}},
+		{"unknown token", "sup", "guess", principal{"cert:sup", RoleDeployer}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auth.authenticate(tt.identity, tt.token); got != tt.want {
+				t.Fatalf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	audit := bytes.NewBuffer(nil)
+	auth, err := NewAuthorizer(ACL{}, audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		role    Role
+		method  string
+		allowed bool
+	}{
+		{RoleNone, methodListAll, false},
+		{RoleReadOnly, methodListAll, true},
+		{RoleReadOnly, methodStartProcess, false},
+		{RoleDeployer, methodStartProcess, true},
+		{RoleDeployer, methodSignalProcess, false},
+		{RoleAdmin, methodSignalProcess, true},
+		{RoleDeployer, "rpc/agent.Teleport", false}, // unlisted methods require admin
+		{RoleAdmin, "rpc/agent.Teleport", true},
+	}
+	denied := 0
+	for _, tt := range tests {
+		err := auth.authorize("session", principal{"token:ci", tt.role}, tt.method)
+		if tt.allowed != (err == nil) {
+			t.Errorf("%q calling %s: want allowed %v, got %v", tt.role, tt.method, tt.allowed, err)
+		}
+		if err != nil {
+			denied++
+			if !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("want %v, got %v", ErrPermissionDenied, err)
+			}
+		}
+	}
+
+	dec := json.NewDecoder(audit)
+	for i := 0; i < denied; i++ {
+		var rec auditRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("want a record of every denied call: %v", err)
+		}
+		if rec.Session != "session" || rec.Principal != "token:ci" || rec.Method == "" || rec.Time.IsZero() {
+			t.Errorf("want the denied call recorded, got %+v", rec)
+		}
+		if rec.Role.allows(rec.Required) {
+			t.Errorf("want a role that isn't allowed, got %+v", rec)
+		}
+	}
+	if dec.More() {
+		t.Error("want allowed calls not recorded")
+	}
+}
+
+func TestRemotePermissionDenied(t *testing.T) {
+	audit := bytes.NewBuffer(nil)
+	auth, err := NewAuthorizer(ACL{
+		Default: RoleReadOnly,
+		Tokens:  []TokenGrant{{Name: "deploy", Token: "secret", Role: RoleDeployer}},
+	}, audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	anonymous := connect(t, Hello{}, []SessionOption{WithAuthorizer(auth)})
+	if _, err := anonymous.rep.ListAll(ctx, &ListAllReq{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = anonymous.rep.StartProcess(ctx, &StartProcessReq{ProgramName: "app"})
+	var rerr *Error
+	if !errors.As(err, &rerr) || rerr.Code != CodePermissionDenied || !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("want a %v error, got %#v", CodePermissionDenied, err)
+	}
+	if len(anonymous.agent.ListAll()) != 0 {
+		t.Fatal("want no process started")
+	}
+	if audit.Len() == 0 {
+		t.Error("want the denied call audited")
+	}
+
+	deployer := connect(t, Hello{}, []SessionOption{WithAuthorizer(auth)}, WithToken("secret"))
+	if _, err := deployer.rep.StartProcess(ctx, &StartProcessReq{ProgramName: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deployer.rep.SignalProcess(ctx, &SignalProcessReq{ProcessID: "any", Signal: "HUP"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("want %v, got %v", ErrPermissionDenied, err)
+	}
+}
@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// A Codec encodes the messages of a stream. The agent tells which codecs it
+// knows in its Hello, and the supervisor picks the first one it knows too.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The codecs this package knows.
+var (
+	JSON Codec = jsonCodec{}
+	Gob  Codec = gobCodec{}
+)
+
+var (
+	codecMu sync.RWMutex
+	// by order of preference: gob is more compact and faster to decode on
+	// large responses, but it describes its types in every message, which
+	// makes small calls slower than with JSON
+	codecs = []Codec{JSON, Gob}
+)
+
+// RegisterCodec makes a codec known to agents and supervisors, with less
+// preference than the codecs known so far.
+func RegisterCodec(codec Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs = append(codecs, codec)
+}
+
+func codecNamed(name string) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+func codecNames() []string {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for _, codec := range codecs {
+		names = append(names, codec.Name())
+	}
+	return names
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+/*
+ payloads
+*/
+
+// A payload is the request or response of a call, encoded by the codec of
+// the link it's sent on. With JSON, it's embedded as is in its message.
+type payload []byte
+
+func (p payload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p *payload) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+// encodePayload encodes the request or response of a call. Requests and
+// responses that have no fields have no payload, since not all codecs can
+// encode them.
+func encodePayload(codec Codec, v interface{}) (payload, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.Indirect(reflect.ValueOf(v)); rv.Kind() == reflect.Struct && rv.NumField() == 0 {
+		return nil, nil
+	}
+	return codec.Marshal(v)
+}
+
+func decodePayload(codec Codec, p payload, v interface{}) error {
+	if len(p) == 0 || string(p) == "null" {
+		return nil
+	}
+	return codec.Unmarshal(p, v)
+}
+
+/*
+ framing
+*/
+
+// Once the peers agreed on a codec, messages are sent in frames: the size
+// of the message as a 4 bytes big endian integer, then the message.
+const maxFrameSize = 64 << 20
+
+func frameWriter(w io.Writer, codec Codec) func(v interface{}) error {
+	return func(v interface{}) error {
+		data, err := codec.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encoding %s message: %v", codec.Name(), err)
+		}
+		if len(data) > maxFrameSize {
+			return fmt.Errorf("message of %d bytes is over the %d bytes limit", len(data), maxFrameSize)
+		}
+		frame := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(frame, uint32(len(data)))
+		copy(frame[4:], data)
+		_, err = w.Write(frame)
+		return err
+	}
+}
+
+// frameReader reads frames after the newline ending the JSON message that
+// precedes them.
+func frameReader(r io.Reader, codec Codec) func(v interface{}) error {
+	var (
+		newline = true
+		header  [4]byte
+	)
+	return func(v interface{}) error {
+		if newline {
+			if _, err := io.ReadFull(r, header[:1]); err != nil {
+				return err
+			}
+			if header[0] != '\n' {
+				return fmt.Errorf("expected a newline before the first frame, got %q", header[0])
+			}
+			newline = false
+		}
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > maxFrameSize {
+			return fmt.Errorf("message of %d bytes is over the %d bytes limit", size, maxFrameSize)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		if err := codec.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("decoding %s message: %v", codec.Name(), err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,226 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+type message struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestFrames(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			// the JSON message that precedes frames ends with a newline
+			buf := bytes.NewBufferString("\n")
+			write := frameWriter(buf, codec)
+			sent := []message{{1, "one"}, {2, "two"}, {3, strings.Repeat("x", 1<<16)}}
+			for _, msg := range sent {
+				if err := write(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			read := frameReader(buf, codec)
+			for _, want := range sent {
+				var got message
+				if err := read(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Fatalf("want %+v, got %+v", want, got)
+				}
+			}
+			if err := read(new(message)); err != io.EOF {
+				t.Fatalf("want %v once frames run out, got %v", io.EOF, err)
+			}
+		})
+	}
+}
+
+func TestFrameReaderWantsNewline(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := frameWriter(buf, JSON)(message{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := frameReader(buf, JSON)(new(message)); err == nil {
+		t.Fatal("want an error without a newline before the first frame")
+	}
+}
+
+func TestFrameReaderRejectsOversizedFrames(t *testing.T) {
+	frame := []byte{'\n', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[1:], maxFrameSize+1)
+	r := &countingReader{r: bytes.NewReader(frame)}
+	err := frameReader(r, JSON)(new(message))
+	if err == nil || !strings.Contains(err.Error(), "over the") {
+		t.Fatalf("want an oversized frame refused, got %v", err)
+	}
+	if r.n != len(frame) {
+		t.Fatalf("want only the header read, got %d bytes read", r.n)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+// sizedCodec encodes anything as that many bytes.
+type sizedCodec int
+
+func (c sizedCodec) Name() string                               { return "sized" }
+func (c sizedCodec) Marshal(v interface{}) ([]byte, error)      { return make([]byte, int(c)), nil }
+func (c sizedCodec) Unmarshal(data []byte, v interface{}) error { return nil }
+
+func TestFrameWriterRejectsOversizedFrames(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := frameWriter(buf, sizedCodec(maxFrameSize+1))(message{}); err == nil {
+		t.Fatal("want an oversized frame refused")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("want nothing written, got %d bytes", buf.Len())
+	}
+	if err := frameWriter(buf, sizedCodec(maxFrameSize))(message{}); err != nil {
+		t.Fatalf("want a frame at the limit written, got %v", err)
+	}
+	if buf.Len() != 4+maxFrameSize {
+		t.Fatalf("want %d bytes written, got %d", 4+maxFrameSize, buf.Len())
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			p, err := encodePayload(codec, &StopJobRes{})
+			if err != nil || p != nil {
+				t.Fatalf("want no payload for a response without fields, got %q, %v", p, err)
+			}
+			want := listing(3, 2)
+			p, err = encodePayload(codec, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := new(ListAllRes)
+			if err := decodePayload(codec, p, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("want %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestCodecNames(t *testing.T) {
+	names := codecNames()
+	if len(names) < 2 || names[0] != JSON.Name() || names[1] != Gob.Name() {
+		t.Fatalf("want JSON then gob, got %v", names)
+	}
+	if _, ok := codecNamed("morse"); ok {
+		t.Fatal("want unknown codecs unknown")
+	}
+}
+
+func TestCallsWithEachCodec(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			p := connect(t, Hello{Codecs: []string{codec.Name()}}, nil)
+			if _, err := p.rep.StartProcess(context.Background(), &StartProcessReq{ProgramName: "app"}); err != nil {
+				t.Fatal(err)
+			}
+			res, err := p.rep.ListAll(context.Background(), &ListAllReq{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Running[p.client.ProgramID("app")]) != 1 {
+				t.Fatalf("want a process running, got %v", res.Running)
+			}
+		})
+	}
+}
+
+// listing is a ListAll response with that many processes of that many
+// programs.
+func listing(programs, procs int) *ListAllRes {
+	res := &ListAllRes{Running: make(map[container.ProgramID][]container.ProcessID)}
+	for i := 0; i < programs; i++ {
+		prgmID := container.ProgramID(fmt.Sprintf("program-%d", i))
+		for j := 0; j < procs; j++ {
+			res.Running[prgmID] = append(res.Running[prgmID], container.ProcessID(fmt.Sprintf("%s-process-%d", prgmID, j)))
+		}
+	}
+	return res
+}
+
+func benchmarkMarshal(b *testing.B, codec Codec) {
+	res := listing(100, 10)
+	data, err := codec.Marshal(res)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Marshal(res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkUnmarshal(b *testing.B, codec Codec) {
+	data, err := codec.Marshal(listing(100, 10))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := codec.Unmarshal(data, new(ListAllRes)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkCall measures round trips to an agent that lists a hundred
+// processes.
+func benchmarkCall(b *testing.B, codec Codec) {
+	p := connect(b, Hello{Codecs: []string{codec.Name()}}, nil)
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		if _, err := p.rep.StartProcess(ctx, &StartProcessReq{ProgramName: fmt.Sprintf("program-%d", i%10)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.rep.ListAll(ctx, &ListAllReq{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCodecJSONMarshal(b *testing.B)   { benchmarkMarshal(b, JSON) }
+func BenchmarkCodecGobMarshal(b *testing.B)    { benchmarkMarshal(b, Gob) }
+func BenchmarkCodecJSONUnmarshal(b *testing.B) { benchmarkUnmarshal(b, JSON) }
+func BenchmarkCodecGobUnmarshal(b *testing.B)  { benchmarkUnmarshal(b, Gob) }
+func BenchmarkCodecJSONCall(b *testing.B)      { benchmarkCall(b, JSON) }
+func BenchmarkCodecGobCall(b *testing.B)       { benchmarkCall(b, Gob) }
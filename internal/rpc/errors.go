@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+)
+
+// A Code tells what kind of error a call failed with.
+type Code string
+
+// The codes calls fail with.
+const (
+	CodeNotFound         Code = "not_found"
+	CodeAlreadyExists    Code = "already_exists"
+	CodeInvalidArgument  Code = "invalid_argument"
+	CodePermissionDenied Code = "permission_denied"
+	CodeUnavailable      Code = "unavailable"
+	CodeCanceled         Code = "canceled"
+	CodeDeadlineExceeded Code = "deadline_exceeded"
+	CodeUnimplemented    Code = "unimplemented"
+	CodeInternal         Code = "internal"
+)
+
+// Calls fail with errors that can be told apart with errors.Is. The errors
+// of an agent are the same whether it's called remotely or not, and so are
+// those of calls whose context is done.
+var (
+	ErrNotFound         = agent.ErrNotFound
+	ErrAlreadyExists    = agent.ErrAlreadyExists
+	ErrInvalidArgument  = agent.ErrInvalidArgument
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUnavailable      = errors.New("unavailable")
+	ErrCanceled         = context.Canceled
+	ErrDeadlineExceeded = context.DeadlineExceeded
+	// ErrUnsupportedMethod is returned by calls to methods an agent doesn't
+	// support, like newer methods on older agents.
+	ErrUnsupportedMethod = errors.New("unsupported method")
+	ErrInternal          = errors.New("internal error")
+)
+
+var codeErrors = map[Code]error{
+	CodeNotFound:         ErrNotFound,
+	CodeAlreadyExists:    ErrAlreadyExists,
+	CodeInvalidArgument:  ErrInvalidArgument,
+	CodePermissionDenied: ErrPermissionDenied,
+	CodeUnavailable:      ErrUnavailable,
+	CodeCanceled:         ErrCanceled,
+	CodeDeadlineExceeded: ErrDeadlineExceeded,
+	CodeUnimplemented:    ErrUnsupportedMethod,
+	CodeInternal:         ErrInternal,
+}
+
+// An Error is why a call failed.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (err *Error) Error() string { return fmt.Sprintf("%s: %s", err.Code, err.Message) }
+
+// Is tells if the error has the code of target, one of the Err* errors.
+func (err *Error) Is(target error) bool { return codeErrors[err.Code] == target }
+
+// describe an error an agent failed with, as the code and message a
+// response carries.
+func describe(err error) (Code, string) {
+	var rerr *Error
+	if errors.As(err, &rerr) {
+		return rerr.Code, rerr.Message
+	}
+	for code, kind := range codeErrors {
+		if errors.Is(err, kind) {
+			return code, err.Error()
+		}
+	}
+	return CodeInternal, err.Error()
+}
+
+// canceled is the error of a call given up on, once its context is done.
+func canceled(method string, err error) error {
+	code, _ := describe(err)
+	return errorf(code, "%s: %v", method, err)
+}
+
+// unsupported is the error of a call to a method the agent doesn't support.
+func unsupported(method string) error {
+	return errorf(CodeUnimplemented, "%s: %v", method, ErrUnsupportedMethod)
+}
+
+// responseError is the error a response carries, if it carries one.
+func responseError(res *rpcClientRes) error {
+	if res.Err == "" {
+		return nil
+	}
+	code := res.Code
+	if _, ok := codeErrors[code]; !ok {
+		code = CodeInternal // peers that don't know about codes
+	}
+	return &Error{Code: code, Message: res.Err}
+}
@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code Code
+		msg  string
+	}{
+		{"coded", errorf(CodeUnavailable, "down"), CodeUnavailable, "down"},
+		{"wrapped coded", fmt.Errorf("calling: %w", errorf(CodeNotFound, "gone")), CodeNotFound, "gone"},
+		{"agent's", fmt.Errorf("no such process: %w", agent.ErrNotFound), CodeNotFound, "no such process: not found"},
+		{"canceled", context.Canceled, CodeCanceled, "context canceled"},
+		{"past deadline", fmt.Errorf("waiting: %w", context.DeadlineExceeded), CodeDeadlineExceeded, "waiting: context deadline exceeded"},
+		{"anything else", errors.New("boom"), CodeInternal, "boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, msg := describe(tt.err)
+			if code != tt.code || msg != tt.msg {
+				t.Fatalf("want %v %q, got %v %q", tt.code, tt.msg, code, msg)
+			}
+		})
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	if err := responseError(&rpcClientRes{}); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	for code, kind := range codeErrors {
+		err := responseError(&rpcClientRes{Err: "failed", Code: code})
+		if !errors.Is(err, kind) {
+			t.Errorf("%v: want %v, got %v", code, kind, err)
+		}
+		for other, otherKind := range codeErrors {
+			if other != code && otherKind != kind && errors.Is(err, otherKind) {
+				t.Errorf("%v: isn't %v", code, other)
+			}
+		}
+	}
+	// peers that don't know about codes
+	for _, code := range []Code{"", "teleported"} {
+		err := responseError(&rpcClientRes{Err: "failed", Code: code})
+		var rerr *Error
+		if !errors.As(err, &rerr) || rerr.Code != CodeInternal || rerr.Message != "failed" {
+			t.Errorf("%q: want an internal error, got %#v", code, err)
+		}
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	err := canceled("ListAll", context.DeadlineExceeded)
+	if !errors.Is(err, ErrDeadlineExceeded) || err.Error() != "deadline_exceeded: ListAll: context deadline exceeded" {
+		t.Fatalf("want a deadline error, got %v", err)
+	}
+}
+
+func TestRemoteErrorCodes(t *testing.T) {
+	p := connect(t, Hello{}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start, err := p.rep.StartProcess(ctx, &StartProcessReq{ProgramName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		call func() error
+		code Code
+	}{
+		{"not found", func() error {
+			_, err := p.rep.StopProcess(ctx, &StopProcessReq{ProcessID: "nope"})
+			return err
+		}, CodeNotFound},
+		{"invalid argument", func() error {
+			_, err := p.rep.RunJob(ctx, &RunJobReq{ProgramName: "app", Options: agent.JobOptions{Completions: -1}})
+			return err
+		}, CodeInvalidArgument},
+		{"negative history limit", func() error {
+			_, err := p.rep.Schedule(ctx, &ScheduleReq{ProgramName: "app", Schedule: agent.Schedule{Name: "s", Cron: "@daily", HistoryLimit: -1}})
+			return err
+		}, CodeInvalidArgument},
+		// calls whose context is done aren't sent, so these don't race the
+		// agent's response
+		{"canceled", func() error {
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := p.rep.ListAll(ctx, &ListAllReq{})
+			return err
+		}, CodeCanceled},
+		{"deadline exceeded", func() error {
+			ctx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+			defer cancel()
+			_, err := p.rep.ListAll(ctx, &ListAllReq{})
+			return err
+		}, CodeDeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			var rerr *Error
+			if !errors.As(err, &rerr) || rerr.Code != tt.code || !errors.Is(err, codeErrors[tt.code]) {
+				t.Fatalf("want a %v error, got %#v", tt.code, err)
+			}
+		})
+	}
+	// errors don't break the session
+	if _, err := p.rep.StopProcess(ctx, &StopProcessReq{ProcessID: start.ProcessID, Timeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The versions of the protocol this package speaks. Peers agree on the
+// highest version they both speak.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// The optional features of the protocol. Peers only use the features they
+// both support.
+const (
+	// FeatureHeartbeat is for peers that send heartbeats, and expect them.
+	FeatureHeartbeat = "heartbeat"
+	// FeatureResume is for agents that can resume their session.
+	FeatureResume = "resume"
+	// FeatureEvents is for agents that push events to their subscribers.
+	FeatureEvents = "events"
+	// FeatureIdempotency is for agents that remember the results of calls
+	// made with an idempotency key.
+	FeatureIdempotency = "idempotency"
+	// FeatureReconfigure is for agents that upgrade programs to a new spec,
+	// see UpgradeProgramReq.
+	FeatureReconfigure = "reconfigure"
+)
+
+var supportedFeatures = []string{FeatureHeartbeat, FeatureResume, FeatureEvents, FeatureIdempotency, FeatureReconfigure}
+
+// Hello is the first message an agent sends on a stream, to tell who it is
+// and what it can do.
+type Hello struct {
+	// Name identifies the agent, it's stable across reconnects.
+	Name string `json:"name"`
+	// Session is resumed when the agent reconnects with the same one.
+	Session  string            `json:"session"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Backend  string            `json:"backend"`
+	Version  string            `json:"version"`
+	Capacity Capacity          `json:"capacity"`
+
+	// Protocol is the highest version of the protocol the agent speaks.
+	Protocol int      `json:"protocol"`
+	Features []string `json:"features,omitempty"`
+	// Codecs the agent can encode messages with, by order of preference.
+	// Older agents don't tell, and speak newline delimited JSON.
+	Codecs []string `json:"codecs,omitempty"`
+	// Methods the agent supports.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// Capacity is what the machine of an agent has to run processes with.
+type Capacity struct {
+	CPUs        int    `json:"cpus"`
+	MemoryBytes uint64 `json:"memory_bytes"`
+}
+
+// welcome is how the supervisor answers a Hello, with the protocol version,
+// features and codec they agreed on.
+type welcome struct {
+	// Resumed is false when the supervisor doesn't know the agent's session,
+	// which then starts over.
+	Resumed  bool     `json:"resumed"`
+	Protocol int      `json:"protocol"`
+	Features []string `json:"features,omitempty"`
+	// Codec the messages that follow are encoded with, if any.
+	Codec string `json:"codec,omitempty"`
+	// Token authenticates the supervisor, if it has one.
+	Token string `json:"token,omitempty"`
+	// Err tells why the agent can't be welcomed, if it can't.
+	Err string `json:"error,omitempty"`
+}
+
+func (w *welcome) supports(feature string) bool {
+	for _, f := range w.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// introduce fills what a hello says about this package's protocol.
+func introduce(hello *Hello) {
+	hello.Protocol = ProtocolVersion
+	hello.Features = supportedFeatures
+	if len(hello.Codecs) == 0 {
+		hello.Codecs = codecNames()
+	}
+	hello.Methods = make([]string, 0, len(rpcContract)+len(rpcStreamContract))
+	for method := range rpcContract {
+		hello.Methods = append(hello.Methods, method)
+	}
+	for method := range rpcStreamContract {
+		hello.Methods = append(hello.Methods, method)
+	}
+	sort.Strings(hello.Methods)
+}
+
+// negotiate the protocol version, features and codec to use with an agent.
+func negotiate(hello Hello, resumed bool) welcome {
+	if hello.Protocol < MinProtocolVersion {
+		return welcome{Err: fmt.Sprintf("protocol version %d isn't supported anymore, %d is the oldest supported", hello.Protocol, MinProtocolVersion)}
+	}
+	w := welcome{Resumed: resumed, Protocol: hello.Protocol}
+	if w.Protocol > ProtocolVersion {
+		w.Protocol = ProtocolVersion
+	}
+	for _, f := range supportedFeatures {
+		for _, theirs := range hello.Features {
+			if f == theirs {
+				w.Features = append(w.Features, f)
+			}
+		}
+	}
+	for _, theirs := range hello.Codecs {
+		if _, ok := codecNamed(theirs); ok {
+			w.Codec = theirs
+			break
+		}
+	}
+	return w
+}
+
+// accept the terms a supervisor welcomed an agent with.
+func (w *welcome) accept() error {
+	if w.Err != "" {
+		return fmt.Errorf("supervisor turned agent away: %s", w.Err)
+	}
+	if w.Protocol < MinProtocolVersion || w.Protocol > ProtocolVersion {
+		return fmt.Errorf("supervisor chose protocol version %d, only %d to %d are supported", w.Protocol, MinProtocolVersion, ProtocolVersion)
+	}
+	if _, ok := codecNamed(w.Codec); w.Codec != "" && !ok {
+		return fmt.Errorf("supervisor chose codec %q, which isn't supported", w.Codec)
+	}
+	return nil
+}
+
+func (hello *Hello) validate() error {
+	if hello.Name == "" {
+		return fmt.Errorf("agent didn't say its name")
+	}
+	if hello.Session == "" {
+		return fmt.Errorf("agent %q has no session", hello.Name)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hello   Hello
+		resumed bool
+		want    welcome
+	}{
+		{"oldest version", Hello{Protocol: MinProtocolVersion}, false, welcome{Protocol: MinProtocolVersion}},
+		{"current version", Hello{Protocol: ProtocolVersion}, false, welcome{Protocol: ProtocolVersion}},
+		{"newer agent", Hello{Protocol: ProtocolVersion + 1}, false, welcome{Protocol: ProtocolVersion}},
+		{"too old", Hello{Protocol: MinProtocolVersion - 1}, false, welcome{Err: fmt.Sprintf("protocol version %d isn't supported anymore, %d is the oldest supported", MinProtocolVersion-1, MinProtocolVersion)}},
+		{"resumed", Hello{Protocol: ProtocolVersion}, true, welcome{Resumed: true, Protocol: ProtocolVersion}},
+		{
+			"common features",
+			Hello{Protocol: ProtocolVersion, Features: []string{"teleport", FeatureEvents, FeatureHeartbeat}},
+			false,
+			welcome{Protocol: ProtocolVersion, Features: []string{FeatureHeartbeat, FeatureEvents}},
+		},
+		{
+			"first codec known",
+			Hello{Protocol: ProtocolVersion, Codecs: []string{"morse", "gob", "json"}},
+			false,
+			welcome{Protocol: ProtocolVersion, Codec: "gob"},
+		},
+		{"no codec known", Hello{Protocol: ProtocolVersion, Codecs: []string{"morse"}}, false, welcome{Protocol: ProtocolVersion}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := negotiate(tt.hello, tt.resumed)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWelcomeAccept(t *testing.T) {
+	for _, w := range []welcome{
+		{Protocol: MinProtocolVersion},
+		{Protocol: ProtocolVersion, Codec: "json"},
+	} {
+		if err := w.accept(); err != nil {
+			t.Errorf("%+v: %v", w, err)
+		}
+	}
+	for _, w := range []welcome{
+		{Err: "go away"},
+		{Protocol: MinProtocolVersion - 1},
+		{Protocol: ProtocolVersion + 1},
+		{Protocol: ProtocolVersion, Codec: "morse"},
+	} {
+		if err := w.accept(); err == nil {
+			t.Errorf("%+v: want an error", w)
+		}
+	}
+}
+
+// speaking makes an agent say it speaks a version of the protocol.
+func speaking(version int) SessionOption {
+	return func(op *operator) { op.hello.Protocol = version }
+}
+
+func TestProtocolVersions(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent int
+		want  int
+	}{
+		{"oldest", MinProtocolVersion, MinProtocolVersion},
+		{"current", ProtocolVersion, ProtocolVersion},
+		{"newer agent", ProtocolVersion + 1, ProtocolVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := connect(t, Hello{}, []SessionOption{speaking(tt.agent)})
+			if got := p.rep.Protocol(); got != tt.want {
+				t.Fatalf("want protocol %d, got %d", tt.want, got)
+			}
+			if _, err := p.rep.ListAll(context.Background(), &ListAllReq{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestProtocolTooOld(t *testing.T) {
+	cl := &fakeClient{}
+	session, err := NewSession(nil, cl, Hello{Name: "agent"}, speaking(MinProtocolVersion-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	sup, ag := net.Pipe()
+	operated := make(chan error, 1)
+	go func() { operated <- session.Operate(ag) }()
+	link, err := Greet(sup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RepresentAgent(link, cl); err == nil {
+		t.Fatal("want the agent turned away")
+	}
+	select {
+	case err := <-operated:
+		if err == nil {
+			t.Fatal("want the agent to know it was turned away")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent is still operating")
+	}
+}
+
+// without makes an agent say it doesn't support a method.
+func without(method string) SessionOption {
+	return func(op *operator) {
+		methods := op.hello.Methods[:0:0]
+		for _, m := range op.hello.Methods {
+			if m != method {
+				methods = append(methods, m)
+			}
+		}
+		op.hello.Methods = methods
+	}
+}
+
+// unaware makes an agent say nothing of the methods it supports, like older
+// agents.
+func unaware() SessionOption {
+	return func(op *operator) { op.hello.Methods = nil }
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  SessionOption
+		call func(ctx context.Context, rep RemoteAgent) error
+	}{
+		{
+			"not in the agent's hello",
+			without(methodGetJob),
+			func(ctx context.Context, rep RemoteAgent) error {
+				_, err := rep.GetJob(ctx, &GetJobReq{JobID: "job"})
+				return err
+			},
+		},
+		{
+			"unknown to the agent",
+			unaware(),
+			func(ctx context.Context, rep RemoteAgent) error {
+				return rep.(*representant).call(ctx, "Teleport", &ListAllReq{}, &ListAllRes{})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := connect(t, Hello{}, []SessionOption{tt.opt})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := tt.call(ctx, p.rep)
+			var rerr *Error
+			if !errors.As(err, &rerr) || rerr.Code != CodeUnimplemented || !errors.Is(err, ErrUnsupportedMethod) {
+				t.Fatalf("want a %v error, got %#v", CodeUnimplemented, err)
+			}
+			// the session carries on
+			if _, err := p.rep.ListAll(ctx, &ListAllReq{}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-p.rep.Done():
+				t.Fatal("want the session open")
+			default:
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	// how long an agent remembers the result of a call made with an
+	// idempotency key
+	idempotencyWindow = 10 * time.Minute
+	// how many results an agent remembers at most
+	idempotencyResults = 4096
+)
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey makes the calls made with ctx idempotent. An agent that
+// carried out a call with the same key within a while doesn't carry it out
+// again, it returns the result it got then. Retries of a call must use the
+// same key, and other calls a different one, see NewIdempotencyKey.
+//
+// Only results of calls that succeeded are remembered: a call that failed
+// is carried out again when it's retried.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// NewIdempotencyKey returns a key no other call uses.
+func NewIdempotencyKey() string { return uuid.New() }
+
+func idempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	return key
+}
+
+// idempotencyCache remembers the results of the calls made with a key. It
+// outlives the links and epochs of a session, since retries come on a new
+// link when the previous one broke. Keys are scoped by the principal that
+// made the call, so that one supervisor can't get the results of another's.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	calls   map[scopedKey]*keyedCall
+	results []scopedKey // keys of the calls that have a result, oldest first
+}
+
+type scopedKey struct {
+	principal string
+	key       string
+}
+
+type keyedCall struct {
+	method string
+	done   chan struct{} // closed once the call returned
+	res    interface{}
+	err    error
+	until  time.Time // when its result is forgotten
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{calls: make(map[scopedKey]*keyedCall)}
+}
+
+// do carries out a call a principal made with a key, unless a call it made
+// with the same key was carried out already, or is being carried out. Then
+// its result is returned instead, once there is one.
+func (cache *idempotencyCache) do(ctx context.Context, principal, key, method string, call func() (interface{}, error)) (interface{}, error) {
+	if key == "" {
+		return call()
+	}
+	sk := scopedKey{principal: principal, key: key}
+	cache.mu.Lock()
+	cache.forget(time.Now())
+	kc, ok := cache.calls[sk]
+	if ok {
+		cache.mu.Unlock()
+		if kc.method != method {
+			return nil, errorf(CodeInvalidArgument, "idempotency key %q was used to call %s", key, kc.method)
+		}
+		select {
+		case <-kc.done:
+			return kc.res, kc.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	kc = &keyedCall{method: method, done: make(chan struct{})}
+	cache.calls[sk] = kc
+	cache.mu.Unlock()
+
+	kc.res, kc.err = call()
+
+	cache.mu.Lock()
+	if kc.err != nil {
+		delete(cache.calls, sk) // retries carry it out again
+	} else {
+		kc.until = time.Now().Add(idempotencyWindow)
+		cache.results = append(cache.results, sk)
+		cache.forget(time.Now())
+	}
+	cache.mu.Unlock()
+	close(kc.done)
+	return kc.res, kc.err
+}
+
+// forget the results that are too old, or too many. It must be called with
+// mu held.
+func (cache *idempotencyCache) forget(now time.Time) {
+	for len(cache.results) > 0 {
+		oldest := cache.results[0]
+		if len(cache.results) <= idempotencyResults && now.Before(cache.calls[oldest].until) {
+			return
+		}
+		delete(cache.calls, oldest)
+		cache.results = cache.results[1:]
+	}
+}
@@ -0,0 +1,215 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counted is a call that counts how many times it's carried out.
+func counted(calls *int32, res interface{}, err error) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		atomic.AddInt32(calls, 1)
+		return res, err
+	}
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	ctx := context.Background()
+	cache := newIdempotencyCache()
+	var calls int32
+	for i := 0; i < 3; i++ {
+		res, err := cache.do(ctx, "sup", "key", "Start", counted(&calls, i, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != 0 {
+			t.Fatalf("want the first result replayed, got %v", res)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("want the call carried out once, got %d", calls)
+	}
+
+	if _, err := cache.do(ctx, "sup", "key", "Stop", counted(&calls, nil, nil)); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("want a key reused for another method refused, got %v", err)
+	}
+	if _, err := cache.do(ctx, "other", "key", "Start", counted(&calls, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.do(ctx, "sup", "", "Start", counted(&calls, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.do(ctx, "sup", "", "Start", counted(&calls, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 {
+		t.Fatalf("want calls of other principals and without keys carried out, got %d calls", calls)
+	}
+}
+
+func TestIdempotencyRetriesFailures(t *testing.T) {
+	ctx := context.Background()
+	cache := newIdempotencyCache()
+	var calls int32
+	if _, err := cache.do(ctx, "sup", "key", "Start", counted(&calls, nil, errors.New("boom"))); err == nil {
+		t.Fatal("want the call's error")
+	}
+	res, err := cache.do(ctx, "sup", "key", "Start", counted(&calls, "ok", nil))
+	if err != nil || res != "ok" {
+		t.Fatalf("want the retry carried out, got %v, %v", res, err)
+	}
+	if calls != 2 {
+		t.Fatalf("want 2 calls, got %d", calls)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	ctx := context.Background()
+	cache := newIdempotencyCache()
+	var calls int32
+	if _, err := cache.do(ctx, "sup", "key", "Start", counted(&calls, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.mu.Lock()
+	cache.forget(time.Now().Add(idempotencyWindow - time.Minute))
+	remembered := len(cache.calls)
+	cache.forget(time.Now().Add(idempotencyWindow + time.Second))
+	forgotten := len(cache.calls) == 0 && len(cache.results) == 0
+	cache.mu.Unlock()
+	if remembered != 1 {
+		t.Fatal("want the result remembered within the window")
+	}
+	if !forgotten {
+		t.Fatal("want the result forgotten past the window")
+	}
+
+	if _, err := cache.do(ctx, "sup", "key", "Start", counted(&calls, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("want the call carried out again, got %d calls", calls)
+	}
+}
+
+func TestIdempotencyCapacity(t *testing.T) {
+	ctx := context.Background()
+	cache := newIdempotencyCache()
+	var calls int32
+	for i := 0; i <= idempotencyResults; i++ {
+		if _, err := cache.do(ctx, "sup", fmt.Sprint(i), "Start", counted(&calls, nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the oldest result is forgotten on the next call
+	if _, err := cache.do(ctx, "sup", "0", "Start", counted(&calls, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if want := int32(idempotencyResults + 2); calls != want {
+		t.Fatalf("want the oldest result forgotten, got %d calls, want %d", calls, want)
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.results) > idempotencyResults {
+		t.Fatalf("want at most %d results, got %d", idempotencyResults, len(cache.results))
+	}
+}
+
+func TestIdempotencyConcurrentCalls(t *testing.T) {
+	ctx := context.Background()
+	cache := newIdempotencyCache()
+	var calls int32
+	release := make(chan struct{})
+	call := func() (interface{}, error) {
+		<-release
+		return atomic.AddInt32(&calls, 1), nil
+	}
+
+	const callers = 10
+	results := make(chan interface{}, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := cache.do(ctx, "sup", "key", "Start", call)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- res
+		}()
+	}
+
+	// a caller that gives up waiting
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	for {
+		cache.mu.Lock()
+		_, started := cache.calls[scopedKey{"sup", "key"}]
+		cache.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := cache.do(waitCtx, "sup", "key", "Start", call); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want the caller to give up, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(results)
+	for res := range results {
+		if res != int32(1) {
+			t.Errorf("want every caller to get the result of the one call, got %v", res)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("want the call carried out once, got %d", calls)
+	}
+}
+
+func TestRemoteIdempotency(t *testing.T) {
+	auth, err := NewAuthorizer(ACL{Tokens: []TokenGrant{
+		{Name: "a", Token: "token-a", Role: RoleDeployer},
+		{Name: "b", Token: "token-b", Role: RoleDeployer},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := connect(t, Hello{}, []SessionOption{WithAuthorizer(auth)}, WithToken("token-a"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	keyed := WithIdempotencyKey(ctx, NewIdempotencyKey())
+
+	first, err := p.rep.StartProcess(keyed, &StartProcessReq{ProgramName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := p.rep.StartProcess(keyed, &StartProcessReq{ProgramName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ProcessID != first.ProcessID {
+		t.Fatalf("want the retry to get %v, got %v", first.ProcessID, retry.ProcessID)
+	}
+	if procs := p.agent.ListAll()[p.client.ProgramID("app")]; len(procs) != 1 {
+		t.Fatalf("want 1 process, got %v", procs)
+	}
+
+	// another supervisor with the same key
+	other := p.operate(t, WithToken("token-b"))
+	defer other.Close()
+	res, err := other.StartProcess(keyed, &StartProcessReq{ProgramName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProcessID == first.ProcessID {
+		t.Fatal("want another principal's call carried out, not replayed")
+	}
+}
@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Link is a stream an agent said hello on. The hello and welcome are
+// newline delimited JSON, and the messages that follow are framed and
+// encoded with the codec the peers agreed on, if they agreed on one.
+type Link struct {
+	hello  Hello
+	peer   string // identity of the peer's certificate, if it has one
+	stream io.ReadWriteCloser
+	dec    *json.Decoder
+	codec  Codec // of the requests and responses of calls
+	send   func(v interface{}) error
+	read   func(v interface{}) error
+
+	// set when the peers didn't agree on heartbeats, so that a silent peer
+	// isn't taken for a dead one
+	silent bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newLink(r io.ReadWriteCloser) *Link {
+	link := &Link{
+		stream: r,
+		dec:    json.NewDecoder(r),
+		codec:  JSON,
+		closed: make(chan struct{}),
+	}
+	link.send = sender(r, json.NewEncoder(r).Encode)
+	link.read = reader(r, link.dec.Decode, link.expectHeartbeats)
+	return link
+}
+
+// agree on the terms of a welcome for the rest of the link. It must be
+// called before messages are read concurrently.
+func (link *Link) agree(w welcome) {
+	link.silent = !w.supports(FeatureHeartbeat)
+	codec, ok := codecNamed(w.Codec)
+	if !ok {
+		return // newline delimited JSON, like older peers
+	}
+	link.codec = codec
+	link.send = sender(link.stream, frameWriter(link.stream, codec))
+	// what the JSON decoder read ahead is the start of the frames
+	frames := io.MultiReader(link.dec.Buffered(), link.stream)
+	link.read = reader(link.stream, frameReader(frames, codec), link.expectHeartbeats)
+}
+
+func (link *Link) expectHeartbeats() bool { return !link.silent }
+
+// sendMsg sends a message, encoding the request or response of a call it
+// carries with the codec of the link.
+func (link *Link) sendMsg(v interface{}) error {
+	switch msg := v.(type) {
+	case rpcClientReq:
+		req, err := encodePayload(link.codec, msg.Request)
+		if err != nil {
+			return fmt.Errorf("encoding request: %v", err)
+		}
+		v = rpcServerReq{
+			ID:         msg.ID,
+			MethodName: msg.MethodName,
+			Request:    req,
+			Key:        msg.Key,
+			Timeout:    msg.Timeout,
+			Credit:     msg.Credit,
+			Cancel:     msg.Cancel,
+			Heartbeat:  msg.Heartbeat,
+		}
+	case rpcServerRes:
+		return link.sendMsg(&msg)
+	case *rpcServerRes:
+		res, err := encodePayload(link.codec, msg.Response)
+		if err != nil {
+			return fmt.Errorf("encoding response: %v", err)
+		}
+		v = rpcClientRes{
+			ID:          msg.ID,
+			Response:    res,
+			Err:         msg.Err,
+			Code:        msg.Code,
+			Unsupported: msg.Unsupported,
+			Stream:      msg.Stream,
+			Seq:         msg.Seq,
+			Event:       msg.Event,
+			Heartbeat:   msg.Heartbeat,
+		}
+	}
+	return link.send(v)
+}
+
+// readMsg reads a message. The request or response of a call it carries is
+// left encoded with the codec of the link.
+func (link *Link) readMsg(v interface{}) error {
+	if err := link.read(v); err != nil {
+		return err
+	}
+	if res, ok := v.(*rpcClientRes); ok {
+		res.codec = link.codec
+	}
+	return nil
+}
+
+// Greet waits for the agent on the other end of a stream to say hello.
+func Greet(r io.ReadWriteCloser) (*Link, error) {
+	link := newLink(r)
+	if err := link.readMsg(&link.hello); err != nil {
+		return nil, fmt.Errorf("reading hello: %v", err)
+	}
+	if err := link.hello.validate(); err != nil {
+		return nil, fmt.Errorf("invalid hello: %v", err)
+	}
+	return link, nil
+}
+
+// Hello is how the agent introduced itself on the link.
+func (link *Link) Hello() Hello { return link.hello }
+
+// Close the link and its stream.
+func (link *Link) Close() error {
+	var err error
+	link.closeOnce.Do(func() {
+		close(link.closed)
+		err = link.stream.Close()
+	})
+	return err
+}
+
+// Both ends of a stream send a heartbeat when they have nothing else to say,
+// so that a peer that went silent for too long can be told apart from one
+// that's just idle. Deadlines only apply to streams that support them, like
+// network connections.
+const (
+	heartbeatInterval = 5 * time.Second
+	heartbeatTimeout  = 3 * heartbeatInterval
+	writeTimeout      = 10 * time.Second
+)
+
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+func sender(w io.Writer, encode func(v interface{}) error) func(v interface{}) error {
+	dl, hasDeadline := w.(deadliner)
+	return func(v interface{}) error {
+		if hasDeadline {
+			if err := dl.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+				return err
+			}
+		}
+		return peerError(encode(v), "peer isn't reading", writeTimeout)
+	}
+}
+
+func reader(r io.Reader, decode func(v interface{}) error, expectHeartbeats func() bool) func(v interface{}) error {
+	dl, hasDeadline := r.(deadliner)
+	return func(v interface{}) error {
+		if hasDeadline {
+			var deadline time.Time // none if the peer can stay silent
+			if expectHeartbeats() {
+				deadline = time.Now().Add(heartbeatTimeout)
+			}
+			if err := dl.SetReadDeadline(deadline); err != nil {
+				return err
+			}
+		}
+		return peerError(decode(v), "peer went silent", heartbeatTimeout)
+	}
+}
+
+func peerError(err error, what string, timeout time.Duration) error {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return fmt.Errorf("%s for %v: %v", what, timeout, err)
+	}
+	return err
+}
+
+// heartbeat sends a heartbeat message on every interval until done is closed.
+func heartbeat(send func(interface{}) error, msg interface{}, done <-chan struct{}) error {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := send(msg); err != nil {
+				return fmt.Errorf("sending heartbeat: %v", err)
+			}
+		}
+	}
+}
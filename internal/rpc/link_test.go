@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hurried scales down the read deadlines of a connection, so that a peer is
+// silent for too long after milliseconds rather than seconds.
+type hurried struct{ net.Conn }
+
+func (conn hurried) SetReadDeadline(t time.Time) error {
+	if !t.IsZero() {
+		t = time.Now().Add(time.Until(t) / 300)
+	}
+	return conn.Conn.SetReadDeadline(t)
+}
+
+func hurry(conn net.Conn) net.Conn { return hurried{conn} }
+
+func TestHeartbeats(t *testing.T) {
+	t.Run("dead link", func(t *testing.T) {
+		_, session := newSession(t)
+		raw, errc := superviseRaw(t, session, welcome{Protocol: ProtocolVersion, Features: []string{FeatureHeartbeat}}, hurry)
+
+		// a supervisor that has nothing to say but heartbeats is alive, for
+		// many times the timeout
+		for deadline := time.Now().Add(300 * time.Millisecond); time.Now().Before(deadline); {
+			raw.send(t, rpcClientReq{Heartbeat: true})
+			select {
+			case err := <-errc:
+				t.Fatalf("want the link kept, got %v", err)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		// one that stops sending them is gone
+		select {
+		case err := <-errc:
+			if err == nil || !strings.Contains(err.Error(), "peer went silent") {
+				t.Fatalf("want the link dropped for going silent, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("want the link dropped")
+		}
+	})
+
+	t.Run("peer without heartbeats", func(t *testing.T) {
+		_, session := newSession(t)
+		_, errc := superviseRaw(t, session, welcome{Protocol: ProtocolVersion}, hurry)
+		select {
+		case err := <-errc:
+			t.Fatalf("want a silent peer that doesn't send heartbeats kept, got %v", err)
+		case <-time.After(300 * time.Millisecond):
+		}
+	})
+}
@@ -0,0 +1,768 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/log"
+)
+
+/*
+	rpc internal details
+*/
+
+// A stream starts with the agent sending its Hello, and the supervisor
+// welcoming it with the protocol version, features and codec they'll use.
+// Then, every request carries an ID, which its response carries back. This
+// lets many calls be in flight at once, and their responses come back in any
+// order. The agent also pushes events it was
+// asked to subscribe to, as responses with no ID. Heartbeats are messages
+// with no ID either, that are otherwise ignored.
+//
+// When a stream breaks, the agent reconnects and resumes its session on a
+// new stream: the supervisor resends the requests it's still waiting on, in
+// the order of their IDs, and the agent resends the responses it couldn't
+// deliver or that were lost with the stream.
+//
+// A request for a method the agent doesn't know gets a response flagged as
+// unsupported, and the stream goes on. A response to a call that failed
+// carries the Code of its error.
+//
+// A streaming call gets many responses flagged as part of a stream and
+// numbered in order, then a last response that isn't. The supervisor grants
+// the agent credit for how many responses it can send so far, and cancels
+// the call once it's had enough.
+//
+// A request carries the time left before the deadline of its call, if it has
+// one, rather than the deadline itself: the clocks of the supervisor and the
+// agent needn't agree. A call the supervisor gives up on is canceled the same
+// way a stream is.
+//
+// A request can carry an idempotency key, see WithIdempotencyKey. The agent
+// remembers the results of the calls made with a key for a while, across
+// links and sessions, and answers calls with a key it knows with them.
+
+type rpcClientReq struct {
+	ID         uint64        `json:"id"`
+	MethodName string        `json:"method_name"`
+	Request    interface{}   `json:"request"`
+	Key        string        `json:"key,omitempty"`
+	Timeout    time.Duration `json:"timeout,omitempty"`
+	Credit     uint64        `json:"credit,omitempty"`
+	Cancel     bool          `json:"cancel,omitempty"`
+	Heartbeat  bool          `json:"heartbeat,omitempty"`
+}
+
+type rpcServerReq struct {
+	ID         uint64        `json:"id"`
+	MethodName string        `json:"method_name"`
+	Request    payload       `json:"request"`
+	Key        string        `json:"key,omitempty"`
+	Timeout    time.Duration `json:"timeout,omitempty"`
+	Credit     uint64        `json:"credit,omitempty"`
+	Cancel     bool          `json:"cancel,omitempty"`
+	Heartbeat  bool          `json:"heartbeat,omitempty"`
+}
+
+type rpcClientRes struct {
+	ID          uint64       `json:"id"`
+	Response    payload      `json:"response"`
+	Err         string       `json:"error"`
+	Code        Code         `json:"code,omitempty"`
+	Unsupported bool         `json:"unsupported,omitempty"`
+	Stream      bool         `json:"stream,omitempty"`
+	Seq         uint64       `json:"seq,omitempty"`
+	Event       *agent.Event `json:"event,omitempty"`
+	Heartbeat   bool         `json:"heartbeat,omitempty"`
+
+	codec Codec // of the link it was read on
+}
+
+type rpcServerRes struct {
+	ID          uint64       `json:"id"`
+	Response    interface{}  `json:"response"`
+	Err         string       `json:"error"`
+	Code        Code         `json:"code,omitempty"`
+	Unsupported bool         `json:"unsupported,omitempty"`
+	Stream      bool         `json:"stream,omitempty"`
+	Seq         uint64       `json:"seq,omitempty"`
+	Event       *agent.Event `json:"event,omitempty"`
+	Heartbeat   bool         `json:"heartbeat,omitempty"`
+}
+
+const (
+	eventBuffer = 128
+	// how long an agent has to resume its session once its stream broke
+	resumeWindow = 30 * time.Second
+	// how many responses an agent remembers, in case they need to be resent
+	replayWindow = 256
+	// how many events an agent keeps while it's disconnected
+	unsentEvents = 1024
+)
+
+type methodCall func(context.Context, interface{}) (interface{}, error)
+
+var rpcContract = make(map[string]func(op *operator) (method methodCall, req interface{}))
+
+type representant struct {
+	provider container.ProgramProvider
+	hello    Hello
+	methods  map[string]bool // nil if the agent didn't tell
+	terms    welcome
+	token    string // presented to the agent when it's welcomed
+
+	// a message must be sent in one piece, and requests are sent in the
+	// order of their IDs
+	sendMu sync.Mutex
+	nextID uint64
+
+	mu      sync.Mutex
+	link    *Link  // nil while the agent is away
+	gen     uint64 // bumped every time a link is attached
+	pending map[uint64]*pendingCall
+	err     error // why the agent is gone for good, if it is
+	done    chan struct{}
+	// streams canceled while the agent was away
+	canceled []uint64
+
+	events chan agent.Event
+}
+
+type pendingCall struct {
+	req      rpcClientReq
+	deadline time.Time // zero if there's none
+	resc     chan *rpcClientRes
+	stream   *clientStream // nil unless it's a streaming call
+}
+
+// request to send for the call, with the time left before its deadline.
+func (call *pendingCall) request() rpcClientReq {
+	req := call.req
+	if !call.deadline.IsZero() {
+		req.Timeout = time.Until(call.deadline)
+		if req.Timeout <= 0 {
+			req.Timeout = 1 // it's about to be canceled anyways
+		}
+	}
+	return req
+}
+
+func (rep *representant) Hello() Hello               { return rep.hello }
+func (rep *representant) Events() <-chan agent.Event { return rep.events }
+func (rep *representant) Done() <-chan struct{}      { return rep.done }
+func (rep *representant) Err() error                 { return rep.brokenErr() }
+func (rep *representant) Protocol() int              { return rep.terms.Protocol }
+
+func (rep *representant) Supports(feature string) bool { return rep.terms.supports(feature) }
+
+func (rep *representant) Resume(link *Link) error {
+	if !rep.Supports(FeatureResume) {
+		return fmt.Errorf("agent %q can't resume sessions", rep.hello.Name)
+	}
+	if link.hello.Name != rep.hello.Name || link.hello.Session != rep.hello.Session {
+		return fmt.Errorf("agent %q can't resume session of agent %q", link.hello.Name, rep.hello.Name)
+	}
+	return rep.attach(link, true)
+}
+
+func (rep *representant) Close() error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.shutdown(fmt.Errorf("closed"))
+	return nil
+}
+
+func (rep *representant) call(ctx context.Context, method string, req, res interface{}) error {
+	call := &pendingCall{
+		req:  rpcClientReq{MethodName: method, Request: req, Key: idempotencyKey(ctx)},
+		resc: make(chan *rpcClientRes, 1),
+	}
+	if call.req.Key != "" && !rep.Supports(FeatureIdempotency) {
+		return fmt.Errorf("%s: agent %q doesn't support idempotency keys", method, rep.hello.Name)
+	}
+	if err := ctx.Err(); err != nil {
+		// the agent never hears of it
+		return canceled(method, err)
+	}
+	call.deadline, _ = ctx.Deadline()
+	if err := rep.start(call); err != nil {
+		return err
+	}
+
+	var (
+		rpcRes *rpcClientRes
+		ok     bool
+	)
+	select {
+	case rpcRes, ok = <-call.resc:
+	case <-ctx.Done():
+		rep.cancel(call)
+		return canceled(method, ctx.Err())
+	}
+	if !ok {
+		return errorf(CodeUnavailable, "agent is gone: %v", rep.brokenErr())
+	}
+	if rpcRes.Unsupported {
+		return unsupported(method)
+	}
+	if err := responseError(rpcRes); err != nil {
+		return err
+	}
+	if err := decodePayload(rpcRes.codec, rpcRes.Response, res); err != nil {
+		return errorf(CodeInternal, "unmarshalling response: %v", err)
+	}
+	return nil
+}
+
+// start a call, which stays pending until its response is received.
+func (rep *representant) start(call *pendingCall) error {
+	method := call.req.MethodName
+	if rep.methods != nil && !rep.methods[method] {
+		return unsupported(method)
+	}
+
+	rep.sendMu.Lock()
+	defer rep.sendMu.Unlock()
+	rep.nextID++
+	call.req.ID = rep.nextID
+	link, err := rep.register(call)
+	if err != nil {
+		return err
+	}
+	if link != nil {
+		if err := link.sendMsg(call.request()); err != nil {
+			// the call stays pending, it's resent if the agent resumes
+			rep.detach(link, fmt.Errorf("sending rpc request message: %v", err))
+		}
+	}
+	return nil
+}
+
+func (rep *representant) register(call *pendingCall) (*Link, error) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if rep.err != nil {
+		return nil, errorf(CodeUnavailable, "agent is gone: %v", rep.err)
+	}
+	rep.pending[call.req.ID] = call
+	return rep.link, nil
+}
+
+func (rep *representant) brokenErr() error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return rep.err
+}
+
+// attach starts using a link to talk to the agent, replacing the previous
+// one. Calls still waiting for a response are resent on it.
+func (rep *representant) attach(link *Link, resumed bool) error {
+	rep.sendMu.Lock()
+	defer rep.sendMu.Unlock()
+
+	rep.mu.Lock()
+	if rep.err != nil {
+		rep.mu.Unlock()
+		return fmt.Errorf("agent is gone: %v", rep.err)
+	}
+	if rep.link != nil {
+		_ = rep.link.Close()
+	}
+	rep.link = link
+	rep.gen++
+	pending := make([]rpcClientReq, 0, len(rep.pending))
+	for _, call := range rep.pending {
+		pending = append(pending, call.request())
+	}
+	for _, id := range rep.canceled {
+		pending = append(pending, rpcClientReq{ID: id, Cancel: true})
+	}
+	rep.canceled = nil
+	rep.mu.Unlock()
+	sort.Sort(requestsByID(pending))
+
+	w := negotiate(link.hello, resumed)
+	w.Token = rep.token
+	if err := link.sendMsg(w); err != nil {
+		err = fmt.Errorf("sending welcome: %v", err)
+		rep.detach(link, err)
+		return err
+	}
+	link.agree(w)
+	go rep.readResponses(link)
+	if w.supports(FeatureHeartbeat) {
+		go func() {
+			err := heartbeat(func(v interface{}) error { return rep.sendOn(link, v) }, rpcClientReq{Heartbeat: true}, link.closed)
+			if err != nil {
+				rep.detach(link, err)
+			}
+		}()
+	}
+	for _, req := range pending {
+		if err := link.sendMsg(req); err != nil {
+			rep.detach(link, fmt.Errorf("resending rpc request message: %v", err))
+			break
+		}
+	}
+	return nil
+}
+
+func (rep *representant) sendOn(link *Link, v interface{}) error {
+	rep.sendMu.Lock()
+	defer rep.sendMu.Unlock()
+	return link.sendMsg(v)
+}
+
+// readResponses dispatches responses to the calls waiting for them, until
+// the link breaks.
+func (rep *representant) readResponses(link *Link) {
+	for {
+		rpcRes := new(rpcClientRes)
+		if err := link.readMsg(rpcRes); err != nil {
+			rep.detach(link, err)
+			return
+		}
+		if rpcRes.Heartbeat {
+			continue
+		}
+		if rpcRes.Event != nil {
+			rep.pushEvent(link, *rpcRes.Event)
+			continue
+		}
+
+		rep.mu.Lock()
+		call, ok := rep.pending[rpcRes.ID]
+		if ok && rpcRes.Stream {
+			call.stream.push(rpcRes)
+			rep.mu.Unlock()
+			continue
+		}
+		delete(rep.pending, rpcRes.ID)
+		rep.mu.Unlock()
+		if ok {
+			call.resc <- rpcRes
+		}
+	}
+}
+
+func (rep *representant) pushEvent(link *Link, ev agent.Event) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if rep.link != link {
+		return // from a stale link
+	}
+	select {
+	case rep.events <- ev:
+	default:
+		log.KV("event.kind", ev.Kind).Error("dropping event, nobody is listening")
+	}
+}
+
+// detach stops using a broken link. If the agent doesn't resume within the
+// resume window, it's gone for good.
+func (rep *representant) detach(link *Link, err error) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	_ = link.Close()
+	if rep.link != link {
+		return // already detached
+	}
+	rep.link = nil
+	gen := rep.gen
+	log.KV("agent.name", rep.hello.Name).Err(err).Info("lost link to agent, waiting for it to resume")
+
+	time.AfterFunc(resumeWindow, func() {
+		rep.mu.Lock()
+		defer rep.mu.Unlock()
+		if rep.link == nil && rep.gen == gen {
+			rep.shutdown(fmt.Errorf("agent didn't resume within %v: %v", resumeWindow, err))
+		}
+	})
+}
+
+// shutdown gives up on the agent, and all calls waiting fail. It must be
+// called with mu held.
+func (rep *representant) shutdown(err error) {
+	if rep.err != nil {
+		return // already gone
+	}
+	rep.err = err
+	if rep.link != nil {
+		_ = rep.link.Close()
+		rep.link = nil
+	}
+	for id, call := range rep.pending {
+		close(call.resc)
+		delete(rep.pending, id)
+	}
+	close(rep.events)
+	close(rep.done)
+}
+
+type requestsByID []rpcClientReq
+
+func (r requestsByID) Len() int           { return len(r) }
+func (r requestsByID) Less(i, j int) bool { return r[i].ID < r[j].ID }
+func (r requestsByID) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+type operator struct {
+	agent    *agent.Agent
+	provider container.ProgramProvider
+	hello    Hello
+	auth     *Authorizer // nil if every call is allowed
+
+	sendMu sync.Mutex // a message must be sent in one piece
+
+	mu      sync.Mutex
+	link    *Link                         // nil while disconnected
+	epoch   uint64                        // bumped when a new supervisor takes over
+	lastID  uint64                        // of the latest request received
+	running map[uint64]bool               // requests being handled
+	calls   map[uint64]context.CancelFunc // cancel the requests being handled
+	replay  map[uint64]*rpcServerRes
+	replays []uint64 // IDs of the responses in replay, oldest first
+	unsent  []*rpcServerRes
+	streams map[uint64]*serverStream
+	keys    *idempotencyCache
+
+	subMu sync.Mutex
+	sub   *agent.Subscription
+}
+
+// service says hello on a link and handles requests concurrently, until the
+// link breaks. Requests still being handled then carry on, and their
+// responses are sent once the session is resumed.
+func (op *operator) service(link *Link) error {
+	defer op.detach(link)
+	if err := op.sendOn(link, op.hello); err != nil {
+		return fmt.Errorf("sending hello: %v", err)
+	}
+	var w welcome
+	if err := link.readMsg(&w); err != nil {
+		return fmt.Errorf("reading welcome: %v", err)
+	}
+	if err := w.accept(); err != nil {
+		return err
+	}
+	link.agree(w)
+	var who principal
+	if op.auth != nil {
+		who = op.auth.authenticate(link.peer, w.Token)
+	} else if link.peer != "" {
+		who.name = "cert:" + link.peer // idempotency keys are still scoped by it
+	}
+	op.attach(link, w.Resumed)
+
+	if w.supports(FeatureHeartbeat) {
+		go func() {
+			err := heartbeat(func(v interface{}) error { return op.sendOn(link, v) }, rpcServerRes{Heartbeat: true}, link.closed)
+			if err != nil {
+				op.handleError(err)
+				_ = link.Close()
+			}
+		}()
+	}
+
+	for {
+		rpcReq := new(rpcServerReq)
+		if err := link.readMsg(rpcReq); err != nil {
+			return fmt.Errorf("can't read message: %v", err)
+		}
+		if rpcReq.Heartbeat {
+			continue
+		}
+
+		if rpcReq.Cancel {
+			op.cancel(rpcReq.ID)
+			continue
+		}
+		if rpcReq.MethodName == "" && rpcReq.Credit > 0 {
+			op.grant(rpcReq.ID, rpcReq.Credit)
+			continue
+		}
+
+		epoch, isNew := op.receive(rpcReq.ID)
+		if !isNew {
+			op.grant(rpcReq.ID, rpcReq.Credit)
+			op.resend(rpcReq.ID)
+			continue
+		}
+		rpcRes := &rpcServerRes{ID: rpcReq.ID}
+
+		// find where to dispatch
+		var (
+			method       methodCall
+			streamMethod streamCall
+			req          interface{}
+		)
+		if dispatcher, ok := rpcContract[rpcReq.MethodName]; ok {
+			method, req = dispatcher(op)
+		} else if dispatcher, ok := rpcStreamContract[rpcReq.MethodName]; ok {
+			streamMethod, req = dispatcher(op)
+		} else {
+			rpcRes.Code, rpcRes.Err = CodeUnimplemented, fmt.Sprintf("unsupported method %q", rpcReq.MethodName)
+			rpcRes.Unsupported = true
+			if err := op.reply(epoch, rpcRes); err != nil {
+				op.handleError(fmt.Errorf("sending unsupported method error: %v", err))
+			}
+			continue
+		}
+		if op.auth != nil {
+			if err := op.auth.authorize(op.hello.Session, who, rpcReq.MethodName); err != nil {
+				rpcRes.Code, rpcRes.Err = describe(err)
+				if err := op.reply(epoch, rpcRes); err != nil {
+					op.handleError(fmt.Errorf("sending permission error: %v", err))
+				}
+				continue
+			}
+		}
+
+		// decode the method's arguments
+		if err := decodePayload(link.codec, rpcReq.Request, req); err != nil {
+			rpcRes.Err = fmt.Sprintf("invalid request: %v", err)
+			rpcRes.Code = CodeInvalidArgument
+			if err := op.reply(epoch, rpcRes); err != nil {
+				op.handleError(fmt.Errorf("sending unmarshal error: %v", err))
+			}
+			continue
+		}
+
+		if streamMethod != nil {
+			stream := op.openStream(epoch, rpcReq.ID, rpcReq.Credit, rpcReq.Timeout)
+			go func() {
+				if err := op.invokeStream(stream, streamMethod, req, rpcRes); err != nil {
+					op.handleError(err)
+				}
+			}()
+			continue
+		}
+		ctx, done := op.begin(epoch, rpcReq.ID, rpcReq.Timeout)
+		go func() {
+			defer done()
+			if err := op.invoke(ctx, epoch, who, rpcReq, method, req, rpcRes); err != nil {
+				op.handleError(err)
+			}
+		}()
+	}
+}
+
+// begin a call, which can be canceled until it's done. Its context is done
+// once it's canceled, or once the time it was given is up.
+func (op *operator) begin(epoch, id uint64, timeout time.Duration) (ctx context.Context, done func()) {
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if epoch != op.epoch {
+		cancel()
+		return ctx, cancel
+	}
+	op.calls[id] = cancel
+	return ctx, func() {
+		cancel()
+		op.mu.Lock()
+		defer op.mu.Unlock()
+		if epoch == op.epoch {
+			delete(op.calls, id)
+		}
+	}
+}
+
+func (op *operator) invoke(ctx context.Context, epoch uint64, who principal, rpcReq *rpcServerReq, method methodCall, req interface{}, rpcRes *rpcServerRes) error {
+	res, err := op.keys.do(ctx, who.name, rpcReq.Key, rpcReq.MethodName, func() (interface{}, error) {
+		return method(ctx, req)
+	})
+	if err != nil {
+		rpcRes.Code, rpcRes.Err = describe(err)
+		if err := op.reply(epoch, rpcRes); err != nil {
+			return fmt.Errorf("sending method call error: %v", err)
+		}
+		return nil
+	}
+
+	// encode the response
+	rpcRes.Response = res
+	if err := op.reply(epoch, rpcRes); err != nil {
+		return fmt.Errorf("sending method call response: %v", err)
+	}
+	return nil
+}
+
+// attach starts using a link to talk to the supervisor, replacing the
+// previous one. If the supervisor is resuming the session, what couldn't be
+// sent is sent on the new link. Otherwise, the session starts over.
+func (op *operator) attach(link *Link, resumed bool) {
+	op.sendMu.Lock()
+	defer op.sendMu.Unlock()
+
+	op.mu.Lock()
+	if op.link != nil {
+		_ = op.link.Close()
+	}
+	op.link = link
+	if !resumed {
+		op.epoch++
+		op.lastID = 0
+		op.running = make(map[uint64]bool)
+		op.replay = make(map[uint64]*rpcServerRes)
+		op.replays = nil
+		op.unsent = nil
+		for _, stream := range op.streams {
+			stream.cancel()
+		}
+		op.streams = make(map[uint64]*serverStream)
+		op.calls = make(map[uint64]context.CancelFunc)
+	}
+	unsent := op.unsent
+	op.unsent = nil
+	op.mu.Unlock()
+
+	if !resumed {
+		op.unsubscribe()
+	}
+	for i, msg := range unsent {
+		if err := link.sendMsg(msg); err != nil {
+			op.mu.Lock()
+			op.unsent = append(unsent[i:], op.unsent...)
+			op.mu.Unlock()
+			_ = link.Close()
+			return
+		}
+	}
+}
+
+func (op *operator) detach(link *Link) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	_ = link.Close()
+	if op.link == link {
+		op.link = nil
+	}
+}
+
+// receive tells if a request is new, or if it's resent after a reconnect.
+func (op *operator) receive(id uint64) (epoch uint64, isNew bool) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if id <= op.lastID {
+		return op.epoch, false
+	}
+	op.lastID = id
+	op.running[id] = true
+	return op.epoch, true
+}
+
+// resend the response to a request received before a reconnect.
+func (op *operator) resend(id uint64) {
+	op.mu.Lock()
+	res, done := op.replay[id]
+	running := op.running[id]
+	op.mu.Unlock()
+	switch {
+	case running: // sent once done
+		return
+	case !done:
+		res = &rpcServerRes{ID: id, Err: "response was lost", Code: CodeUnavailable}
+	}
+	if err := op.send(res); err != nil {
+		op.handleError(fmt.Errorf("resending response: %v", err))
+	}
+}
+
+// reply to a request, unless it came from a supervisor that isn't there
+// anymore. The response is remembered in case it needs to be resent.
+func (op *operator) reply(epoch uint64, res *rpcServerRes) error {
+	op.mu.Lock()
+	if epoch != op.epoch {
+		op.mu.Unlock()
+		return nil
+	}
+	delete(op.running, res.ID)
+	op.replay[res.ID] = res
+	op.replays = append(op.replays, res.ID)
+	if len(op.replays) > replayWindow {
+		delete(op.replay, op.replays[0])
+		op.replays = op.replays[1:]
+	}
+	op.mu.Unlock()
+	return op.send(res)
+}
+
+// send a message to the supervisor, or keep it for when the session resumes
+// if it can't be sent.
+func (op *operator) send(res *rpcServerRes) error {
+	op.sendMu.Lock()
+	defer op.sendMu.Unlock()
+
+	op.mu.Lock()
+	link := op.link
+	op.mu.Unlock()
+	if link == nil {
+		op.keep(res)
+		return nil
+	}
+	if err := link.sendMsg(res); err != nil {
+		op.keep(res)
+		_ = link.Close()
+		return err
+	}
+	return nil
+}
+
+func (op *operator) sendOn(link *Link, v interface{}) error {
+	op.sendMu.Lock()
+	defer op.sendMu.Unlock()
+	return link.sendMsg(v)
+}
+
+func (op *operator) keep(res *rpcServerRes) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if res.Event != nil && len(op.unsent) >= unsentEvents {
+		return // drop events rather than growing without bounds
+	}
+	op.unsent = append(op.unsent, res)
+}
+
+// subscribe pushes the agent's events of the given kinds to the peer,
+// replacing the previous subscription.
+func (op *operator) subscribe(kinds []agent.EventKind) {
+	sub := op.agent.Subscribe(kinds...)
+	op.subMu.Lock()
+	if op.sub != nil {
+		op.sub.Close()
+	}
+	op.sub = sub
+	op.subMu.Unlock()
+
+	go func() {
+		for ev := range sub.C {
+			ev := ev
+			if err := op.send(&rpcServerRes{Event: &ev}); err != nil {
+				op.handleError(fmt.Errorf("pushing event: %v", err))
+			}
+		}
+	}()
+}
+
+func (op *operator) unsubscribe() {
+	op.subMu.Lock()
+	defer op.subMu.Unlock()
+	if op.sub != nil {
+		op.sub.Close()
+		op.sub = nil
+	}
+}
+
+func (op *operator) handleError(err error) {
+	log.Err(err).Error("unexpected error")
+}
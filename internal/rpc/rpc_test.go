@@ -0,0 +1,216 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+)
+
+// rawPeer is one end of a pipe that tests script message by message, in
+// newline delimited JSON.
+type rawPeer struct {
+	conn net.Conn
+	enc  *json.Encoder
+	msgs chan json.RawMessage // closed once the pipe is
+}
+
+func newRawPeer(t *testing.T, conn net.Conn) *rawPeer {
+	raw := &rawPeer{conn: conn, enc: json.NewEncoder(conn), msgs: make(chan json.RawMessage, 256)}
+	go func() {
+		defer close(raw.msgs)
+		dec := json.NewDecoder(conn)
+		for {
+			var msg json.RawMessage
+			if err := dec.Decode(&msg); err != nil {
+				return
+			}
+			raw.msgs <- msg
+		}
+	}()
+	t.Cleanup(func() { _ = conn.Close() })
+	return raw
+}
+
+func (raw *rawPeer) send(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := raw.enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (raw *rawPeer) recv(t *testing.T, v interface{}) {
+	t.Helper()
+	select {
+	case msg, ok := <-raw.msgs:
+		if !ok {
+			t.Fatal("want a message, the pipe is closed")
+		}
+		if err := json.Unmarshal(msg, v); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want a message")
+	}
+}
+
+// silent tells if nothing was received for a while.
+func (raw *rawPeer) silent(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case msg, ok := <-raw.msgs:
+		if ok {
+			t.Fatalf("want nothing, got %s", msg)
+		}
+		t.Fatal("want nothing, the pipe is closed")
+	case <-time.After(d):
+	}
+}
+
+// representRaw represents an agent that's scripted by the test.
+func representRaw(t *testing.T, hello Hello) (RemoteAgent, *rawPeer) {
+	t.Helper()
+	sup, ag := net.Pipe()
+	raw := newRawPeer(t, ag)
+	go func() { _ = raw.enc.Encode(hello) }()
+	link, err := Greet(sup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := RepresentAgent(link, &fakeClient{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = rep.Close() })
+	var w welcome
+	raw.recv(t, &w)
+	return rep, raw
+}
+
+// newSession of an agent that runs fake processes.
+func newSession(t *testing.T) (*agent.Agent, *Session) {
+	t.Helper()
+	cl := &fakeClient{}
+	ag := agent.New(cl)
+	session, err := NewSession(ag, cl, Hello{Name: "agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		session.Close()
+		for id := range ag.ListAll() {
+			_ = ag.StopProgram(context.Background(), id, time.Second)
+		}
+	})
+	return ag, session
+}
+
+// superviseRaw operates a session for a supervisor that's scripted by the
+// test, and welcomes the agent with w. The agent's end of the pipe is wrapped
+// by wrap, if it's set.
+func superviseRaw(t *testing.T, session *Session, w welcome, wrap func(net.Conn) net.Conn) (*rawPeer, <-chan error) {
+	t.Helper()
+	sup, ag := net.Pipe()
+	raw := newRawPeer(t, sup)
+	if wrap != nil {
+		ag = wrap(ag)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- session.Operate(ag) }()
+	var hello Hello
+	raw.recv(t, &hello)
+	raw.send(t, w)
+	return raw, errc
+}
+
+var rawHello = Hello{Name: "raw", Session: "raw-session", Protocol: ProtocolVersion}
+
+func TestConcurrentCalls(t *testing.T) {
+	rep, raw := representRaw(t, rawHello)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		asked agent.JobID
+		got   agent.JobID
+		err   error
+	}
+	results := make(chan result, 2)
+	for _, id := range []agent.JobID{"a", "b"} {
+		go func(id agent.JobID) {
+			res, err := rep.GetJob(ctx, &GetJobReq{JobID: id})
+			if err != nil {
+				results <- result{asked: id, err: err}
+				return
+			}
+			results <- result{asked: id, got: res.Job.ID}
+		}(id)
+	}
+
+	// both calls are in flight before either is answered, and they're
+	// answered in the other order
+	type getJob struct {
+		ID      uint64    `json:"id"`
+		Request GetJobReq `json:"request"`
+	}
+	var first, second getJob
+	raw.recv(t, &first)
+	raw.recv(t, &second)
+	if first.ID == second.ID {
+		t.Fatalf("want calls in flight to have their own ID, both have %d", first.ID)
+	}
+	for _, req := range []getJob{second, first} {
+		raw.send(t, rpcServerRes{ID: req.ID, Response: GetJobRes{Job: agent.JobStatus{ID: req.Request.JobID}}})
+	}
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		if res.got != res.asked {
+			t.Errorf("want the response to job %q, got job %q", res.asked, res.got)
+		}
+	}
+}
+
+func TestCanceledCallsNeverReachAgent(t *testing.T) {
+	rep, raw := representRaw(t, rawHello)
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rep.StartProcess(canceledCtx, &StartProcessReq{ProgramName: "app"}); !errors.Is(err, ErrCanceled) {
+		t.Fatalf("want %v, got %v", ErrCanceled, err)
+	}
+	expiredCtx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := rep.StartProcess(expiredCtx, &StartProcessReq{ProgramName: "app"}); !errors.Is(err, ErrDeadlineExceeded) {
+		t.Fatalf("want %v, got %v", ErrDeadlineExceeded, err)
+	}
+	raw.silent(t, 50*time.Millisecond)
+
+	// a call canceled once it's sent is canceled on the agent too
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := rep.StartProcess(ctx, &StartProcessReq{ProgramName: "app"})
+		errc <- err
+	}()
+	var req rpcClientReq
+	raw.recv(t, &req)
+	if req.MethodName != "rpc/agent.StartProcess" || req.Timeout <= 0 || req.Timeout > 5*time.Second {
+		t.Fatalf("want the call with the time it has left, got %+v", req)
+	}
+	cancel()
+	if err := <-errc; !errors.Is(err, ErrCanceled) {
+		t.Fatalf("want %v, got %v", ErrCanceled, err)
+	}
+	var cancelation rpcClientReq
+	raw.recv(t, &cancelation)
+	if cancelation.ID != req.ID || !cancelation.Cancel {
+		t.Fatalf("want call %d canceled, got %+v", req.ID, cancelation)
+	}
+}
@@ -0,0 +1,264 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A streaming call has at most streamWindow responses in flight: the agent
+// waits for more credit before sending more.
+const streamWindow = 64
+
+type streamCall func(ctx context.Context, req interface{}, stream *serverStream) error
+
+var rpcStreamContract = make(map[string]func(op *operator) (method streamCall, req interface{}))
+
+/*
+	supervisor side
+*/
+
+// clientStream receives the responses of a streaming call, and grants the
+// agent more credit as they're consumed.
+type clientStream struct {
+	rep  *representant
+	call *pendingCall
+	ctx  context.Context
+
+	received uint64 // guarded by rep.mu
+	consumed uint64
+	done     bool
+}
+
+func (rep *representant) stream(ctx context.Context, method string, req interface{}) (*clientStream, error) {
+	call := &pendingCall{
+		req: rpcClientReq{MethodName: method, Request: req, Credit: streamWindow},
+		// room for the window and the last response
+		resc: make(chan *rpcClientRes, streamWindow+1),
+	}
+	call.deadline, _ = ctx.Deadline()
+	call.stream = &clientStream{rep: rep, call: call, ctx: ctx}
+	if err := rep.start(call); err != nil {
+		return nil, err
+	}
+	return call.stream, nil
+}
+
+// push a response received for the stream. It must be called with rep.mu
+// held. Responses resent after a reconnect, or beyond the credit the agent
+// was granted, are dropped.
+func (s *clientStream) push(rpcRes *rpcClientRes) {
+	if rpcRes.Seq <= s.received || len(s.call.resc) >= streamWindow {
+		return
+	}
+	s.received = rpcRes.Seq
+	s.call.resc <- rpcRes
+}
+
+// recv the next response of the stream into v. It returns io.EOF once the
+// stream ended without error. The stream is canceled once its context is
+// done.
+func (s *clientStream) recv(v interface{}) error {
+	if s.done {
+		return io.EOF
+	}
+	method := s.call.req.MethodName
+	var (
+		rpcRes *rpcClientRes
+		ok     bool
+	)
+	select {
+	case rpcRes, ok = <-s.call.resc:
+	case <-s.ctx.Done():
+		_ = s.close()
+		return canceled(method, s.ctx.Err())
+	}
+	if !ok {
+		s.done = true
+		return errorf(CodeUnavailable, "agent is gone: %v", s.rep.brokenErr())
+	}
+	if !rpcRes.Stream {
+		s.done = true
+		if rpcRes.Unsupported {
+			return unsupported(method)
+		}
+		if err := responseError(rpcRes); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	s.consumed++
+	if s.consumed%(streamWindow/2) == 0 {
+		s.rep.grant(s.call, s.consumed+streamWindow)
+	}
+	if err := decodePayload(rpcRes.codec, rpcRes.Response, v); err != nil {
+		return errorf(CodeInternal, "unmarshalling response: %v", err)
+	}
+	return nil
+}
+
+// close cancels the stream if it's still going.
+func (s *clientStream) close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.rep.cancel(s.call)
+	return nil
+}
+
+// grant the agent credit for a stream, up to a number of responses in total.
+func (rep *representant) grant(call *pendingCall, credit uint64) {
+	rep.sendMu.Lock()
+	defer rep.sendMu.Unlock()
+	rep.mu.Lock()
+	if _, ok := rep.pending[call.req.ID]; !ok {
+		rep.mu.Unlock()
+		return
+	}
+	// the credit is resent with the call if the agent resumes
+	call.req.Credit = credit
+	link := rep.link
+	rep.mu.Unlock()
+	if link == nil {
+		return
+	}
+	if err := link.sendMsg(rpcClientReq{ID: call.req.ID, Credit: credit}); err != nil {
+		rep.detach(link, fmt.Errorf("sending stream credit: %v", err))
+	}
+}
+
+// cancel a call. It's canceled once the agent resumes if it's away.
+func (rep *representant) cancel(call *pendingCall) {
+	rep.sendMu.Lock()
+	defer rep.sendMu.Unlock()
+	rep.mu.Lock()
+	if _, ok := rep.pending[call.req.ID]; !ok {
+		rep.mu.Unlock()
+		return
+	}
+	delete(rep.pending, call.req.ID)
+	link := rep.link
+	if link == nil {
+		rep.canceled = append(rep.canceled, call.req.ID)
+	}
+	rep.mu.Unlock()
+	if link == nil {
+		return
+	}
+	if err := link.sendMsg(rpcClientReq{ID: call.req.ID, Cancel: true}); err != nil {
+		rep.detach(link, fmt.Errorf("sending cancelation: %v", err))
+	}
+}
+
+/*
+	agent side
+*/
+
+// serverStream sends the responses of a streaming call, as long as the
+// supervisor granted it credit.
+type serverStream struct {
+	op    *operator
+	id    uint64
+	epoch uint64
+
+	credit chan struct{} // signaled when credit is granted
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// guarded by op.mu
+	allowed uint64
+	sent    uint64
+}
+
+func (op *operator) openStream(epoch, id, credit uint64, timeout time.Duration) *serverStream {
+	stream := &serverStream{
+		op:      op,
+		id:      id,
+		epoch:   epoch,
+		credit:  make(chan struct{}, 1),
+		allowed: credit,
+	}
+	if timeout > 0 {
+		stream.ctx, stream.cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		stream.ctx, stream.cancel = context.WithCancel(context.Background())
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if epoch != op.epoch {
+		stream.cancel()
+		return stream
+	}
+	op.streams[id] = stream
+	return stream
+}
+
+func (op *operator) invokeStream(stream *serverStream, method streamCall, req interface{}, rpcRes *rpcServerRes) error {
+	err := method(stream.ctx, req, stream)
+	stream.cancel()
+	op.mu.Lock()
+	if op.streams[stream.id] == stream {
+		delete(op.streams, stream.id)
+	}
+	op.mu.Unlock()
+	if err != nil {
+		rpcRes.Code, rpcRes.Err = describe(err)
+	}
+	if err := op.reply(stream.epoch, rpcRes); err != nil {
+		return fmt.Errorf("sending end of stream: %v", err)
+	}
+	return nil
+}
+
+// grant a stream credit, up to a number of responses in total.
+func (op *operator) grant(id, credit uint64) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	stream, ok := op.streams[id]
+	if !ok || credit <= stream.allowed {
+		return
+	}
+	stream.allowed = credit
+	select {
+	case stream.credit <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// cancel a call or a stream.
+func (op *operator) cancel(id uint64) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if stream, ok := op.streams[id]; ok {
+		stream.cancel()
+	}
+	if cancel, ok := op.calls[id]; ok {
+		cancel()
+	}
+}
+
+// send a response on the stream, waiting for credit if there's none left.
+// A response that can't be sent right away is sent once the session resumes.
+func (stream *serverStream) send(res interface{}) error {
+	op := stream.op
+	for {
+		op.mu.Lock()
+		if stream.sent < stream.allowed {
+			stream.sent++
+			seq := stream.sent
+			op.mu.Unlock()
+			if err := op.send(&rpcServerRes{ID: stream.id, Response: res, Stream: true, Seq: seq}); err != nil {
+				op.handleError(fmt.Errorf("sending stream response: %v", err))
+			}
+			return nil
+		}
+		op.mu.Unlock()
+		select {
+		case <-stream.credit:
+		case <-stream.ctx.Done():
+			return stream.ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+func TestStreamCredit(t *testing.T) {
+	ag, session := newSession(t)
+	raw, _ := superviseRaw(t, session, welcome{Protocol: ProtocolVersion}, nil)
+	id := (&fakeClient{}).ProgramID("app")
+	if _, err := ag.StartProcess(context.Background(), id, container.Spec{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every restart of the program is an event
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(2 * time.Millisecond):
+			}
+			if err := ag.RestartProgram(context.Background(), agent.PolicyRolling(), id); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	type streamRes struct {
+		ID     uint64 `json:"id"`
+		Stream bool   `json:"stream"`
+		Seq    uint64 `json:"seq"`
+		Code   Code   `json:"code"`
+	}
+	expect := func(seqs ...uint64) {
+		t.Helper()
+		for _, seq := range seqs {
+			var res streamRes
+			raw.recv(t, &res)
+			if res.ID != 1 || !res.Stream || res.Seq != seq {
+				t.Fatalf("want response %d of the stream, got %+v", seq, res)
+			}
+		}
+	}
+
+	raw.send(t, rpcClientReq{
+		ID:         1,
+		MethodName: methodWatchEvents,
+		Request:    WatchEventsReq{Kinds: []agent.EventKind{agent.EventDeployProgress}},
+		Credit:     2,
+	})
+	expect(1, 2)
+	// out of credit, the stream stalls
+	raw.silent(t, 100*time.Millisecond)
+
+	raw.send(t, rpcClientReq{ID: 1, Credit: 4})
+	expect(3, 4)
+	raw.silent(t, 100*time.Millisecond)
+
+	raw.send(t, rpcClientReq{ID: 1, Cancel: true})
+	var last streamRes
+	raw.recv(t, &last)
+	if last.ID != 1 || last.Stream || last.Code != CodeCanceled {
+		t.Fatalf("want the stream to end canceled, got %+v", last)
+	}
+}
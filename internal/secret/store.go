@@ -0,0 +1,193 @@
+// Package secret keeps secrets in a file encrypted with a master key.
+package secret
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// magic prefixes every secret store file, so we don't try to decrypt
+// something that isn't one.
+var magic = []byte("deployotron.secrets.v1\n")
+
+// A Store holds named secrets, persisted encrypted on disk.
+type Store struct {
+	path string
+	aead cipher.AEAD
+
+	mu      sync.RWMutex
+	secrets map[string][]byte
+}
+
+// Open the secret store at path, decrypting it with the master key. If no
+// file exists at path, the store starts empty and the file is created on
+// the first write.
+func Open(path string, masterKey []byte) (*Store, error) {
+	if len(masterKey) == 0 {
+		return nil, fmt.Errorf("master key is empty")
+	}
+	// the master key is expected to be random, not a passphrase
+	key := sha256.Sum256(masterKey)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %v", err)
+	}
+	store := &Store{path: path, aead: aead, secrets: make(map[string][]byte)}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return store, nil
+	case err != nil:
+		return nil, fmt.Errorf("reading secret store: %v", err)
+	}
+	if err := store.decrypt(data); err != nil {
+		return nil, fmt.Errorf("decrypting secret store %q: %v", path, err)
+	}
+	return store, nil
+}
+
+// ReadMasterKey reads a master key from a file, ignoring surrounding
+// whitespace.
+func ReadMasterKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading master key: %v", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("master key file %q is empty", path)
+	}
+	return key, nil
+}
+
+// Get returns the value of a secret.
+func (store *Store) Get(name string) ([]byte, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	v, ok := store.secrets[name]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), v...), true
+}
+
+// Names returns the name of every secret, sorted.
+func (store *Store) Names() []string {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	names := make([]string, 0, len(store.secrets))
+	for name := range store.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Put sets the value of a secret and persists the store.
+func (store *Store) Put(name string, value []byte) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.secrets[name] = append([]byte(nil), value...)
+	return store.save()
+}
+
+// Remove deletes a secret and persists the store.
+func (store *Store) Remove(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.secrets[name]; !ok {
+		return fmt.Errorf("no such secret: %q", name)
+	}
+	delete(store.secrets, name)
+	return store.save()
+}
+
+// ValidName checks that a secret name can safely be used as an environment
+// variable name or a file name.
+func ValidName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("secret name is empty")
+	case strings.ContainsAny(name, "/\\=\x00") || name == "." || name == "..":
+		return fmt.Errorf("invalid secret name: %q", name)
+	}
+	return nil
+}
+
+func (store *Store) save() error {
+	data, err := store.encrypt()
+	if err != nil {
+		return fmt.Errorf("encrypting secret store: %v", err)
+	}
+	// write to a temp file first so a crash can't leave a corrupt store
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("creating temp secret store: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("restricting temp secret store permissions: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing temp secret store: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp secret store: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), store.path); err != nil {
+		return fmt.Errorf("replacing secret store: %v", err)
+	}
+	return nil
+}
+
+func (store *Store) encrypt() ([]byte, error) {
+	plaintext, err := json.Marshal(store.secrets)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, store.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %v", err)
+	}
+	out := append([]byte(nil), magic...)
+	out = append(out, nonce...)
+	return store.aead.Seal(out, nonce, plaintext, magic), nil
+}
+
+func (store *Store) decrypt(data []byte) error {
+	if !bytes.HasPrefix(data, magic) {
+		return fmt.Errorf("not a secret store")
+	}
+	data = data[len(magic):]
+	if len(data) < store.aead.NonceSize() {
+		return fmt.Errorf("truncated secret store")
+	}
+	nonce, ciphertext := data[:store.aead.NonceSize()], data[store.aead.NonceSize():]
+	plaintext, err := store.aead.Open(nil, nonce, ciphertext, magic)
+	if err != nil {
+		return fmt.Errorf("wrong master key or corrupt store")
+	}
+	return json.Unmarshal(plaintext, &store.secrets)
+}
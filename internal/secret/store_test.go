@@ -0,0 +1,152 @@
+package secret
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	key := []byte("master key")
+
+	store, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := store.Names(); len(names) != 0 {
+		t.Fatalf("want an empty store, got %v", names)
+	}
+	if err := store.Put("db", []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("api", []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove("api"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("tls", []byte("---key---")); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"db", "tls"}, reopened.Names(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if v, ok := reopened.Get("db"); !ok || string(v) != "hunter2" {
+		t.Errorf("want db to be hunter2, got %q, %v", v, ok)
+	}
+	if _, ok := reopened.Get("api"); ok {
+		t.Errorf("want api removed")
+	}
+}
+
+func TestStoreIsEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	store, err := Open(path, []byte("master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("db", []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Error("store holds secrets in clear")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("want mode 0600, got %v", mode)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets")
+	store, err := Open(path, []byte("master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("db", []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		key  string
+	}{
+		{"wrong key", data, "other key"},
+		{"corrupt", corrupt, "master key"},
+		{"truncated", data[:len(magic)+2], "master key"},
+		{"not a store", []byte(`{"db":"hunter2"}`), "master key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := ioutil.WriteFile(path, tt.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(path, []byte(tt.key)); err == nil {
+				t.Fatal("want an error")
+			}
+		})
+	}
+	if _, err := Open(path, nil); err == nil {
+		t.Error("want an error for an empty key")
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"db", "db.password", "DB_PASSWORD", "tls-key"} {
+		if err := ValidName(name); err != nil {
+			t.Errorf("%q: want valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`, "a=b", "a\x00b"} {
+		if err := ValidName(name); err == nil {
+			t.Errorf("%q: want invalid", name)
+		}
+	}
+}
+
+func TestReadMasterKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(path, []byte("  s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ReadMasterKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "s3cret" {
+		t.Errorf("want s3cret, got %q", key)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMasterKey(empty); err == nil {
+		t.Error("want an error for an empty key file")
+	}
+}
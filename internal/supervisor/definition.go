@@ -0,0 +1,204 @@
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	"github.com/aybabtme/deployotron/internal/container"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadDefinition reads a definition from a YAML file, or a JSON one since
+// JSON is YAML too. A definition that isn't valid fails with an error that
+// tells where each problem is in the file.
+func LoadDefinition(path string) (Definition, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Definition{}, fmt.Errorf("reading definition: %v", err)
+	}
+	return ParseDefinition(path, src)
+}
+
+// ParseDefinition parses a definition read from filename. Every agent must
+// be listed once in agents, and can have at most one stack in machines. A
+// stack lists each of its programs once, whatever their version.
+func ParseDefinition(filename string, src []byte) (Definition, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return Definition{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(doc.Content) == 0 {
+		return Definition{}, fmt.Errorf("%s: definition is empty", filename)
+	}
+	var dfn Definition
+	dec := yaml.NewDecoder(bytes.NewReader(src))
+	dec.KnownFields(true)
+	if err := dec.Decode(&dfn); err != nil {
+		return Definition{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	v := validator{filename: filename}
+	v.definition(doc.Content[0])
+	if err := v.err(); err != nil {
+		return Definition{}, err
+	}
+	return dfn, nil
+}
+
+// DefinitionError lists the problems of a definition, one per line.
+type DefinitionError struct {
+	Problems []string
+}
+
+func (err *DefinitionError) Error() string {
+	return "invalid definition:\n  " + strings.Join(err.Problems, "\n  ")
+}
+
+// validator walks the nodes of a definition, which know where they are in
+// the file, and notes its problems.
+type validator struct {
+	filename string
+	problems []string
+}
+
+func (v *validator) errorf(n *yaml.Node, format string, args ...interface{}) {
+	at := fmt.Sprintf("%s:%d:%d: ", v.filename, n.Line, n.Column)
+	v.problems = append(v.problems, at+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &DefinitionError{Problems: v.problems}
+}
+
+func (v *validator) definition(n *yaml.Node) {
+	agents := make(map[string]*yaml.Node)
+	if list := field(n, "agents"); list == nil {
+		v.errorf(n, "no agents are listed")
+	} else {
+		for _, name := range list.Content {
+			if name.Value == "" {
+				v.errorf(name, "agent has no name")
+			} else if first, ok := agents[name.Value]; ok {
+				v.errorf(name, "agent %q is listed twice, first at %d:%d", name.Value, first.Line, first.Column)
+			} else {
+				agents[name.Value] = name
+			}
+		}
+	}
+	machines := field(n, "machines")
+	if machines == nil {
+		return
+	}
+	for i := 0; i+1 < len(machines.Content); i += 2 {
+		name, stack := machines.Content[i], machines.Content[i+1]
+		if _, ok := agents[name.Value]; !ok {
+			v.errorf(name, "unknown agent %q, it isn't listed in agents", name.Value)
+		}
+		v.stack(name.Value, stack)
+	}
+}
+
+func (v *validator) stack(agent string, n *yaml.Node) {
+	list := field(n, "programs")
+	if list == nil {
+		return
+	}
+	programs := make(map[string]*yaml.Node)
+	for _, entry := range list.Content {
+		prgm := field(entry, "program")
+		if prgm == nil {
+			v.errorf(entry, "program isn't named")
+			continue
+		}
+		if err := validateProgram(prgm.Value); err != nil {
+			v.errorf(prgm, "program %q: %v", prgm.Value, err)
+		} else if first, ok := programs[prgm.Value]; ok {
+			v.errorf(prgm, "program %q is listed twice for agent %q, first at %d:%d", prgm.Value, agent, first.Line, first.Column)
+		} else {
+			programs[prgm.Value] = prgm
+		}
+		v.programDef(entry)
+	}
+}
+
+func (v *validator) programDef(n *yaml.Node) {
+	var def programDef
+	if err := n.Decode(&def); err != nil {
+		return // the definition as a whole failed to decode already
+	}
+	if version := field(n, "version"); version != nil {
+		if err := validateVersion(def.Version); err != nil {
+			v.errorf(version, "version %q: %v", def.Version, err)
+		}
+	}
+	if instances := field(n, "instances"); instances != nil && def.Instances < 1 {
+		v.errorf(instances, "a program runs at least 1 instance, not %d", def.Instances)
+	}
+	if spec := field(n, "spec"); spec != nil {
+		if ka := field(spec, "keep_alive"); ka != nil {
+			if err := def.Spec.KeepAlive.Validate(); err != nil {
+				v.errorf(ka, "%v", err)
+			}
+		}
+		if limits := field(spec, "limits"); limits != nil {
+			if err := def.Spec.Limits.Validate(); err != nil {
+				v.errorf(limits, "%v", err)
+			}
+		}
+		if sig := field(spec, "reload_signal"); sig != nil {
+			if _, err := container.ParseSignal(def.Spec.ReloadSignal); err != nil {
+				v.errorf(sig, "%v", err)
+			}
+		}
+	}
+	if rollout := field(n, "rollout"); rollout != nil {
+		if _, err := def.Rollout.Policy(); err != nil {
+			v.errorf(rollout, "%v", err)
+		}
+	}
+}
+
+// validateProgram tells if a program name can be turned into a program ID
+// by every backend: it's a command and its arguments, separated by spaces.
+func validateProgram(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("name is empty")
+	case strings.TrimSpace(name) != name:
+		return fmt.Errorf("name starts or ends with spaces")
+	case strings.Contains(name, "  "):
+		return fmt.Errorf("name has an empty argument")
+	case strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0:
+		return fmt.Errorf("name has characters that can't be printed")
+	}
+	return nil
+}
+
+// validateVersion tells if a version can tag a program.
+func validateVersion(version string) error {
+	switch {
+	case version == "":
+		return fmt.Errorf("version is empty")
+	case strings.IndexFunc(version, func(r rune) bool { return r == ':' || !unicode.IsPrint(r) || unicode.IsSpace(r) }) >= 0:
+		return fmt.Errorf("version has spaces, colons or characters that can't be printed")
+	}
+	return nil
+}
+
+// field returns the value of a key of a mapping node, if it has it.
+func field(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
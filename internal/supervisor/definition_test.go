@@ -0,0 +1,253 @@
+package supervisor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+)
+
+func TestLoadDefinition(t *testing.T) {
+	// the example that ships with the repo
+	dfn, err := LoadDefinition("../../definition.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dfn.Agents) != 2 || dfn.Agents[0] != "agent-1" || dfn.Agents[1] != "agent-2" {
+		t.Fatalf("want agent-1 and agent-2, got %v", dfn.Agents)
+	}
+	programs := dfn.Machines["agent-1"].Programs
+	if len(programs) != 2 {
+		t.Fatalf("want 2 programs, got %+v", programs)
+	}
+	def := programs[0]
+	if def.Program != "echoer program.1" || def.instances() != 2 || def.Spec.Env["GREETING"] != "hello" || def.Spec.Ports["http"] != 8080 {
+		t.Errorf("want the program's instances and spec, got %+v", def)
+	}
+	if ka := def.Spec.KeepAlive; ka.Restart != container.RestartOnFailure || ka.MaxBackoff != 30*time.Second {
+		t.Errorf("want the program's keep alive, got %+v", ka)
+	}
+	if def.Rollout.StopTimeout != 10*time.Second {
+		t.Errorf("want the program's rollout, got %+v", def.Rollout)
+	}
+	if programs[1].instances() != 1 {
+		t.Errorf("want 1 instance if not set, got %d", programs[1].instances())
+	}
+
+	if _, err := LoadDefinition("nope.yaml"); err == nil {
+		t.Error("want an error reading a file that doesn't exist")
+	}
+}
+
+func TestParseDefinitionJSON(t *testing.T) {
+	src := `{"agents": ["a"], "machines": {"a": {"programs": [{"program": "app", "version": "v2", "instances": 3}]}}}`
+	dfn, err := ParseDefinition("dfn.json", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := dfn.Machines["a"].Programs[0]
+	if def.Program != "app" || def.Version != "v2" || def.Instances != 3 {
+		t.Fatalf("want the program, got %+v", def)
+	}
+}
+
+func TestParseDefinitionRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		problems []string // where each problem is, and what it is
+	}{
+		{
+			name:     "empty",
+			src:      "",
+			problems: []string{"dfn.yaml: definition is empty"},
+		},
+		{
+			name:     "not yaml",
+			src:      "agents: [a",
+			problems: []string{"dfn.yaml: yaml: line 1"},
+		},
+		{
+			name:     "unknown field",
+			src:      "agents: [a]\nmachine: {}\n",
+			problems: []string{"dfn.yaml: yaml: unmarshal errors:\n  line 2: field machine not found"},
+		},
+		{
+			name:     "no agents",
+			src:      "machines: {}\n",
+			problems: []string{"dfn.yaml:1:1: no agents are listed"},
+		},
+		{
+			name:     "agent listed twice",
+			src:      "agents:\n  - a\n  - b\n  - a\n",
+			problems: []string{`dfn.yaml:4:5: agent "a" is listed twice, first at 2:5`},
+		},
+		{
+			name:     "agent without a name",
+			src:      "agents:\n  - a\n  - \"\"\n",
+			problems: []string{"dfn.yaml:3:5: agent has no name"},
+		},
+		{
+			name: "unknown agent",
+			src: `agents: [a]
+machines:
+  b:
+    programs:
+      - program: app
+`,
+			problems: []string{`dfn.yaml:3:3: unknown agent "b", it isn't listed in agents`},
+		},
+		{
+			name: "program without a name",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - instances: 2
+`,
+			problems: []string{"dfn.yaml:5:9: program isn't named"},
+		},
+		{
+			name: "bad program names",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: ""
+      - program: " app"
+      - program: "app  --flag"
+`,
+			problems: []string{
+				`dfn.yaml:5:18: program "": name is empty`,
+				`dfn.yaml:6:18: program " app": name starts or ends with spaces`,
+				`dfn.yaml:7:18: program "app  --flag": name has an empty argument`,
+			},
+		},
+		{
+			name: "program listed twice",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: app
+        version: v1
+      - program: db
+      - program: app
+        version: v2
+`,
+			problems: []string{`dfn.yaml:8:18: program "app" is listed twice for agent "a", first at 5:18`},
+		},
+		{
+			name: "bad version",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: app
+        version: "v:1"
+`,
+			problems: []string{`dfn.yaml:6:18: version "v:1": version has spaces, colons or characters that can't be printed`},
+		},
+		{
+			name: "no instances",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: app
+        instances: 0
+`,
+			problems: []string{"dfn.yaml:6:20: a program runs at least 1 instance, not 0"},
+		},
+		{
+			name: "keep alive outside the spec",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: app
+        keep_alive:
+          restart: always
+`,
+			problems: []string{"dfn.yaml: yaml: unmarshal errors:\n  line 6: field keep_alive not found"},
+		},
+		{
+			name: "bad reload signal",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: app
+        spec:
+          reload_signal: STOP
+`,
+			problems: []string{"dfn.yaml:7:26: signal stopped (signal) freezes or thaws processes"},
+		},
+		{
+			name: "bad keep alive",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: app
+        spec:
+          keep_alive:
+            restart: sometimes
+`,
+			problems: []string{`dfn.yaml:8:13: unknown restart mode "sometimes"`},
+		},
+		{
+			name: "bad rollout",
+			src: `agents: [a]
+machines:
+  a:
+    programs:
+      - program: app
+        rollout:
+          strategy: yolo
+`,
+			problems: []string{`dfn.yaml:7:11: unknown restart strategy "yolo"`},
+		},
+		{
+			name: "every problem",
+			src: `agents: [a, a]
+machines:
+  b:
+    programs:
+      - program: app
+        instances: -1
+`,
+			problems: []string{
+				`dfn.yaml:1:13: agent "a" is listed twice, first at 1:10`,
+				`dfn.yaml:3:3: unknown agent "b", it isn't listed in agents`,
+				"dfn.yaml:6:20: a program runs at least 1 instance, not -1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDefinition("dfn.yaml", []byte(tt.src))
+			if err == nil {
+				t.Fatal("want an error")
+			}
+			var dfnErr *DefinitionError
+			if !errors.As(err, &dfnErr) {
+				// the file as a whole is wrong
+				if len(tt.problems) != 1 || !strings.HasPrefix(err.Error(), tt.problems[0]) {
+					t.Fatalf("want %q, got %q", tt.problems, err)
+				}
+				return
+			}
+			if len(dfnErr.Problems) != len(tt.problems) {
+				t.Fatalf("want problems %q, got %q", tt.problems, dfnErr.Problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.HasPrefix(dfnErr.Problems[i], want) {
+					t.Errorf("want problem %q, got %q", want, dfnErr.Problems[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	agentpkg "github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/backoff"
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/deployotron/internal/rpc"
+)
+
+const (
+	// how often an agent is compared to its stack, when nothing changed
+	reconcileInterval = 30 * time.Second
+	// how long an agent has to carry out a call
+	callTimeout = time.Minute
+	// how long processes have to stop before they're killed, unless their
+	// rollout says otherwise
+	stopTimeout = agentpkg.DefaultStopTimeout
+	// how many times a process is started before giving up until next time
+	startAttempts = 3
+)
+
+// enforceState makes the agent compare itself to its stack right away,
+// rather than at its next pass.
+func (ag *agent) enforceState() {
+	select {
+	case ag.wake <- struct{}{}:
+	default: // a pass is coming already
+	}
+}
+
+// maintainStateForever brings the agent in line with its stack, and keeps
+// it there until the agent is gone. An agent that keeps failing to converge
+// is left alone for longer and longer.
+func (ag *agent) maintainStateForever(stackOf func(agentName) (stack, bool)) {
+	retry := backoff.Backoff{Min: time.Second, Max: 5 * time.Minute}
+	var wait time.Duration
+	for {
+		select {
+		case <-ag.client.Done():
+			return
+		case <-ag.wake:
+		case <-time.After(wait):
+		}
+
+		desired, ok := stackOf(ag.name)
+		if !ok {
+			wait = reconcileInterval
+			continue
+		}
+		if err := ag.reconcile(desired); err != nil {
+			wait = retry.Next()
+			ag.ll.Err(err).KV("retry_in", wait).Error("agent doesn't run its stack")
+			continue
+		}
+		retry.Reset()
+		wait = reconcileInterval
+	}
+}
+
+// reconcile carries out one pass: what the agent runs is compared to its
+// stack, and the calls needed to converge are made.
+func (ag *agent) reconcile(desired stack) error {
+	current, err := ag.gatherCurrentState()
+	if err != nil {
+		return fmt.Errorf("gathering current state: %v", err)
+	}
+	for id := range ag.applied {
+		if _, ok := current[id]; !ok {
+			delete(ag.applied, id)
+		}
+	}
+	targets := make([]target, 0, len(desired.Programs))
+	for _, def := range desired.Programs {
+		t := targetOf(ag.provider, def)
+		if _, ok := ag.applied[t.id]; !ok && len(current[t.id]) > 0 {
+			// it ran before we knew the agent, trust it runs this spec
+			ag.applied[t.id] = t.spec
+		}
+		targets = append(targets, t)
+	}
+
+	var failed int
+	for _, act := range plan(targets, current, ag.applied) {
+		ll := ag.ll.KV("action", act.kind).KV("program.id", act.id)
+		if act.from != "" && act.from != act.id {
+			ll = ll.KV("from.program.id", act.from)
+		}
+		if act.process != "" {
+			ll = ll.KV("process.id", act.process)
+		}
+		if err := ag.carryOut(act); err != nil {
+			ll.Err(err).Error("failed to converge")
+			failed++
+			continue
+		}
+		ll.Info("converged")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d calls failed", failed)
+	}
+	return nil
+}
+
+// gatherCurrentState returns the processes the agent runs, by program.
+func (ag *agent) gatherCurrentState() (map[container.ProgramID][]container.ProcessID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	res, err := ag.client.ListAll(ctx, &rpc.ListAllReq{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Running, nil
+}
+
+// A target is a program of a stack, as its agent knows it.
+type target struct {
+	id        container.ProgramID // of the version to run
+	base      container.ProgramID // of the program, whatever its version
+	program   string
+	name      string // of the version to run
+	instances int
+	spec      container.Spec
+	rollout   agentpkg.PolicySpec
+}
+
+func targetOf(provider container.ProgramProvider, def programDef) target {
+	name := def.name(provider)
+	return target{
+		id:        provider.ProgramID(name),
+		base:      provider.ProgramID(string(def.Program)),
+		program:   string(def.Program),
+		name:      name,
+		instances: def.instances(),
+		spec:      def.Spec,
+		rollout:   def.Rollout,
+	}
+}
+
+// owns tells if a program is a version of the target's program.
+func (t target) owns(id container.ProgramID) bool {
+	return id == t.id || id == t.base || strings.HasPrefix(string(id), string(t.base)+":")
+}
+
+// nameOf a version of the target's program. Programs are named after their
+// ID, which is their name under a prefix of their backend.
+func (t target) nameOf(id container.ProgramID) string {
+	return t.program + strings.TrimPrefix(string(id), string(t.base))
+}
+
+type actionKind string
+
+const (
+	actionStart       actionKind = "start"
+	actionStop        actionKind = "stop"
+	actionUpgrade     actionKind = "upgrade"
+	actionReconfigure actionKind = "reconfigure"
+)
+
+// An action brings an agent closer to its stack. A start starts a process
+// of the program, a stop stops the process, an upgrade turns the processes
+// of another version into processes of the program, and a reconfigure
+// restarts the processes of the program with its spec.
+type action struct {
+	kind     actionKind
+	id       container.ProgramID
+	name     string              // of the program, if it's targeted
+	from     container.ProgramID // of an upgrade or a reconfigure
+	fromName string
+	process  container.ProcessID // of a stop
+	spec     container.Spec
+	rollout  agentpkg.PolicySpec
+}
+
+// plan the actions that turn the processes an agent runs into the targets.
+// Processes in surplus are stopped, those of other versions first to spare
+// them an upgrade. The processes of other versions left are upgraded, and
+// those that run another spec than the one wanted are reconfigured. The
+// processes of programs that aren't targeted are stopped.
+func plan(targets []target, current map[container.ProgramID][]container.ProcessID, applied map[container.ProgramID]container.Spec) []action {
+	var stops, upgrades, starts []action
+	owned := make(map[container.ProgramID]bool)
+	for _, t := range targets {
+		owned[t.id] = true
+		var others []container.ProgramID
+		count := len(current[t.id])
+		for _, id := range sortedPrograms(current) {
+			if id != t.id && t.owns(id) {
+				owned[id] = true
+				others = append(others, id)
+				count += len(current[id])
+			}
+		}
+		surplus := count - t.instances
+		stop := func(id container.ProgramID, proc container.ProcessID) {
+			stops = append(stops, action{kind: actionStop, id: id, process: proc, rollout: t.rollout})
+			surplus--
+		}
+
+		for _, id := range others {
+			procs := sortedProcesses(current[id])
+			for len(procs) > 0 && surplus > 0 {
+				stop(id, procs[0])
+				procs = procs[1:]
+			}
+			if len(procs) > 0 {
+				upgrades = append(upgrades, action{kind: actionUpgrade, id: t.id, name: t.name, from: id, fromName: t.nameOf(id), spec: t.spec, rollout: t.rollout})
+			}
+		}
+		procs := sortedProcesses(current[t.id])
+		for len(procs) > 0 && surplus > 0 {
+			stop(t.id, procs[len(procs)-1])
+			procs = procs[:len(procs)-1]
+		}
+		if spec, ok := applied[t.id]; ok && len(procs) > 0 && !reflect.DeepEqual(spec, t.spec) {
+			upgrades = append(upgrades, action{kind: actionReconfigure, id: t.id, name: t.name, from: t.id, fromName: t.name, spec: t.spec, rollout: t.rollout})
+		}
+		for ; surplus < 0; surplus++ {
+			starts = append(starts, action{kind: actionStart, id: t.id, name: t.name, spec: t.spec})
+		}
+	}
+	for _, id := range sortedPrograms(current) {
+		if owned[id] {
+			continue
+		}
+		for _, proc := range sortedProcesses(current[id]) {
+			stops = append(stops, action{kind: actionStop, id: id, process: proc})
+		}
+	}
+
+	actions := append(stops, upgrades...)
+	return append(actions, starts...)
+}
+
+func sortedPrograms(current map[container.ProgramID][]container.ProcessID) []container.ProgramID {
+	ids := make([]container.ProgramID, 0, len(current))
+	for id := range current {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func sortedProcesses(procs []container.ProcessID) []container.ProcessID {
+	sorted := append([]container.ProcessID(nil), procs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// carryOut an action on the agent, and remember the spec the processes of
+// its program run once it's done.
+func (ag *agent) carryOut(act action) error {
+	switch act.kind {
+	case actionStart:
+		if err := ag.startProcess(act.name, act.spec); err != nil {
+			return err
+		}
+	case actionStop:
+		timeout := act.rollout.StopTimeout
+		if timeout == 0 {
+			timeout = stopTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		defer cancel()
+		_, err := ag.client.StopProcess(ctx, &rpc.StopProcessReq{ProcessID: act.process, Timeout: timeout})
+		return err
+	case actionUpgrade, actionReconfigure:
+		if err := ag.upgradeProgram(act); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown action %q", act.kind)
+	}
+	ag.applied[act.id] = act.spec
+	return nil
+}
+
+// startProcess starts a process of a program. Starts that may or may not
+// have happened are retried with the same idempotency key, so that the
+// agent doesn't end up with an extra process. Agents that don't remember
+// keys aren't retried until the next pass, which sees what they run.
+func (ag *agent) startProcess(name string, spec container.Spec) error {
+	base, attempts := context.Background(), 1
+	if ag.client.Supports(rpc.FeatureIdempotency) {
+		base, attempts = rpc.WithIdempotencyKey(base, rpc.NewIdempotencyKey()), startAttempts
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(base, callTimeout)
+		_, err = ag.client.StartProcess(ctx, &rpc.StartProcessReq{ProgramName: name, Spec: spec})
+		cancel()
+		if !errors.Is(err, rpc.ErrUnavailable) && !errors.Is(err, rpc.ErrDeadlineExceeded) {
+			return err
+		}
+		ag.ll.Err(err).KV("attempt", attempt).KV("program.name", name).Info("start may have failed")
+	}
+	return err
+}
+
+// upgradeProgram rolls the processes of a program out to the program of an
+// action, with its spec. Agents that can't change the spec of processes
+// only upgrade them, and new specs apply to the processes they start next.
+func (ag *agent) upgradeProgram(act action) error {
+	req := &rpc.UpgradeProgramReq{
+		FromProgramName: act.fromName,
+		ToProgramName:   act.name,
+		Policy:          act.rollout,
+	}
+	if ag.client.Supports(rpc.FeatureReconfigure) {
+		req.Spec = &act.spec
+	} else if act.kind == actionReconfigure {
+		ag.ll.KV("program.id", act.id).Info("agent can't change the spec of running processes")
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	_, err := ag.client.UpgradeProgram(ctx, req)
+	return err
+}
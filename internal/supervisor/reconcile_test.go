@@ -0,0 +1,204 @@
+package supervisor
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/deployotron/internal/container/osprocess"
+)
+
+// describe an action the way tests expect it.
+func (act action) String() string {
+	switch act.kind {
+	case actionStop:
+		return fmt.Sprintf("stop %s %s", act.id, act.process)
+	case actionUpgrade, actionReconfigure:
+		return fmt.Sprintf("%s %s to %s", act.kind, act.fromName, act.name)
+	}
+	return fmt.Sprintf("%s %s", act.kind, act.name)
+}
+
+func TestPlan(t *testing.T) {
+	cl := &fakeClient{}
+	id := func(name string) container.ProgramID { return cl.ProgramID(name) }
+	def := func(prgm, version string, instances int) programDef {
+		return programDef{Program: program(prgm), Version: version, Instances: instances}
+	}
+	v1 := container.Spec{Env: map[string]string{"V": "1"}}
+	v2 := container.Spec{Env: map[string]string{"V": "2"}}
+	withSpec := func(def programDef, spec container.Spec) programDef {
+		def.Spec = spec
+		return def
+	}
+	type current map[container.ProgramID][]container.ProcessID
+	type applied map[container.ProgramID]container.Spec
+
+	tests := []struct {
+		name    string
+		defs    []programDef
+		current current
+		applied applied
+		want    []string
+	}{
+		{
+			name: "nothing runs",
+			defs: []programDef{def("app", "", 2)},
+			want: []string{"start app", "start app"},
+		},
+		{
+			name:    "converged",
+			defs:    []programDef{def("app", "", 2)},
+			current: current{id("app"): {"p1", "p2"}},
+			applied: applied{id("app"): {}},
+		},
+		{
+			name:    "one instance if not set",
+			defs:    []programDef{def("app", "", 0)},
+			current: current{id("app"): {"p1", "p2"}},
+			want:    []string{"stop fake.program.app p2"},
+		},
+		{
+			name:    "missing instances",
+			defs:    []programDef{def("app", "", 3)},
+			current: current{id("app"): {"p1"}},
+			want:    []string{"start app", "start app"},
+		},
+		{
+			name:    "programs that aren't targeted",
+			current: current{id("db"): {"d2", "d1"}},
+			want:    []string{"stop fake.program.db d1", "stop fake.program.db d2"},
+		},
+		{
+			name:    "upgrade",
+			defs:    []programDef{def("app", "v2", 2)},
+			current: current{id("app:v1"): {"p1", "p2"}},
+			want:    []string{"upgrade app:v1 to app:v2"},
+		},
+		{
+			name:    "upgrade from no version",
+			defs:    []programDef{def("app", "v2", 1)},
+			current: current{id("app"): {"p1"}},
+			want:    []string{"upgrade app to app:v2"},
+		},
+		{
+			name:    "surplus of other versions stopped first",
+			defs:    []programDef{def("app", "v2", 2)},
+			current: current{id("app:v1"): {"p1"}, id("app:v2"): {"p2", "p3"}},
+			want:    []string{"stop fake.program.app:v1 p1"},
+		},
+		{
+			name:    "partly upgraded",
+			defs:    []programDef{def("app", "v2", 3)},
+			current: current{id("app:v1"): {"p1"}, id("app:v2"): {"p2"}},
+			want:    []string{"upgrade app:v1 to app:v2", "start app:v2"},
+		},
+		{
+			name:    "reconfigure",
+			defs:    []programDef{withSpec(def("app", "", 1), v2)},
+			current: current{id("app"): {"p1"}},
+			applied: applied{id("app"): v1},
+			want:    []string{"reconfigure app to app"},
+		},
+		{
+			name:    "spec isn't known",
+			defs:    []programDef{withSpec(def("app", "", 1), v2)},
+			current: current{id("app"): {"p1"}},
+		},
+		{
+			name:    "nothing to reconfigure",
+			defs:    []programDef{withSpec(def("app", "", 1), v2)},
+			applied: applied{id("app"): v1},
+			want:    []string{"start app"},
+		},
+		{
+			name:    "keep alive is part of the spec",
+			defs:    []programDef{withSpec(def("app", "", 1), container.Spec{KeepAlive: container.KeepAlive{Restart: container.RestartAlways}})},
+			current: current{id("app"): {"p1"}},
+			applied: applied{id("app"): {}},
+			want:    []string{"reconfigure app to app"},
+		},
+		{
+			name:    "other programs with the same prefix",
+			defs:    []programDef{def("app", "", 1)},
+			current: current{id("apple"): {"a1"}},
+			want:    []string{"stop fake.program.apple a1", "start app"},
+		},
+		{
+			name:    "stops, then upgrades, then starts",
+			defs:    []programDef{def("app", "v2", 2), def("db", "", 1)},
+			current: current{id("app:v1"): {"p1"}, id("cache"): {"c1"}},
+			want: []string{
+				"stop fake.program.cache c1",
+				"upgrade app:v1 to app:v2",
+				"start app:v2",
+				"start db",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var targets []target
+			for _, def := range tt.defs {
+				targets = append(targets, targetOf(cl, def))
+			}
+			var got []string
+			for _, act := range plan(targets, tt.current, tt.applied) {
+				got = append(got, act.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanWithoutTags(t *testing.T) {
+	provider := osprocess.Provider
+	id := provider.ProgramID("app")
+	def := programDef{Program: "app", Version: "v2", Instances: 2}
+
+	var got []string
+	for _, act := range plan([]target{targetOf(provider, def)}, map[container.ProgramID][]container.ProcessID{id: {"p1"}}, nil) {
+		got = append(got, act.String())
+	}
+	if want := []string{"start app"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want the version ignored, %q, got %q", want, got)
+	}
+}
+
+// waitRunning waits until an agent runs that many processes of a program.
+func waitRunning(t *testing.T, ta *testAgent, name string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := len(ta.agent.ListAll()[ta.client.ProgramID(name)])
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d processes of %s, got %d", want, name, got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAgentRunsItsStack(t *testing.T) {
+	define := func(instances int) Definition {
+		return Definition{
+			Agents: []agentName{"a"},
+			Machines: map[agentName]stack{
+				"a": {Programs: []programDef{{Program: "app", Instances: instances}}},
+			},
+		}
+	}
+	sup := newTestSupervisor(t, define(2))
+	ta := newTestAgent(t, "a")
+	ta.dial(sup)
+	waitRunning(t, ta, "app", 2)
+
+	sup.Redefine(define(1))
+	waitRunning(t, ta, "app", 1)
+}
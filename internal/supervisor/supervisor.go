@@ -1,65 +1,109 @@
 package supervisor
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"reflect"
 	"sync"
 
+	agentpkg "github.com/aybabtme/deployotron/internal/agent"
 	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/deployotron/internal/pki"
 	"github.com/aybabtme/deployotron/internal/rpc"
 	"github.com/aybabtme/log"
 )
 
 type program string
 
-type address string
+// agentName is how an agent introduced itself, see rpc.Hello.
+type agentName string
 
-func addressFromNet(addr net.Addr) address {
-	return address(addr.String())
-}
-
-// Definition defines what a bunch of machines should run.
+// Definition defines what a bunch of machines should run, by the name of
+// their agent. Agents lists every agent the definition knows of, and
+// Machines the stack of those that run something.
 type Definition struct {
-	Machines map[address]stack `json:"machines"`
+	Agents   []agentName         `json:"agents" yaml:"agents"`
+	Machines map[agentName]stack `json:"machines" yaml:"machines"`
 }
 
 type stack struct {
-	Programs []program `json:"programs"`
-}
-
-var testDefinition = &Definition{
-	Machines: map[address]stack{
-		"127.0.0.1:13371": {
-			Programs: []program{
-				"echoer program.1",
-				"echoer program.2",
-			},
-		},
-		"127.0.0.1:13372": {
-			Programs: []program{
-				"echoer program.2",
-				"echoer program.3",
-			},
-		},
-	},
+	Programs []programDef `json:"programs" yaml:"programs"`
+}
+
+// A programDef tells how many instances of a version of a program a machine
+// runs, with which spec, and how changes to them roll out: upgrades to
+// another version, or to another spec, follow Rollout.
+type programDef struct {
+	Program   program             `json:"program" yaml:"program"`
+	Version   string              `json:"version,omitempty" yaml:"version,omitempty"`
+	Instances int                 `json:"instances,omitempty" yaml:"instances,omitempty"` // 1 if not set
+	Spec      container.Spec      `json:"spec,omitempty" yaml:"spec,omitempty"`
+	Rollout   agentpkg.PolicySpec `json:"rollout,omitempty" yaml:"rollout,omitempty"`
+}
+
+// name of the version of the program, for backends that tag programs with
+// their version. Other backends run the program they have, whatever its
+// version.
+func (def programDef) name(provider container.ProgramProvider) string {
+	tagger, ok := provider.(container.ProgramTagger)
+	if !ok || def.Version == "" {
+		return string(def.Program)
+	}
+	return tagger.Tag(string(def.Program), def.Version)
+}
+
+func (def programDef) instances() int {
+	if def.Instances == 0 {
+		return 1
+	}
+	return def.Instances
 }
 
 // Supervisor tells a bunch of machines what to run, all the time.
 type Supervisor struct {
-	provider container.ProgramProvider
-	dfn      Definition
+	provider  container.ProgramProvider
+	agentOpts []rpc.AgentOption
 
 	mu     sync.Mutex
-	agents map[address]*agent
+	dfn    Definition
+	agents map[agentName]*agent
+}
+
+// An Option configures a Supervisor.
+type Option func(*Supervisor)
+
+// WithAgentOptions represents every agent with opts.
+func WithAgentOptions(opts ...rpc.AgentOption) Option {
+	return func(sup *Supervisor) { sup.agentOpts = append(sup.agentOpts, opts...) }
 }
 
 // DefineStack takes a definition and creates a supervisor that will make sure
 // the definition is applied on a bunch of machines.
-func DefineStack(dfn Definition, provider container.ProgramProvider) (*Supervisor, error) {
-	sup := &Supervisor{dfn: dfn, provider: provider}
+func DefineStack(dfn Definition, provider container.ProgramProvider, opts ...Option) (*Supervisor, error) {
+	sup := &Supervisor{dfn: dfn, provider: provider, agents: make(map[agentName]*agent)}
+	for _, opt := range opts {
+		opt(sup)
+	}
 	return sup, nil
 }
 
+// Redefine replaces the definition the supervisor applies. Agents stay
+// connected, and those whose stack changed converge to their new stack
+// right away.
+func (sup *Supervisor) Redefine(dfn Definition) {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	previous := sup.dfn
+	sup.dfn = dfn
+	for name, ag := range sup.agents {
+		if !reflect.DeepEqual(previous.Machines[name], dfn.Machines[name]) {
+			ag.ll.Info("stack of agent changed")
+			ag.enforceState()
+		}
+	}
+}
+
 // Listen makes the supervisor accept incoming agents from machines to supervise.
 func (sup *Supervisor) Listen(l net.Listener) error {
 	for {
@@ -67,68 +111,149 @@ func (sup *Supervisor) Listen(l net.Listener) error {
 		if err != nil {
 			return fmt.Errorf("accepting connections: %v", err)
 		}
-		sup.acceptAgent(cc)
+		go sup.acceptAgent(cc)
 	}
 }
 
 func (sup *Supervisor) acceptAgent(cc net.Conn) {
-	sup.mu.Lock()
-	defer sup.mu.Unlock()
-
-	raddr := addressFromNet(cc.RemoteAddr())
-	ll := log.KV("raddr", raddr)
+	ll := log.KV("raddr", cc.RemoteAddr().String())
 
-	if _, ok := sup.agents[raddr]; ok {
-		ll.Info("agent already joined")
+	link, err := sup.greet(cc)
+	if err != nil {
+		ll.Err(err).Error("rejecting agent")
 		_ = cc.Close()
 		return
 	}
-	ll.Info("new agent joined")
+	hello := link.Hello()
+	name := agentName(hello.Name)
+	ll = ll.KV("agent.name", name).
+		KV("agent.backend", hello.Backend).
+		KV("agent.version", hello.Version)
+
+	sup.mu.Lock()
+	previous, ok := sup.agents[name]
+	sup.mu.Unlock()
+
+	// welcoming the agent waits on it, so other agents shouldn't
+	if ok && previous.hello.Session == hello.Session && previous.client.Supports(rpc.FeatureResume) {
+		if err := previous.client.Resume(link); err != nil {
+			ll.Err(err).Error("agent couldn't resume its session")
+			_ = link.Close()
+		} else {
+			ll.Info("agent resumed its session")
+		}
+		return
+	}
+	client, err := rpc.RepresentAgent(link, sup.provider, sup.agentOpts...)
+	if err != nil {
+		ll.Err(err).Error("rejecting agent")
+		return
+	}
+	ll.KV("agent.protocol", client.Protocol()).Info("new agent joined")
 
 	agent := &agent{
 		ll:       ll,
-		addr:     raddr,
-		client:   rpc.RepresentAgent(cc, sup.provider),
-		cc:       cc,
-		setState: make(chan stack),
+		name:     name,
+		hello:    hello,
+		client:   client,
+		provider: sup.provider,
+		wake:     make(chan struct{}, 1),
+		applied:  make(map[container.ProgramID]container.Spec),
 	}
-	sup.agents[raddr] = agent
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	if previous, ok := sup.agents[name]; ok {
+		ll.Info("agent restarted, replacing it")
+		_ = previous.client.Close()
+	}
+	sup.agents[name] = agent
+	go sup.watch(agent)
 
-	if s, ok := sup.dfn.Machines[raddr]; !ok {
+	if !sup.dfn.knows(name) {
+		ll.Info("agent isn't listed in the definition")
+	} else if _, ok := sup.dfn.Machines[name]; !ok {
 		ll.Info("no stack defined for this agent")
-	} else {
-		go agent.enforceState(s)
 	}
+	go agent.logEvents()
+	go agent.maintainStateForever(sup.stackOf)
 }
 
-type agent struct {
-	ll     *log.Log
-	addr   address
-	cc     net.Conn
-	client rpc.RemoteAgent
+// stackOf returns the stack an agent should run, if it has one.
+func (sup *Supervisor) stackOf(name agentName) (stack, bool) {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	s, ok := sup.dfn.Machines[name]
+	return s, ok
+}
 
-	setState chan stack
+// greet waits for an agent to say hello. If the agent has a certificate,
+// it must be named after it.
+func (sup *Supervisor) greet(cc net.Conn) (*rpc.Link, error) {
+	certName, hasCert, err := pki.PeerIdentity(cc)
+	if err != nil {
+		return nil, err
+	}
+	link, err := rpc.Greet(cc)
+	if err != nil {
+		return nil, err
+	}
+	if name := link.Hello().Name; hasCert && name != certName {
+		return nil, fmt.Errorf("agent %q has a certificate for %q", name, certName)
+	}
+	return link, nil
 }
 
-func (ag *agent) enforceState(desired stack) {
-	ag.setState <- desired
+// watch forgets an agent once it's gone.
+func (sup *Supervisor) watch(ag *agent) {
+	<-ag.client.Done()
+	ag.ll.Err(ag.client.Err()).Info("agent is unreachable")
+
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	if sup.agents[ag.name] == ag {
+		delete(sup.agents, ag.name)
+	}
 }
 
-func (ag *agent) maintainStateForever(imDead func(addr address)) {
-	defer ag.cc.Close()
-	defer imDead(ag.addr)
-	for desiredState := range ag.setState {
-		actualState, err := ag.gatherCurrentState()
-		if err != nil {
-			ag.ll.Err(err).Error("failed to gather current state")
-			return
+// knows tells if an agent is listed in the definition.
+func (dfn Definition) knows(name agentName) bool {
+	for _, known := range dfn.Agents {
+		if known == name {
+			return true
 		}
-		_ = actualState
 	}
+	return false
+}
+
+type agent struct {
+	ll       *log.Log
+	name     agentName
+	hello    rpc.Hello
+	client   rpc.RemoteAgent
+	provider container.ProgramProvider
+
+	wake chan struct{} // signaled when its stack changed
+
+	// the spec the processes of each program run, as far as we know. Only
+	// maintainStateForever uses it.
+	applied map[container.ProgramID]container.Spec
 }
 
-func (ag *agent) gatherCurrentState() (stack, error) {
-	current := stack{}
-	// ag.client.ListAll()
-	return current, nil
+// logEvents subscribes to the events of the agent, and logs them until it's
+// gone.
+func (ag *agent) logEvents() {
+	if ag.client.Supports(rpc.FeatureEvents) {
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		_, err := ag.client.Subscribe(ctx, &rpc.SubscribeReq{})
+		cancel()
+		if err != nil {
+			ag.ll.Err(err).Error("couldn't subscribe to agent events")
+		}
+	}
+	for ev := range ag.client.Events() {
+		ag.ll.KV("event.kind", ev.Kind).
+			KV("program.id", ev.ProgramID).
+			KV("process.id", ev.ProcessID).
+			Info(ev.Message)
+	}
 }
@@ -0,0 +1,250 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	agentpkg "github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container"
+	"github.com/aybabtme/deployotron/internal/rpc"
+)
+
+// fakeClient creates processes that run until they're stopped.
+type fakeClient struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (cl *fakeClient) ProgramID(name string) container.ProgramID {
+	return container.ProgramID("fake.program." + name)
+}
+
+// Tag programs the way images are.
+func (cl *fakeClient) Tag(name, version string) string {
+	return name + ":" + version
+}
+
+func (cl *fakeClient) Programs() container.ProgramSvc  { return fakePrograms{} }
+func (cl *fakeClient) Processes() container.ProcessSvc { return cl }
+
+func (cl *fakeClient) Create(ctx context.Context, prgm container.Program, cfg container.ProcessConfig) (container.Process, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.next++
+	return &fakeProcess{id: container.ProcessID(fmt.Sprintf("fake.process.%d", cl.next)), prgm: prgm, exit: make(chan struct{})}, nil
+}
+
+func (cl *fakeClient) Remove(ctx context.Context, proc container.Process) error { return nil }
+
+type fakePrograms struct{}
+
+func (fakePrograms) Pull(ctx context.Context, id container.ProgramID) (container.Program, error) {
+	return fakeProgram(id), nil
+}
+
+func (fakePrograms) Get(ctx context.Context, id container.ProgramID) (container.Program, bool, error) {
+	return fakeProgram(id), true, nil
+}
+
+func (fakePrograms) Remove(ctx context.Context, id container.ProgramID) error { return nil }
+
+type fakeProgram container.ProgramID
+
+func (prgm fakeProgram) ID() container.ProgramID { return container.ProgramID(prgm) }
+
+type fakeProcess struct {
+	id   container.ProcessID
+	prgm container.Program
+	once sync.Once
+	exit chan struct{}
+}
+
+func (proc *fakeProcess) ID() container.ProcessID         { return proc.id }
+func (proc *fakeProcess) Program() container.Program      { return proc.prgm }
+func (proc *fakeProcess) Start(ctx context.Context) error { return nil }
+func (proc *fakeProcess) Kill() error                     { return proc.Stop(context.Background(), 0) }
+func (proc *fakeProcess) Signal(os.Signal) error          { return nil }
+func (proc *fakeProcess) Pause() error                    { return nil }
+func (proc *fakeProcess) Resume() error                   { return nil }
+
+func (proc *fakeProcess) Stop(ctx context.Context, timeout time.Duration) error {
+	proc.once.Do(func() { close(proc.exit) })
+	return nil
+}
+
+func (proc *fakeProcess) Wait() error {
+	<-proc.exit
+	return nil
+}
+
+// testAgent is an agent operated over pipes to a supervisor.
+type testAgent struct {
+	agent   *agentpkg.Agent
+	client  *fakeClient
+	session *rpc.Session
+}
+
+func newTestAgent(t *testing.T, name string) *testAgent {
+	t.Helper()
+	ta := &testAgent{client: &fakeClient{}}
+	ta.agent = agentpkg.New(ta.client)
+	session, err := rpc.NewSession(ta.agent, ta.client, rpc.Hello{Name: name, Backend: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta.session = session
+	t.Cleanup(func() {
+		session.Close()
+		for id := range ta.agent.ListAll() {
+			_ = ta.agent.StopProgram(context.Background(), id, time.Second)
+		}
+	})
+	return ta
+}
+
+// dial the supervisor, which accepts the agent over a new pipe.
+func (ta *testAgent) dial(sup *Supervisor) {
+	sc, ac := net.Pipe()
+	go sup.acceptAgent(sc)
+	go func() { _ = ta.session.Operate(ac) }()
+}
+
+func newTestSupervisor(t *testing.T, dfn Definition) *Supervisor {
+	t.Helper()
+	sup, err := DefineStack(dfn, &fakeClient{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sup.mu.Lock()
+		defer sup.mu.Unlock()
+		for _, ag := range sup.agents {
+			_ = ag.client.Close()
+		}
+	})
+	return sup
+}
+
+// waitAgent waits until the supervisor has an agent by that name, and
+// returns it.
+func waitAgent(t *testing.T, sup *Supervisor, name agentName, unless *agent) *agent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sup.mu.Lock()
+		ag, ok := sup.agents[name]
+		sup.mu.Unlock()
+		if ok && ag != unless {
+			return ag
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("agent %q never joined", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAcceptAgent(t *testing.T) {
+	sup := newTestSupervisor(t, Definition{Agents: []agentName{"a"}})
+	ta := newTestAgent(t, "a")
+	ta.dial(sup)
+	first := waitAgent(t, sup, "a", nil)
+	if first.hello.Backend != "fake" {
+		t.Errorf("want the agent's hello, got %+v", first.hello)
+	}
+
+	// the agent restarts, with a new session
+	restarted := newTestAgent(t, "a")
+	restarted.dial(sup)
+	second := waitAgent(t, sup, "a", first)
+	select {
+	case <-first.client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the agent it replaced closed")
+	}
+	if second.hello.Session == first.hello.Session {
+		t.Error("want the restarted agent's session")
+	}
+}
+
+func TestAcceptAgentDoesntWaitOnOthers(t *testing.T) {
+	sup := newTestSupervisor(t, Definition{})
+
+	// an agent that says hello, then never reads its welcome
+	stalled, ac := net.Pipe()
+	defer ac.Close()
+	go sup.acceptAgent(stalled)
+	hello, err := json.Marshal(rpc.Hello{Name: "stalled", Session: "s", Protocol: rpc.ProtocolVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.Write(append(hello, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the supervisor start welcoming it
+
+	redefined := make(chan struct{})
+	go func() {
+		sup.Redefine(Definition{Agents: []agentName{"a"}})
+		close(redefined)
+	}()
+	select {
+	case <-redefined:
+	case <-time.After(2 * time.Second):
+		t.Fatal("redefining waited on a stalled agent")
+	}
+	ta := newTestAgent(t, "a")
+	ta.dial(sup)
+	waitAgent(t, sup, "a", nil)
+}
+
+func TestAcceptAgentResumes(t *testing.T) {
+	sup := newTestSupervisor(t, Definition{Agents: []agentName{"a"}})
+	ta := newTestAgent(t, "a")
+	ta.dial(sup)
+	ag := waitAgent(t, sup, "a", nil)
+
+	// an agent resuming its session, that never reads its welcome
+	stalled, ac := net.Pipe()
+	defer ac.Close()
+	go sup.acceptAgent(stalled)
+	hello, err := json.Marshal(ag.hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.Write(append(hello, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the supervisor start resuming it
+
+	redefined := make(chan struct{})
+	go func() {
+		sup.Redefine(Definition{Agents: []agentName{"a"}})
+		close(redefined)
+	}()
+	select {
+	case <-redefined:
+	case <-time.After(2 * time.Second):
+		t.Fatal("redefining waited on a stalled agent")
+	}
+	_ = ac.Close()
+
+	ta.dial(sup)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ag.client.ListAll(ctx, &rpc.ListAllReq{}); err != nil {
+		t.Fatal(err)
+	}
+	sup.mu.Lock()
+	resumed := sup.agents["a"]
+	sup.mu.Unlock()
+	if resumed != ag {
+		t.Error("want the agent to resume its session, not replace it")
+	}
+}
@@ -2,9 +2,11 @@
 package main
 
 import (
+	"context"
 	"time"
 
 	"github.com/aybabtme/deployotron/internal/agent"
+	"github.com/aybabtme/deployotron/internal/container"
 	"github.com/aybabtme/deployotron/internal/container/osprocess"
 	// "github.com/aybabtme/deployotron/internal/container/docker"
 
@@ -35,20 +37,21 @@ func main() {
 	img := client.ProgramID("echoer v1")
 	ll = ll.KV("program.id", img)
 
+	ctx := context.Background()
 	ag := agent.New(client)
 	ll.Info("starting program")
-	if _, err := ag.StartProcess(img); err != nil {
+	if _, err := ag.StartProcess(ctx, img, container.Spec{}); err != nil {
 		ll.Err(err).Fatal("couldn't start image")
 	}
 	time.Sleep(3 * time.Second)
 
-	if _, err := ag.StartProcess(img); err != nil {
+	if _, err := ag.StartProcess(ctx, img, container.Spec{}); err != nil {
 		ll.Err(err).Fatal("couldn't start image")
 	}
 	time.Sleep(3 * time.Second)
 
 	ll.Info("restarting")
-	if err := ag.RestartProgram(policy, img); err != nil {
+	if err := ag.RestartProgram(ctx, policy, img); err != nil {
 		ll.Err(err).Fatal("couldn't restart image")
 	}
 
@@ -57,7 +60,7 @@ func main() {
 
 	newImg := client.ProgramID("echoer v2")
 	ll.Info("upgrading")
-	if err := ag.UpgradeProgram(policy, img, newImg); err != nil {
+	if err := ag.UpgradeProgram(ctx, policy, img, newImg); err != nil {
 		ll.Err(err).Fatal("couldn't restart image")
 	}
 
@@ -65,7 +68,7 @@ func main() {
 	time.Sleep(3 * time.Second)
 
 	ll.Info("stopping all processes")
-	if err := ag.StopProgram(newImg, 10*time.Second); err != nil {
+	if err := ag.StopProgram(ctx, newImg, 10*time.Second); err != nil {
 		ll.Err(err).Fatal("couldn't stop image")
 	}
 	ll.Info("all done!")
@@ -0,0 +1,299 @@
+// Command rpcgen writes the boilerplate of the RPC methods of an agent, from
+// the interface that lists them. For every method
+//
+//	MethodName(context.Context, *MethodNameReq) (*MethodNameRes, error)
+//
+// it writes the name the method is called by, registers the operator's
+// MethodName as the method's implementation, and writes the representant's
+// MethodName that calls it. A method annotated with
+//
+//	// rpc:stream ItemType
+//
+// is a streaming method instead: its result is a stream that receives
+// ItemType responses, which rpcgen writes too. A method annotated with
+//
+//	// rpc:role read-only|deployer|admin
+//
+// can be called by supervisors with that role or a higher one. Methods that
+// aren't annotated can only be called by admins.
+//
+// With -check, rpcgen writes nothing and fails if the generated code is
+// stale.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	streamAnnotation = "rpc:stream"
+	roleAnnotation   = "rpc:role"
+)
+
+// the constants of the roles methods can require
+var roles = map[string]string{
+	"read-only": "RoleReadOnly",
+	"deployer":  "RoleDeployer",
+	"admin":     "RoleAdmin",
+}
+
+func main() {
+	in := flag.String("in", "agent.go", "file declaring the interface of the methods")
+	out := flag.String("out", "agent_gen.go", "file to write the generated code to")
+	typeName := flag.String("type", "agentContract", "name of the interface of the methods")
+	prefix := flag.String("prefix", "rpc/agent.", "prefix of the names methods are called by")
+	check := flag.Bool("check", false, "fail if the generated code is stale, rather than writing it")
+	flag.Parse()
+
+	src, err := generate(*in, *typeName, *prefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rpcgen: %v\n", err)
+		os.Exit(1)
+	}
+	if *check {
+		current, err := ioutil.ReadFile(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rpcgen: %v\n", err)
+			os.Exit(1)
+		}
+		if !bytes.Equal(current, src) {
+			fmt.Fprintf(os.Stderr, "rpcgen: %s is stale, run go generate\n", *out)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "rpcgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type contract struct {
+	Source  string
+	Package string
+	Imports []string
+	Methods []method
+}
+
+type method struct {
+	Name     string
+	Called   string // the name it's called by
+	Req      string
+	Res      string
+	Stream   bool
+	ItemType string // of the responses of a stream
+	Role     string // the constant of the role it requires, if any
+}
+
+func generate(filename, typeName, prefix string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	iface, err := findInterface(file, typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	c := contract{Source: filepath.Base(filename), Package: file.Name.Name}
+	used := make(map[string]bool)
+	for _, field := range iface.Methods.List {
+		if len(field.Names) == 0 {
+			return nil, fmt.Errorf("%s: %s can't embed other interfaces", fset.Position(field.Pos()), typeName)
+		}
+		m, err := parseMethod(fset, field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fset.Position(field.Pos()), err)
+		}
+		m.Called = prefix + m.Name
+		if i := strings.Index(m.ItemType, "."); i > 0 {
+			used[m.ItemType[:i]] = true
+		}
+		c.Methods = append(c.Methods, m)
+	}
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if used[name] {
+			c.Imports = append(c.Imports, spec.Path.Value)
+			delete(used, name)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buf, c); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+func findInterface(file *ast.File, typeName string) (*ast.InterfaceType, error) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.Name.Name != typeName {
+				continue
+			}
+			iface, ok := ts.Type.(*ast.InterfaceType)
+			if !ok {
+				return nil, fmt.Errorf("%s isn't an interface", typeName)
+			}
+			if len(iface.Methods.List) == 0 {
+				return nil, fmt.Errorf("%s has no methods", typeName)
+			}
+			return iface, nil
+		}
+	}
+	return nil, fmt.Errorf("no interface named %s", typeName)
+}
+
+func parseMethod(fset *token.FileSet, field *ast.Field) (method, error) {
+	m := method{Name: field.Names[0].Name}
+	fn := field.Type.(*ast.FuncType)
+	if fn.Params.NumFields() != 2 || fn.Results.NumFields() != 2 {
+		return m, fmt.Errorf("%s must take a context and a request, and return a response and an error", m.Name)
+	}
+	if first := fn.Params.List[0].Type; expr(fset, first) != "context.Context" {
+		return m, fmt.Errorf("%s must take a context first", m.Name)
+	}
+	var err error
+	if m.Req, err = pointedName(fset, fn.Params.List[len(fn.Params.List)-1].Type); err != nil {
+		return m, fmt.Errorf("request of %s %v", m.Name, err)
+	}
+	if m.Res, err = pointedName(fset, fn.Results.List[0].Type); err != nil {
+		return m, fmt.Errorf("response of %s %v", m.Name, err)
+	}
+	if last := fn.Results.List[len(fn.Results.List)-1].Type; expr(fset, last) != "error" {
+		return m, fmt.Errorf("%s must return an error last", m.Name)
+	}
+	if field.Doc != nil {
+		for _, comment := range field.Doc.List {
+			text := strings.TrimSpace(strings.TrimPrefix(comment.Text, "//"))
+			switch {
+			case strings.HasPrefix(text, streamAnnotation):
+				m.Stream = true
+				m.ItemType = strings.TrimSpace(strings.TrimPrefix(text, streamAnnotation))
+				if m.ItemType == "" {
+					return m, fmt.Errorf("%s must tell the type of its stream's responses", m.Name)
+				}
+			case strings.HasPrefix(text, roleAnnotation):
+				role := strings.TrimSpace(strings.TrimPrefix(text, roleAnnotation))
+				if m.Role = roles[role]; m.Role == "" {
+					return m, fmt.Errorf("%s requires unknown role %q", m.Name, role)
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// pointedName returns the name of the type a pointer points to.
+func pointedName(fset *token.FileSet, e ast.Expr) (string, error) {
+	star, ok := e.(*ast.StarExpr)
+	if !ok {
+		return "", fmt.Errorf("must be a pointer, not %s", expr(fset, e))
+	}
+	ident, ok := star.X.(*ast.Ident)
+	if !ok {
+		return "", fmt.Errorf("must be a type of this package, not %s", expr(fset, star.X))
+	}
+	return ident.Name, nil
+}
+
+func expr(fset *token.FileSet, e ast.Expr) string {
+	buf := bytes.NewBuffer(nil)
+	_ = printer.Fprint(buf, fset, e)
+	return buf.String()
+}
+
+var tmpl = template.Must(template.New("contract").Parse(`// Code generated by rpcgen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+const (
+{{- range .Methods}}
+	method{{.Name}} = "{{.Called}}"
+{{- end}}
+)
+
+func init() {
+{{- range .Methods}}
+{{- if .Stream}}
+	rpcStreamContract[method{{.Name}}] = func(op *operator) (method streamCall, req interface{}) {
+		return op.{{.Name}}, new({{.Req}})
+	}
+{{- else}}
+	rpcContract[method{{.Name}}] = func(op *operator) (method methodCall, req interface{}) {
+		return op.{{.Name}}, new({{.Req}})
+	}
+{{- end}}
+{{- if .Role}}
+	rpcRoles[method{{.Name}}] = {{.Role}}
+{{- end}}
+{{- end}}
+}
+{{range .Methods}}
+{{- if .Stream}}
+// {{.Res}} receives the responses of a {{.Name}} call.
+type {{.Res}} struct {
+	stream *clientStream
+}
+
+// Recv the next response, or io.EOF once the stream ended.
+func (s *{{.Res}}) Recv() (*{{.ItemType}}, error) {
+	res := new({{.ItemType}})
+	if err := s.stream.recv(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Close the stream, which cancels the call.
+func (s *{{.Res}}) Close() error { return s.stream.close() }
+
+func (rep *representant) {{.Name}}(ctx context.Context, req *{{.Req}}) (*{{.Res}}, error) {
+	stream, err := rep.stream(ctx, method{{.Name}}, req)
+	if err != nil {
+		return nil, err
+	}
+	return &{{.Res}}{stream: stream}, nil
+}
+{{else}}
+func (rep *representant) {{.Name}}(ctx context.Context, req *{{.Req}}) (*{{.Res}}, error) {
+	res := new({{.Res}})
+	return res, rep.call(ctx, method{{.Name}}, req, res)
+}
+{{end}}
+{{- end}}`))